@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/dsaidgovsg/registrywatcher/client"
+	"github.com/dsaidgovsg/registrywatcher/log"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+func (h *Handler) GetPendingDeploymentsHandler(c *gin.Context) {
+	repoName := c.Query("repo_name")
+	if !h.isWatchedOrGlobal(repoName) {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: Repo %s is not being watched", repoName),
+		})
+		return
+	}
+
+	pending, err := h.clients.PostgresClient.GetPendingDeployments(c.Request.Context(), repoName)
+	if err != nil {
+		log.LogAppErr("Couldn't fetch pending deployments", err)
+		c.JSON(500, gin.H{
+			"message": fmt.Sprintf("Unable to fetch pending deployments, err: %s", err),
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"pending": pending,
+	})
+}
+
+func (h *Handler) ApprovePendingDeploymentHandler(c *gin.Context) {
+	h.decidePendingDeployment(c, "approve", h.clients.ApprovePendingDeployment)
+}
+
+func (h *Handler) RejectPendingDeploymentHandler(c *gin.Context) {
+	h.decidePendingDeployment(c, "reject", h.clients.RejectPendingDeployment)
+}
+
+func (h *Handler) decidePendingDeployment(c *gin.Context, action string,
+	decide func(ctx context.Context, conf *viper.Viper, id int64, decidedBy string) (client.PendingDeploymentRow, error)) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{
+			"message": "Error: id must be an integer",
+		})
+		return
+	}
+	pending, err := decide(c.Request.Context(), h.conf, id, requester(c))
+	if err != nil {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: Failed to %s deployment %d, %s", action, id, err),
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": fmt.Sprintf("Deployment of tag %s for %s is %s", pending.Tag, pending.RepositoryName, pending.Status),
+	})
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dsaidgovsg/registrywatcher/log"
+	"github.com/dsaidgovsg/registrywatcher/notifier"
+	"github.com/dsaidgovsg/registrywatcher/utils"
+	"github.com/spf13/viper"
+)
+
+// Records that the watcher found tag of repoName to deploy, so that it is
+// only deployed once approved, within the repo's approval_timeout
+func (client *Clients) RequestApproval(ctx context.Context, conf *viper.Viper, repoName, tag string, trigger DeployTrigger) (PendingDeploymentRow, error) {
+	timeout, err := utils.GetRepoApprovalTimeout(conf, repoName)
+	if err != nil {
+		return PendingDeploymentRow{}, err
+	}
+	now := time.Now()
+	pending := PendingDeploymentRow{
+		RepositoryName: repoName,
+		Tag:            tag,
+		Trigger:        string(trigger),
+		Status:         PendingDeploymentPending,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(timeout),
+	}
+	// the digest is informational, so a failure to fetch it is not fatal
+	if digest, err := client.getTagDigest(ctx, repoName, tag); err == nil {
+		pending.Digest = digest
+	}
+	pending.ID, err = client.PostgresClient.InsertPendingDeployment(ctx, pending)
+	return pending, err
+}
+
+/*
+ * Deploys the pending deployment with id on behalf of approver. It must
+ * still be pending and not expired, and its repository must still resolve
+ * to the same tag and digest, since its pinned tag or version constraint
+ * may have changed, or the tag may have been pushed again, while waiting. Approving is a manual deployment, so like
+ * deployments through the API it ignores deployment windows, and it is
+ * recorded with the manual trigger.
+ */
+func (client *Clients) ApprovePendingDeployment(ctx context.Context, conf *viper.Viper, id int64, approver string) (PendingDeploymentRow, error) {
+	pending, err := client.PostgresClient.GetPendingDeployment(ctx, id)
+	if err != nil {
+		return pending, err
+	}
+	if pending.Status != PendingDeploymentPending {
+		return pending, fmt.Errorf("deployment %d is already %s", id, pending.Status)
+	}
+	now := time.Now()
+	if !now.Before(pending.ExpiresAt) {
+		return pending, fmt.Errorf("deployment %d expired at %s", id, pending.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	tag, err := client.GetFormattedPinnedTag(ctx, pending.RepositoryName)
+	if err != nil {
+		return pending, err
+	}
+	if tag != pending.Tag {
+		return pending, fmt.Errorf("%s now resolves to tag %s instead of %s", pending.RepositoryName, tag, pending.Tag)
+	}
+	// the watcher requests another approval when the tag is pushed again
+	if pending.Digest != "" {
+		digest, err := client.getTagDigest(ctx, pending.RepositoryName, pending.Tag)
+		if err != nil {
+			return pending, err
+		}
+		if digest != pending.Digest {
+			return pending, fmt.Errorf("tag %s of %s was pushed again since deployment %d was requested", pending.Tag, pending.RepositoryName, id)
+		}
+	}
+
+	if err = client.decidePendingDeployment(ctx, &pending, PendingDeploymentApproved, approver, now); err != nil {
+		return pending, err
+	}
+	log.LogAppInfo(fmt.Sprintf("Deployment %d of tag %s for repo %s approved by %s", id, pending.Tag, pending.RepositoryName, approver))
+	data := pending.EventData()
+	data.Requester = approver
+	notifier.PostEvent(conf, notifier.EventApproved, data)
+	client.deployPinnedTag(ctx, conf, DeployRequest{
+		RepoName:  pending.RepositoryName,
+		Trigger:   DeployTriggerManual,
+		Requester: approver,
+		Reason:    fmt.Sprintf("approved %s deployment %d", pending.Trigger, id),
+	})
+	return pending, nil
+}
+
+// Drops the pending deployment with id on behalf of rejecter. The watcher
+// won't ask again for the same tag and digest.
+func (client *Clients) RejectPendingDeployment(ctx context.Context, conf *viper.Viper, id int64, rejecter string) (PendingDeploymentRow, error) {
+	pending, err := client.PostgresClient.GetPendingDeployment(ctx, id)
+	if err != nil {
+		return pending, err
+	}
+	if pending.Status != PendingDeploymentPending {
+		return pending, fmt.Errorf("deployment %d is already %s", id, pending.Status)
+	}
+	if err = client.decidePendingDeployment(ctx, &pending, PendingDeploymentRejected, rejecter, time.Now()); err != nil {
+		return pending, err
+	}
+	log.LogAppInfo(fmt.Sprintf("Deployment %d of tag %s for repo %s rejected by %s", id, pending.Tag, pending.RepositoryName, rejecter))
+	data := pending.EventData()
+	data.Requester = rejecter
+	notifier.PostEvent(conf, notifier.EventRejected, data)
+	return pending, nil
+}
+
+// What the notifications about the pending deployment can refer to
+func (pending PendingDeploymentRow) EventData() notifier.EventData {
+	return notifier.EventData{
+		Repo:      pending.RepositoryName,
+		Tag:       pending.Tag,
+		Digest:    pending.Digest,
+		Trigger:   pending.Trigger,
+		PendingID: pending.ID,
+		ExpiresAt: pending.ExpiresAt.UTC().Format(time.RFC3339),
+	}
+}
+
+func (client *Clients) decidePendingDeployment(ctx context.Context, pending *PendingDeploymentRow, status, decidedBy string, decidedAt time.Time) error {
+	decided, err := client.PostgresClient.DecidePendingDeployment(ctx, pending.ID, status, decidedBy, decidedAt)
+	if err != nil {
+		return err
+	}
+	// someone else decided it, or it expired, since it was fetched
+	if !decided {
+		return fmt.Errorf("deployment %d is no longer pending", pending.ID)
+	}
+	pending.Status, pending.DecidedBy, pending.DecidedAt = status, decidedBy, &decidedAt
+	return nil
+}
@@ -1,13 +1,17 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"sort"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/dsaidgovsg/registrywatcher/log"
+	"github.com/dsaidgovsg/registrywatcher/notifier"
+	"github.com/dsaidgovsg/registrywatcher/registry"
 	"github.com/dsaidgovsg/registrywatcher/utils"
 	nomad "github.com/hashicorp/nomad/api"
 	"github.com/hashicorp/nomad/testutil"
@@ -15,12 +19,27 @@ import (
 )
 
 type Clients struct {
+	// deployer backends keyed by the deployer setting in repo_map
+	Deployers            map[string]Deployer
 	NomadClient          *NomadClient
 	DockerRegistryClient *DockerRegistryClient
 	PostgresClient       *PostgresClient
 	DockerhubApi         *DockerhubApi
 	DockerTags           sync.Map
 	DigestMap            sync.Map
+	// the tag whose digest is cached in DigestMap, keyed by repository name
+	digestTags sync.Map
+	// metadata of the image of each repository's pinned tag, see GetCachedImage
+	ImageMap sync.Map
+	// tag policies keyed by repository name
+	TagPolicies map[string]*utils.TagPolicy
+	conf        *viper.Viper
+
+	// deployments running in the background, whose rollouts are
+	// monitored until deployCtx is cancelled by Shutdown
+	deploys      sync.WaitGroup
+	deployCtx    context.Context
+	cancelDeploy context.CancelFunc
 
 	// for test usage only
 	NomadServer *testutil.TestServer
@@ -32,9 +51,24 @@ func SetUpClients(conf *viper.Viper) *Clients {
 		panic(fmt.Errorf("starting postgres client failed: %v", err))
 	}
 	dockerClient := InitializeDockerRegistryClient(conf)
-	dockerhubApi, err := InitializeDockerhubApi(conf)
+	// the Dockerhub API is only used as a fallback for digest lookups
+	var dockerhubApi *DockerhubApi
+	if conf.GetString("dockerhub_url") != "" {
+		dockerhubApi, err = InitializeDockerhubApi(conf)
+		if err != nil {
+			log.LogAppErr("error initializing dockerhub API client", err)
+		}
+	}
+
+	deployers, err := InitializeDeployers(conf)
 	if err != nil {
-		log.LogAppErr("error initializing dockerhub API client", err)
+		panic(fmt.Errorf("starting deployers failed: %v", err))
+	}
+	nomadClient, _ := deployers[NomadDeployer].(*NomadClient)
+
+	tagPolicies, err := InitializeTagPolicies(conf)
+	if err != nil {
+		panic(fmt.Errorf("reading tag policies failed: %v", err))
 	}
 
 	// caching fields
@@ -45,13 +79,17 @@ func SetUpClients(conf *viper.Viper) *Clients {
 		digestMap.Store(repoName, "")
 	}
 	clients := Clients{
-		NomadClient:          InitializeNomadClient(conf),
+		Deployers:            deployers,
+		NomadClient:          nomadClient,
 		PostgresClient:       postgresClient,
 		DockerRegistryClient: dockerClient,
 		DockerhubApi:         dockerhubApi,
 		DockerTags:           dockerTags,
 		DigestMap:            digestMap,
+		TagPolicies:          tagPolicies,
+		conf:                 conf,
 	}
+	clients.deployCtx, clients.cancelDeploy = context.WithCancel(context.Background())
 	return &clients
 }
 
@@ -77,6 +115,11 @@ func SetUpTestClients(t *testing.T, conf *viper.Viper) *Clients {
 		conf: conf,
 	}
 
+	tagPolicies, err := InitializeTagPolicies(conf)
+	if err != nil {
+		panic(fmt.Errorf("reading tag policies failed: %v", err))
+	}
+
 	// caching fields
 	dockerTags := sync.Map{}
 	digestMap := sync.Map{}
@@ -86,6 +129,7 @@ func SetUpTestClients(t *testing.T, conf *viper.Viper) *Clients {
 	}
 
 	clients := Clients{
+		Deployers:            map[string]Deployer{NomadDeployer: &nc},
 		NomadClient:          &nc,
 		NomadServer:          ns,
 		PostgresClient:       postgresClient,
@@ -93,10 +137,34 @@ func SetUpTestClients(t *testing.T, conf *viper.Viper) *Clients {
 		DockerhubApi:         nil,
 		DockerTags:           dockerTags,
 		DigestMap:            digestMap,
+		TagPolicies:          tagPolicies,
+		conf:                 conf,
 	}
+	clients.deployCtx, clients.cancelDeploy = context.WithCancel(context.Background())
 	return &clients
 }
 
+// Reads the tag policy of every watched repository
+func InitializeTagPolicies(conf *viper.Viper) (map[string]*utils.TagPolicy, error) {
+	policies := map[string]*utils.TagPolicy{}
+	for _, repoName := range conf.GetStringSlice("watched_repositories") {
+		policy, err := utils.GetRepoTagPolicy(conf, repoName)
+		if err != nil {
+			return nil, err
+		}
+		policies[repoName] = policy
+	}
+	return policies, nil
+}
+
+// Returns the tag policy of repoName, the default semver policy if it has none
+func (client *Clients) GetTagPolicy(repoName string) *utils.TagPolicy {
+	if policy, ok := client.TagPolicies[repoName]; ok {
+		return policy
+	}
+	return &utils.TagPolicy{}
+}
+
 func (client *Clients) GetCachedTags(repoName string) ([]string, error) {
 	rtn, ok := client.DockerTags.Load(repoName)
 	if !ok {
@@ -128,8 +196,8 @@ func (client *Clients) GetCachedTagDigest(repoName string) (string, error) {
 }
 
 // fetches the CACHED pinned tag
-func (client *Clients) GetFormattedPinnedTag(repoName string) (string, error) {
-	pinnedTag, err := client.PostgresClient.GetPinnedTag(repoName)
+func (client *Clients) GetFormattedPinnedTag(ctx context.Context, repoName string) (string, error) {
+	pinnedTag, err := client.PostgresClient.GetPinnedTag(ctx, repoName)
 	if err != nil {
 		return "", err
 	}
@@ -138,49 +206,332 @@ func (client *Clients) GetFormattedPinnedTag(repoName string) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		pinnedTag, err = utils.GetLatestReleaseTag(tags)
+		pinnedTag, err = client.GetLatestReleaseTag(ctx, repoName, tags)
 	}
 	return pinnedTag, err
 }
 
-func (client *Clients) DeployPinnedTag(conf *viper.Viper, repoName string) {
-	pinnedTag, err := client.GetFormattedPinnedTag(repoName)
+// Returns the tag policy of repoName restricted to its stored version constraint, if any
+func (client *Clients) GetReleaseTagPolicy(ctx context.Context, repoName string) (*utils.TagPolicy, error) {
+	policy := client.GetTagPolicy(repoName)
+	versionConstraint, err := client.PostgresClient.GetVersionConstraint(ctx, repoName)
+	if err != nil || versionConstraint == "" {
+		return policy, err
+	}
+	constraint, err := utils.ParseVersionConstraint(versionConstraint)
+	if err != nil {
+		return nil, err
+	}
+	return policy.WithConstraint(constraint), nil
+}
+
+// Returns the latest of tags that repoName can auto deploy
+func (client *Clients) GetLatestReleaseTag(ctx context.Context, repoName string, tags []string) (string, error) {
+	policy, err := client.GetReleaseTagPolicy(ctx, repoName)
+	if err != nil {
+		return "", err
+	}
+	return utils.GetLatestReleaseTag(tags, policy)
+}
+
+// Returns the deployer backend configured for repoName
+func (client *Clients) GetDeployer(repoName string) (Deployer, error) {
+	name := utils.GetRepoDeployer(client.conf, repoName)
+	deployer, ok := client.Deployers[name]
+	if !ok {
+		return nil, fmt.Errorf("deployer %s for repo %s is not initialized", name, repoName)
+	}
+	return deployer, nil
+}
+
+func (client *Clients) DeployPinnedTag(ctx context.Context, conf *viper.Viper, repoName string, trigger DeployTrigger, requester string) {
+	client.deployPinnedTag(ctx, conf, DeployRequest{
+		RepoName:  repoName,
+		Trigger:   trigger,
+		Requester: requester,
+	})
+}
+
+// Deploys the pinned tag of request.RepoName in the background. ctx is only
+// used to look up the tag, the rollout is monitored until Shutdown.
+func (client *Clients) deployPinnedTag(ctx context.Context, conf *viper.Viper, request DeployRequest) {
+	repoName := request.RepoName
+	pinnedTag, err := client.GetFormattedPinnedTag(ctx, repoName)
 	if err != nil {
 		log.LogAppErr(fmt.Sprintf("Couldn't fetch pinned tag while deploying pinned tag for %s", repoName), err)
 		return
 	}
-	jobID := utils.GetRepoNomadJob(conf, repoName)
-	taskName := utils.GetRepoNomadTaskName(conf, repoName)
-	client.NomadClient.UpdateNomadJobTag(jobID, repoName, taskName, pinnedTag)
+	request.Tag = pinnedTag
+	deployer, err := client.GetDeployer(repoName)
+	if err != nil {
+		log.LogAppErr(fmt.Sprintf("Couldn't get deployer while deploying pinned tag for %s", repoName), err)
+		historyID := client.recordDeploymentStart(request)
+		client.recordDeploymentEnd(historyID, request, DeploymentOutcome{Status: DeploymentFailed, Description: err.Error()})
+		notifier.PostEvent(conf, notifier.EventFailed, deployEventData(request, fmt.Sprintf("`%s`", repoName), err.Error()))
+		return
+	}
+	// any deployment of the pinned tag fulfils a deferred or pending auto deployment
+	if err = client.PostgresClient.UpdateDeferredTrigger(ctx, repoName, ""); err != nil {
+		log.LogAppErr(fmt.Sprintf("Couldn't clear deferred deployment of %s", repoName), err)
+	}
+	if err = client.PostgresClient.SupersedePendingDeployments(ctx, repoName); err != nil {
+		log.LogAppErr(fmt.Sprintf("Couldn't supersede pending deployments of %s", repoName), err)
+	}
+	client.deploys.Add(1)
+	go func() {
+		defer client.deploys.Done()
+		client.deploy(client.deployCtx, conf, deployer, request)
+	}()
 	// update after deploying new sha, so it will not trigger autodeployment
-	client.updateCaches(repoName)
+	client.updateCaches(ctx, repoName)
+	// and on the other replicas, so that the leader doesn't deploy it again
+	if conf.GetBool("leader_election") {
+		if err = client.PostgresClient.NotifyDeploy(ctx, repoName); err != nil {
+			log.LogAppErr(fmt.Sprintf("Couldn't notify other replicas of the deployment of %s", repoName), err)
+		}
+	}
+}
+
+// Deploys tag, records the deployment in the history table and
+// posts a slack update on the outcome of the rollout
+func (client *Clients) deploy(ctx context.Context, conf *viper.Viper, deployer Deployer, request DeployRequest) {
+	repoName, tag := request.RepoName, request.Tag
+	// the previous tag and digest are informational, so a failure to fetch them is not fatal
+	if previousTag, err := deployer.GetDeployedTag(ctx, repoName); err == nil {
+		request.PreviousTag = previousTag
+	}
+	if digest, err := client.getTagDigest(ctx, repoName, tag); err == nil {
+		request.Digest = digest
+	}
+	historyID := client.recordDeploymentStart(request)
+
+	deployment, err := deployer.Deploy(ctx, request)
+	if err != nil {
+		log.LogAppErr(fmt.Sprintf("Failed to deploy tag %s for %s", tag, repoName), err)
+		client.recordDeploymentEnd(historyID, request, DeploymentOutcome{Status: DeploymentFailed, Description: err.Error()})
+		notifier.PostEvent(conf, notifier.EventFailed, deployEventData(request, fmt.Sprintf("`%s`", repoName), err.Error()))
+		client.autoRollback(ctx, conf, request, err.Error())
+		return
+	}
+	if request.Trigger == DeployTriggerAuto || request.Trigger == DeployTriggerDigestChange {
+		// the watcher deployed it on its own, so offer to undo it
+		notifier.PostEvent(conf, notifier.EventStarted, deployEventData(request, deployment.Target, ""),
+			utils.SlackActionRollback, utils.SlackActionPause)
+	} else {
+		notifier.PostEvent(conf, notifier.EventStarted, deployEventData(request, deployment.Target, ""))
+	}
+
+	outcome := deployer.WaitForDeployment(ctx, deployment)
+	client.recordDeploymentEnd(historyID, request, outcome)
+	switch outcome.Status {
+	case DeploymentSuccessful:
+		notifier.PostEvent(conf, notifier.EventSucceeded, deployEventData(request, deployment.Target, outcome.Description))
+	case DeploymentFailed:
+		notifier.PostEvent(conf, notifier.EventFailed, deployEventData(request, deployment.Target, outcome.Description))
+	default:
+		notifier.PostEvent(conf, notifier.EventUnmonitored, deployEventData(request, deployment.Target, outcome.Description))
+	}
+	if outcome.Status != DeploymentSuccessful && outcome.Status != DeploymentInterrupted {
+		client.autoRollback(ctx, conf, request, fmt.Sprintf("deployment %s", outcome.Status))
+	}
+}
+
+// What the notification templates of request's deployment events can refer to
+func deployEventData(request DeployRequest, target, description string) notifier.EventData {
+	return notifier.EventData{
+		Repo:        request.RepoName,
+		Tag:         request.Tag,
+		PreviousTag: request.PreviousTag,
+		Digest:      request.Digest,
+		Target:      target,
+		Trigger:     string(request.Trigger),
+		Requester:   request.Requester,
+		Description: description,
+	}
+}
+
+// Rolls repoName back to its last successful deployment after a failed
+// deployment, if the repo's auto_rollback policy is turned on. Deployments
+// that are rollbacks themselves are not rolled back, to avoid looping.
+func (client *Clients) autoRollback(ctx context.Context, conf *viper.Viper, request DeployRequest, cause string) {
+	repoName := request.RepoName
+	if !utils.GetRepoAutoRollback(conf, repoName) || request.Trigger == DeployTriggerRollback {
+		return
+	}
+	entry, err := client.PostgresClient.GetLastSuccessfulDeployment(ctx, repoName, request.Tag)
+	if err != nil {
+		log.LogAppErr(fmt.Sprintf("Couldn't find a tag to automatically roll back to for %s", repoName), err)
+		notifier.PostError(conf, repoName, fmt.Sprintf("Error: couldn't automatically roll back `%s`, no earlier successful deployment found", repoName))
+		return
+	}
+	reason := fmt.Sprintf("automatic rollback from tag %s: %s", request.Tag, cause)
+	if err = client.RollbackToTag(ctx, conf, repoName, entry.ToTag, "", reason); err != nil {
+		log.LogAppErr(fmt.Sprintf("Couldn't automatically roll back %s to tag %s", repoName, entry.ToTag), err)
+		notifier.PostError(conf, repoName, fmt.Sprintf("Error: couldn't automatically roll back `%s` to tag `%s`: %s", repoName, entry.ToTag, err))
+	}
+}
+
+// Adds a pending row to the deployment history and returns its ID,
+// or 0 if it couldn't be recorded. History is best effort and never blocks a deployment.
+// It is recorded without a context so interrupted deployments are still written during shutdown.
+func (client *Clients) recordDeploymentStart(request DeployRequest) int64 {
+	id, err := client.PostgresClient.InsertDeploymentHistory(context.Background(), DeploymentHistoryRow{
+		RepositoryName: request.RepoName,
+		FromTag:        request.PreviousTag,
+		ToTag:          request.Tag,
+		Digest:         request.Digest,
+		Trigger:        string(request.Trigger),
+		Requester:      request.Requester,
+		StartedAt:      time.Now(),
+		Outcome:        string(DeploymentPending),
+		Description:    request.Reason,
+	})
+	if err != nil {
+		log.LogAppErr(fmt.Sprintf("Couldn't record deployment history for %s", request.RepoName), err)
+		return 0
+	}
+	return id
+}
+
+func (client *Clients) recordDeploymentEnd(historyID int64, request DeployRequest, outcome DeploymentOutcome) {
+	if historyID == 0 {
+		return
+	}
+	description := outcome.Description
+	if request.Reason != "" && description != "" {
+		description = fmt.Sprintf("%s; %s", request.Reason, description)
+	} else if request.Reason != "" {
+		description = request.Reason
+	}
+	err := client.PostgresClient.FinishDeploymentHistory(context.Background(), historyID, string(outcome.Status), description, time.Now())
+	if err != nil {
+		log.LogAppErr("Couldn't record deployment outcome", err)
+	}
+}
+
+// Returns the tag to roll repoName back to. If historyID is 0, this is the
+// last successfully deployed tag that is not the current pinned tag value,
+// otherwise it is the tag deployed by that history entry.
+func (client *Clients) GetRollbackTag(ctx context.Context, repoName string, historyID int64) (string, error) {
+	if historyID != 0 {
+		entry, err := client.PostgresClient.GetDeploymentHistoryEntry(ctx, historyID)
+		if err != nil {
+			return "", err
+		}
+		if entry.RepositoryName != repoName {
+			return "", fmt.Errorf("deployment history entry %d is not for repo %s", historyID, repoName)
+		}
+		return entry.ToTag, nil
+	}
+	currentTag, err := client.GetFormattedPinnedTag(ctx, repoName)
+	if err != nil {
+		return "", err
+	}
+	entry, err := client.PostgresClient.GetLastSuccessfulDeployment(ctx, repoName, currentTag)
+	if err != nil {
+		return "", err
+	}
+	return entry.ToTag, nil
+}
+
+// Pins repoName to tag with auto deployment turned off, so the watcher
+// doesn't roll forward again, and deploys it. reason is recorded in the deployment history.
+func (client *Clients) RollbackToTag(ctx context.Context, conf *viper.Viper, repoName, tag, requester, reason string) error {
+	tags, err := client.DockerRegistryClient.GetAllTags(ctx, repoName)
+	if err != nil || !utils.IsTagDeployable(tag, tags) {
+		return fmt.Errorf("tag %s is no longer inside the docker repository registry %s", tag, repoName)
+	}
+	if err = client.PostgresClient.UpdateAutoDeployFlag(ctx, repoName, false); err != nil {
+		return err
+	}
+	originalTag, err := client.PostgresClient.GetPinnedTag(ctx, repoName)
+	if err != nil {
+		return err
+	}
+	if err = client.PostgresClient.UpdatePinnedTag(ctx, repoName, tag); err != nil {
+		_ = client.PostgresClient.UpdatePinnedTag(ctx, repoName, originalTag)
+		return err
+	}
+	log.LogAppInfo(fmt.Sprintf("Rolling back repo %s from pinned_tag %s to %s", repoName, originalTag, tag))
+	notifier.PostEvent(conf, notifier.EventRolledBack, notifier.EventData{
+		Repo:        repoName,
+		Tag:         tag,
+		PreviousTag: originalTag,
+		Trigger:     string(DeployTriggerRollback),
+		Requester:   requester,
+		Description: reason,
+	})
+	client.deployPinnedTag(ctx, conf, DeployRequest{
+		RepoName:  repoName,
+		Trigger:   DeployTriggerRollback,
+		Requester: requester,
+		Reason:    reason,
+	})
+	return nil
+}
+
+/*
+ * Waits for the deployments running in the background to finish. If ctx is
+ * done first, their rollouts stop being monitored and are recorded as
+ * interrupted. Deployments must not be started after Shutdown is called.
+ */
+func (client *Clients) Shutdown(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		client.deploys.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		client.cancelDeploy()
+		<-done
+	}
 }
 
-func (client *Clients) PopulateCaches(repoName string) {
+func (client *Clients) PopulateCaches(ctx context.Context, repoName string) {
 	// populate tags
-	tags, err := client.getSHATags(repoName)
+	tags, err := client.getSHATags(ctx, repoName)
 	if err != nil {
 		log.LogAppErr(fmt.Sprintf("Couldn't fetch docker tags from registry while populating cache for %s", repoName), err)
 		return
 	}
-	validTags := utils.FilterSHATags(tags)
-	client.updateTagsCache(repoName, validTags)
+	client.updateTagsCache(repoName, tags)
 
 	// populate digest
-	pinnedTag, err := client.GetFormattedPinnedTag(repoName)
+	pinnedTag, err := client.GetFormattedPinnedTag(ctx, repoName)
 	if err != nil {
 		log.LogAppErr(fmt.Sprintf("Couldn't fetch pinned tag while populating cache for %s", repoName), err)
 	}
-	tagDigest, err := client.DockerhubApi.GetTagDigestFromApi(repoName, pinnedTag)
+	tagDigest, err := client.getTagDigest(ctx, repoName, pinnedTag)
 	if err != nil {
-		log.LogAppErr(fmt.Sprintf("Couldn't fetch tag digest from Dockerhub while populating cache for %s", repoName), err)
+		log.LogAppErr(fmt.Sprintf("Couldn't fetch tag digest while populating cache for %s", repoName), err)
 		return
 	}
-	client.updateDigestCache(repoName, *tagDigest)
+	client.updateDigestCache(repoName, pinnedTag, tagDigest)
+	client.updateImageCache(ctx, repoName, pinnedTag)
+}
+
+// fetches the digest of tag from the docker registry, falling back
+// to the Dockerhub API if it is configured and the registry lookup fails.
+// There is no fallback for repos with a platform, since the Dockerhub API
+// only knows the digest of the manifest list, and caching it would look
+// like a changed digest on the next successful lookup.
+func (client *Clients) getTagDigest(ctx context.Context, repoName, tag string) (string, error) {
+	digest, err := client.DockerRegistryClient.GetTagDigest(ctx, repoName, tag)
+	if err == nil || client.DockerhubApi == nil || utils.GetRepoPlatform(client.conf, repoName) != "" {
+		return digest, err
+	}
+	log.LogAppWarn(fmt.Sprintf("Couldn't fetch tag digest from registry for %s, falling back to Dockerhub API", repoName), err)
+	hubDigest, hubErr := client.DockerhubApi.GetTagDigestFromApi(ctx, repoName, tag)
+	if hubErr != nil {
+		return "", hubErr
+	}
+	return *hubDigest, nil
 }
 
-func (client *Clients) isNewReleaseTagAvailable(repoName string) bool {
-	registryTags, err := client.getSHATags(repoName)
+func (client *Clients) isNewReleaseTagAvailable(ctx context.Context, repoName string) bool {
+	registryTags, err := client.getSHATags(ctx, repoName)
 	if err != nil {
 		log.LogAppErr(fmt.Sprintf("Couldn't fetch docker tags from registry checking if new release available for for %s", repoName), err)
 		return false
@@ -200,19 +551,24 @@ func (client *Clients) isNewReleaseTagAvailable(repoName string) bool {
 	}
 
 	// a new versioned tag doesn't necessarily mean it's the latest
-	latestTagOld, err1 := utils.GetLatestReleaseTag(cachedTags)
+	policy, err := client.GetReleaseTagPolicy(ctx, repoName)
+	if err != nil {
+		log.LogAppErr(fmt.Sprintf("Couldn't fetch tag policy while checking if new release available for %s", repoName), err)
+		return false
+	}
+	latestTagOld, err1 := utils.GetLatestReleaseTag(cachedTags, policy)
 	if err1 != nil {
 		log.LogAppErr(fmt.Sprintf("Couldn't fetch latest tag from registry while checking if new release available for %s", repoName), err)
 		return false
 	}
 
-	latestTagNew, err2 := utils.GetLatestReleaseTag(registryTags)
+	latestTagNew, err2 := utils.GetLatestReleaseTag(registryTags, policy)
 	if err2 != nil {
 		log.LogAppErr(fmt.Sprintf("Couldn't fetch latest tag from cache while checking if new release available for for %s", repoName), err)
 		return false
 	}
 
-	if utils.TagToNumber(latestTagNew) > utils.TagToNumber(latestTagOld) {
+	if policy.Compare(latestTagNew, latestTagOld) > 0 {
 		return true
 	}
 
@@ -220,12 +576,12 @@ func (client *Clients) isNewReleaseTagAvailable(repoName string) bool {
 }
 
 // fetches from docker registry
-func (client *Clients) getSHATags(repoName string) ([]string, error) {
-	tags, err := client.DockerRegistryClient.GetAllTags(repoName)
+func (client *Clients) getSHATags(ctx context.Context, repoName string) ([]string, error) {
+	tags, err := client.DockerRegistryClient.GetAllTags(ctx, repoName)
 	if len(tags) == 0 {
 		return []string{}, err
 	}
-	validTags := utils.FilterSHATags(tags)
+	validTags := utils.FilterSHATags(tags, client.GetTagPolicy(repoName))
 	sort.Strings(validTags)
 	return validTags, nil
 }
@@ -234,110 +590,162 @@ func (client *Clients) updateTagsCache(repoName string, tags []string) {
 	client.DockerTags.Store(repoName, tags)
 }
 
-func (client *Clients) updateDigestCache(repoName string, digest string) {
+func (client *Clients) updateDigestCache(repoName, tag, digest string) {
 	client.DigestMap.Store(repoName, digest)
+	client.digestTags.Store(repoName, tag)
+}
+
+// The image metadata cached for the image with digest
+type cachedImage struct {
+	digest string
+	image  *registry.Image
 }
 
-func (client *Clients) isPinnedTagDeployed(conf *viper.Viper, repoName string) (bool, error) {
-	jobID := utils.GetRepoNomadJob(conf, repoName)
-	deployedTag, err := client.NomadClient.GetNomadJobTag(jobID, repoName)
+// Returns the cached metadata of the image of repoName's pinned tag,
+// nil if it couldn't be fetched yet
+func (client *Clients) GetCachedImage(repoName string) *registry.Image {
+	cached, ok := client.ImageMap.Load(repoName)
+	if !ok {
+		return nil
+	}
+	return cached.(cachedImage).image
+}
+
+// Fetches the metadata of the image of pinnedTag, unless it is already
+// cached for the cached digest, since an image with the same digest doesn't change
+func (client *Clients) updateImageCache(ctx context.Context, repoName, pinnedTag string) {
+	digest, err := client.GetCachedTagDigest(repoName)
+	if err != nil || digest == "" {
+		return
+	}
+	if cached, ok := client.ImageMap.Load(repoName); ok && cached.(cachedImage).digest == digest {
+		return
+	}
+	// the metadata is informational, so a failure is retried at the next update
+	image, err := client.DockerRegistryClient.GetImage(ctx, repoName, pinnedTag)
+	if err != nil {
+		log.LogAppWarn(fmt.Sprintf("Couldn't fetch image of tag %s while updating image cache for %s", pinnedTag, repoName), err)
+		return
+	}
+	client.ImageMap.Store(repoName, cachedImage{digest: digest, image: image})
+}
+
+// Whether the pinned tag is what is deployed. For repos deployed by digest,
+// the digests are compared, so an overwritten tag counts as not deployed.
+func (client *Clients) isPinnedTagDeployed(ctx context.Context, repoName string) (bool, error) {
+	deployer, err := client.GetDeployer(repoName)
 	if err != nil {
-		log.LogAppErr(fmt.Sprintf("Couldn't fetch nomad job tag while checking deployed tag for %s", repoName), err)
 		return false, err
 	}
-	pinnedTag, err := client.GetFormattedPinnedTag(repoName)
+	pinnedTag, err := client.GetFormattedPinnedTag(ctx, repoName)
 	if err != nil {
 		log.LogAppErr(fmt.Sprintf("Couldn't fetch pinned tag while checking deployed tag for %s", repoName), err)
 		return false, err
 	}
+	return client.isTagDeployed(ctx, deployer, repoName, pinnedTag)
+}
+
+func (client *Clients) isTagDeployed(ctx context.Context, deployer Deployer, repoName, pinnedTag string) (bool, error) {
+	if digestDeployer, ok := deployer.(DigestDeployer); ok && utils.GetRepoDeployByDigest(client.conf, repoName) {
+		deployedDigest, err := digestDeployer.GetDeployedDigest(ctx, repoName)
+		if err != nil {
+			log.LogAppErr(fmt.Sprintf("Couldn't fetch deployed digest while checking deployed tag for %s", repoName), err)
+			return false, err
+		}
+		pinnedDigest, err := client.getTagDigest(ctx, repoName, pinnedTag)
+		if err != nil {
+			log.LogAppErr(fmt.Sprintf("Couldn't fetch pinned tag digest while checking deployed tag for %s", repoName), err)
+			return false, err
+		}
+		return deployedDigest == pinnedDigest, nil
+	}
+	deployedTag, err := deployer.GetDeployedTag(ctx, repoName)
+	if err != nil {
+		log.LogAppErr(fmt.Sprintf("Couldn't fetch deployed tag while checking deployed tag for %s", repoName), err)
+		return false, err
+	}
 	return deployedTag == pinnedTag, nil
 }
 
-func (client *Clients) isTagDigestChanged(repoName string) (bool, error) {
-	pinnedTag, err := client.GetFormattedPinnedTag(repoName)
+func (client *Clients) isTagDigestChanged(ctx context.Context, repoName string) (bool, error) {
+	pinnedTag, err := client.GetFormattedPinnedTag(ctx, repoName)
 	if err != nil {
 		log.LogAppErr(fmt.Sprintf("Couldn't fetch pinned tag while checking if it was changed for %s", repoName), err)
 		return false, err
 	}
+	// the pinned tag changed since its digest was cached, e.g. because it was
+	// deployed through another replica, which is not a change of its digest
+	if cachedTag, ok := client.digestTags.Load(repoName); ok && cachedTag != pinnedTag {
+		return false, nil
+	}
 	cachedTagDigest, err := client.GetCachedTagDigest(repoName)
 	if err != nil {
 		log.LogAppErr(fmt.Sprintf("Couldn't fetch tag digest from cache while checking if it was changed for %s", repoName), err)
 		return false, err
 	}
-	digestIsCurrent, err := client.DockerhubApi.CheckImageIsCurrent(repoName, cachedTagDigest, pinnedTag)
+	tagDigest, err := client.getTagDigest(ctx, repoName, pinnedTag)
 	if err != nil {
 		log.LogAppErr("Couldn't check if tag currently points to cached image digest", err)
 		return false, err
 	}
 
-	return !*digestIsCurrent, nil
+	return tagDigest != cachedTagDigest, nil
 }
 
-func (client *Clients) updateCaches(repoName string) {
-	validTags, err := client.getSHATags(repoName)
+func (client *Clients) updateCaches(ctx context.Context, repoName string) {
+	validTags, err := client.getSHATags(ctx, repoName)
 	if err != nil {
 		log.LogAppErr(fmt.Sprintf("Couldn't fetch tags from registry while updating cache for %s", repoName), err)
 		return
 	}
 	client.updateTagsCache(repoName, validTags)
 
-	isDigestChanged, err := client.isTagDigestChanged(repoName)
+	pinnedTag, err := client.GetFormattedPinnedTag(ctx, repoName)
 	if err != nil {
-		log.LogAppErr(fmt.Sprintf("Couldn't check tag digest changed while updating cache for %s", repoName), err)
+		log.LogAppErr(fmt.Sprintf("Couldn't fetch pinned tag while updating cache for %s", repoName), err)
 		return
 	}
-	if isDigestChanged {
-		pinnedTag, err := client.GetFormattedPinnedTag(repoName)
-		if err != nil {
-			log.LogAppErr(fmt.Sprintf("Couldn't fetch pinned tag while updating cache for %s", repoName), err)
-			return
-		}
-		tagDigest, err := client.DockerhubApi.GetTagDigestFromApi(repoName, pinnedTag)
-		if err != nil {
-			log.LogAppErr(fmt.Sprintf("Couldn't fetch tag digest from registry while updating cache for %s", repoName), err)
-			return
-		}
-
-		// log update
-		cachedTagDigest, err := client.GetCachedTagDigest(repoName)
-		if err != nil {
-			log.LogAppErr(fmt.Sprintf("Couldn't fetch tag digest from cache while updating cache for %s", repoName), err)
-			return
-		}
-		log.LogAppInfo(fmt.Sprintf("cached digest: %s, new digest: %s", cachedTagDigest, *tagDigest))
-
-		client.updateDigestCache(repoName, *tagDigest)
+	tagDigest, err := client.getTagDigest(ctx, repoName, pinnedTag)
+	if err != nil {
+		log.LogAppErr(fmt.Sprintf("Couldn't fetch tag digest from registry while updating cache for %s", repoName), err)
+		return
+	}
+	// log update
+	if cachedTagDigest, _ := client.GetCachedTagDigest(repoName); cachedTagDigest != tagDigest {
+		log.LogAppInfo(fmt.Sprintf("cached digest: %s, new digest: %s", cachedTagDigest, tagDigest))
 	}
+	client.updateDigestCache(repoName, pinnedTag, tagDigest)
+	client.updateImageCache(ctx, repoName, pinnedTag)
 }
 
 // this function compares cached values with the actual values,
 // so only update the cache before returning non-error cases
-func (client *Clients) ShouldDeploy(repoName string) (bool, error) {
-	autoDeploy, err := client.PostgresClient.GetAutoDeployFlag(repoName)
+func (client *Clients) ShouldDeploy(ctx context.Context, repoName string) (bool, error) {
+	autoDeploy, err := client.PostgresClient.GetAutoDeployFlag(ctx, repoName)
 	if err != nil {
 		log.LogAppErr(fmt.Sprintf("Couldn't fetch whether to deploy flag while checking whether to deploy for %s", repoName), err)
 		return false, err
 	}
 	if !autoDeploy {
-		client.updateCaches(repoName)
+		client.updateCaches(ctx, repoName)
 		return false, nil
 	}
 
-	pinnedTag, err := client.PostgresClient.GetPinnedTag(repoName)
+	pinnedTag, err := client.PostgresClient.GetPinnedTag(ctx, repoName)
 	if err != nil {
 		log.LogAppErr(fmt.Sprintf("Couldn't fetch pinned tag while checking whether to deploy for %s", repoName), err)
 		return false, err
 	}
-	isDigestChanged, err := client.isTagDigestChanged(repoName)
+	isDigestChanged, err := client.isTagDigestChanged(ctx, repoName)
 	if err != nil {
 		log.LogAppErr(fmt.Sprintf("Couldn't check tag digest changed while checking whether to deploy for %s", repoName), err)
 		return false, err
 	}
 
-	if (pinnedTag == "" && client.isNewReleaseTagAvailable(repoName)) || isDigestChanged {
-		client.updateCaches(repoName)
+	if (pinnedTag == "" && client.isNewReleaseTagAvailable(ctx, repoName)) || isDigestChanged {
+		client.updateCaches(ctx, repoName)
 		return true, nil
 	}
-	client.updateCaches(repoName)
+	client.updateCaches(ctx, repoName)
 	return false, nil
 }
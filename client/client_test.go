@@ -3,7 +3,10 @@
 package client
 
 import (
+	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -22,7 +25,7 @@ func TestAutoDeployLatestTag(t *testing.T) {
 	}
 
 	// update the cache with digests
-	te.Clients.PopulateCaches(te.TestRepoName)
+	te.Clients.PopulateCaches(context.Background(), te.TestRepoName)
 
 	/*
 		resolves correctly for autodeployment of latest
@@ -32,8 +35,8 @@ func TestAutoDeployLatestTag(t *testing.T) {
 	newTag := "v0.0.2"
 	te.PushNewTag(newTag, "latest")
 
-	shouldDeploy, _ := te.Clients.ShouldDeploy(te.TestRepoName)
-	tagToDeploy, _ := te.Clients.GetFormattedPinnedTag(te.TestRepoName)
+	shouldDeploy, _ := te.Clients.ShouldDeploy(context.Background(), te.TestRepoName)
+	tagToDeploy, _ := te.Clients.GetFormattedPinnedTag(context.Background(), te.TestRepoName)
 	// v0.0.2 is not a new release version
 	assert.False(t, shouldDeploy)
 	assert.Equal(t, tagToDeploy, "v0.1.0")
@@ -42,8 +45,8 @@ func TestAutoDeployLatestTag(t *testing.T) {
 	newTag = "v0.2.0"
 	te.PushNewTag(newTag, "latest")
 
-	shouldDeploy, _ = te.Clients.ShouldDeploy(te.TestRepoName)
-	tagToDeploy, _ = te.Clients.GetFormattedPinnedTag(te.TestRepoName)
+	shouldDeploy, _ = te.Clients.ShouldDeploy(context.Background(), te.TestRepoName)
+	tagToDeploy, _ = te.Clients.GetFormattedPinnedTag(context.Background(), te.TestRepoName)
 	// v0.2.0 is a new release version,
 	assert.True(t, shouldDeploy)
 	assert.Equal(t, tagToDeploy, newTag)
@@ -52,8 +55,8 @@ func TestAutoDeployLatestTag(t *testing.T) {
 	newTag = "v0.0.9"
 	te.PushNewTag(newTag, "latest")
 
-	shouldDeploy, _ = te.Clients.ShouldDeploy(te.TestRepoName)
-	tagToDeploy, _ = te.Clients.GetFormattedPinnedTag(te.TestRepoName)
+	shouldDeploy, _ = te.Clients.ShouldDeploy(context.Background(), te.TestRepoName)
+	tagToDeploy, _ = te.Clients.GetFormattedPinnedTag(context.Background(), te.TestRepoName)
 	// v0.0.9 is not a new release version
 	assert.False(t, shouldDeploy)
 	assert.Equal(t, tagToDeploy, "v0.2.0")
@@ -66,8 +69,8 @@ func TestAutoDeployLatestTag(t *testing.T) {
 	newTag = "test"
 	te.UpdatePinnedTag(newTag)
 
-	shouldDeploy, _ = te.Clients.ShouldDeploy(te.TestRepoName)
-	tagToDeploy, _ = te.Clients.GetFormattedPinnedTag(te.TestRepoName)
+	shouldDeploy, _ = te.Clients.ShouldDeploy(context.Background(), te.TestRepoName)
+	tagToDeploy, _ = te.Clients.GetFormattedPinnedTag(context.Background(), te.TestRepoName)
 	// should not deploy as all tags are based on the digest "latest", even though
 	// the pinned tag is changed
 	assert.False(t, shouldDeploy)
@@ -76,18 +79,146 @@ func TestAutoDeployLatestTag(t *testing.T) {
 	// "test" is now based on "alpine", rather than the original "latest"
 	te.PushNewTag(newTag, "alpine")
 
-	shouldDeploy, _ = te.Clients.ShouldDeploy(te.TestRepoName)
-	tagToDeploy, _ = te.Clients.GetFormattedPinnedTag(te.TestRepoName)
+	shouldDeploy, _ = te.Clients.ShouldDeploy(context.Background(), te.TestRepoName)
+	tagToDeploy, _ = te.Clients.GetFormattedPinnedTag(context.Background(), te.TestRepoName)
 	assert.True(t, shouldDeploy)
 	assert.Equal(t, "test", tagToDeploy)
 
 	// "test" is back to "latest", but autoDeploy is off
-	_ = te.Clients.PostgresClient.UpdateAutoDeployFlag(te.TestRepoName, false)
+	_ = te.Clients.PostgresClient.UpdateAutoDeployFlag(context.Background(), te.TestRepoName, false)
 	te.PushNewTag(newTag, "alpine")
 
-	shouldDeploy, _ = te.Clients.ShouldDeploy(te.TestRepoName)
-	autoDeploy, _ := te.Clients.PostgresClient.GetAutoDeployFlag(te.TestRepoName)
+	shouldDeploy, _ = te.Clients.ShouldDeploy(context.Background(), te.TestRepoName)
+	autoDeploy, _ := te.Clients.PostgresClient.GetAutoDeployFlag(context.Background(), te.TestRepoName)
 	assert.False(t, autoDeploy)
 	assert.False(t, shouldDeploy)
 	assert.Equal(t, "test", tagToDeploy)
 }
+
+/*
+Test that failed deployments are rolled back to the last successful one,
+unless auto_rollback is off or the deployment is a rollback itself.
+*/
+func TestAutoRollback(t *testing.T) {
+	te := SetUpClientTest(t)
+	defer te.TearDown()
+	ctx := context.Background()
+
+	tags := []string{"v1.0.0", "v1.1.0"}
+	for _, tag := range tags {
+		te.PushNewTag(tag, "latest")
+	}
+	_, err := te.Clients.PostgresClient.InsertDeploymentHistory(ctx, DeploymentHistoryRow{
+		RepositoryName: te.TestRepoName,
+		ToTag:          "v1.0.0",
+		Trigger:        string(DeployTriggerManual),
+		StartedAt:      time.Now().Add(-time.Hour),
+		Outcome:        string(DeploymentSuccessful),
+	})
+	assert.Nil(t, err)
+	te.UpdatePinnedTag("v1.1.0")
+	failed := DeployRequest{RepoName: te.TestRepoName, Tag: "v1.1.0", Trigger: DeployTriggerAuto}
+
+	// auto_rollback is off by default
+	te.Clients.autoRollback(ctx, te.Conf, failed, "deployment failed")
+	tag, _ := te.Clients.PostgresClient.GetPinnedTag(ctx, te.TestRepoName)
+	assert.Equal(t, "v1.1.0", tag)
+
+	// rollbacks are never rolled back, to avoid looping
+	te.Conf.Set(fmt.Sprintf("repo_map.%s.auto_rollback", te.TestRepoName), true)
+	rollback := failed
+	rollback.Trigger = DeployTriggerRollback
+	te.Clients.autoRollback(ctx, te.Conf, rollback, "deployment failed")
+	tag, _ = te.Clients.PostgresClient.GetPinnedTag(ctx, te.TestRepoName)
+	assert.Equal(t, "v1.1.0", tag)
+	autoDeploy, _ := te.Clients.PostgresClient.GetAutoDeployFlag(ctx, te.TestRepoName)
+	assert.True(t, autoDeploy)
+
+	te.Clients.autoRollback(ctx, te.Conf, failed, "deployment failed")
+	tag, _ = te.Clients.PostgresClient.GetPinnedTag(ctx, te.TestRepoName)
+	assert.Equal(t, "v1.0.0", tag)
+	autoDeploy, _ = te.Clients.PostgresClient.GetAutoDeployFlag(ctx, te.TestRepoName)
+	assert.False(t, autoDeploy)
+
+	// let the rollback deployment finish before the containers are removed
+	shutdownCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	te.Clients.Shutdown(shutdownCtx)
+}
+
+/*
+Test that the pushes and deployments notified by one replica reach the
+listening replicas.
+*/
+func TestNotifyReplicas(t *testing.T) {
+	te := SetUpClientTest(t)
+	defer te.TearDown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pushed := make(chan string, 1)
+	deployed := make(chan string, 1)
+	listening := make(chan error, 1)
+	go func() {
+		listening <- te.Clients.PostgresClient.ListenForReplicas(ctx, func(repoName string) {
+			pushed <- repoName
+		}, func(repoName string) {
+			deployed <- repoName
+		})
+	}()
+	// give the listener time to LISTEN, notifications sent before that are lost
+	time.Sleep(time.Second)
+
+	assert.Nil(t, te.Clients.PostgresClient.NotifyPush(context.Background(), te.TestRepoName))
+	select {
+	case repoName := <-pushed:
+		assert.Equal(t, te.TestRepoName, repoName)
+	case <-deployed:
+		t.Fatal("forwarded push was received as a deployment")
+	case <-time.After(10 * time.Second):
+		t.Fatal("forwarded push was not received")
+	}
+
+	assert.Nil(t, te.Clients.PostgresClient.NotifyDeploy(context.Background(), te.TestRepoName))
+	select {
+	case repoName := <-deployed:
+		assert.Equal(t, te.TestRepoName, repoName)
+	case <-pushed:
+		t.Fatal("deployment was received as a push")
+	case <-time.After(10 * time.Second):
+		t.Fatal("deployment was not received")
+	}
+
+	cancel()
+	assert.Nil(t, <-listening)
+}
+
+/*
+Test that only one session at a time holds an advisory lock.
+*/
+func TestTryAdvisoryLock(t *testing.T) {
+	te := SetUpClientTest(t)
+	defer te.TearDown()
+
+	ctx := context.Background()
+	lock, err := te.Clients.PostgresClient.TryAdvisoryLock(ctx, "registrywatcher/test")
+	assert.Nil(t, err)
+	assert.NotNil(t, lock)
+	assert.True(t, lock.IsHeld(ctx))
+
+	// taken by another connection, like another replica's
+	other, err := te.Clients.PostgresClient.TryAdvisoryLock(ctx, "registrywatcher/test")
+	assert.Nil(t, err)
+	assert.Nil(t, other)
+
+	// other locks are independent
+	unrelated, err := te.Clients.PostgresClient.TryAdvisoryLock(ctx, "registrywatcher/other")
+	assert.Nil(t, err)
+	assert.NotNil(t, unrelated)
+	assert.Nil(t, unrelated.Release())
+
+	assert.Nil(t, lock.Release())
+	other, err = te.Clients.PostgresClient.TryAdvisoryLock(ctx, "registrywatcher/test")
+	assert.Nil(t, err)
+	assert.NotNil(t, other)
+	assert.Nil(t, other.Release())
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dsaidgovsg/registrywatcher/utils"
+	"github.com/spf13/viper"
+)
+
+const NomadDeployer = "nomad"
+
+// How long deployers monitor a rollout before giving up on it
+const defaultDeploymentTimeout = 1200 * time.Second
+
+type DeploymentStatus string
+
+const (
+	DeploymentSuccessful DeploymentStatus = "successful"
+	DeploymentFailed     DeploymentStatus = "failed"
+	// the deployer gave up monitoring before the rollout finished
+	DeploymentTimedOut DeploymentStatus = "timed_out"
+	// the rollout has started and its outcome is not known yet
+	DeploymentPending DeploymentStatus = "pending"
+	// registrywatcher shut down before the rollout finished
+	DeploymentInterrupted DeploymentStatus = "interrupted"
+)
+
+// What caused a deployment
+type DeployTrigger string
+
+const (
+	// requested through the API
+	DeployTriggerManual DeployTrigger = "manual"
+	// a newer release tag was pushed
+	DeployTriggerAuto DeployTrigger = "auto"
+	// the pinned tag was pushed again with a different digest
+	DeployTriggerDigestChange DeployTrigger = "digest-change"
+	// the repo was rolled back to an earlier deployment
+	DeployTriggerRollback DeployTrigger = "rollback"
+)
+
+// What a Deployer is asked to roll out
+type DeployRequest struct {
+	RepoName string
+	Tag      string
+	// tag deployed before this rollout, empty if unknown
+	PreviousTag string
+	// manifest digest Tag currently points to, empty if unknown
+	Digest  string
+	Trigger DeployTrigger
+	// who asked for the rollout, empty for automatic deployments
+	Requester string
+	// why the rollout was requested, recorded in the deployment history
+	Reason string
+}
+
+// A rollout started by a Deployer
+type Deployment struct {
+	RepoName string
+	Tag      string
+	// human readable name of what is being deployed, e.g. "Nomad job `registrywatcher`"
+	Target string
+	// identifies the rollout to the deployer, e.g. the Nomad evaluation ID
+	ID string
+}
+
+type DeploymentOutcome struct {
+	Status DeploymentStatus
+	// additional detail reported by the deployer, may be empty
+	Description string
+}
+
+// Deployer updates the image of a watched repository on a runtime
+type Deployer interface {
+	// Returns the tag of repoName's image that is currently deployed
+	GetDeployedTag(ctx context.Context, repoName string) (string, error)
+	// Starts the rollout and returns without waiting for it to finish
+	Deploy(ctx context.Context, request DeployRequest) (*Deployment, error)
+	// Blocks until the rollout finishes or the deployer stops monitoring it.
+	// If ctx is done first, the outcome is DeploymentInterrupted.
+	WaitForDeployment(ctx context.Context, deployment *Deployment) DeploymentOutcome
+}
+
+// Implemented by deployers that support the deploy_by_digest repo setting
+type DigestDeployer interface {
+	// Returns the manifest digest of repoName's deployed image, empty if it is deployed by tag
+	GetDeployedDigest(ctx context.Context, repoName string) (string, error)
+}
+
+// Constructors for each supported deployer backend, keyed by the
+// value of the deployer setting in repo_map
+var deployerConstructors = map[string]func(conf *viper.Viper) (Deployer, error){
+	NomadDeployer: func(conf *viper.Viper) (Deployer, error) {
+		return InitializeNomadClient(conf), nil
+	},
+	KubernetesDeployer: func(conf *viper.Viper) (Deployer, error) {
+		return InitializeKubernetesClient(conf)
+	},
+	DockerDeployer: func(conf *viper.Viper) (Deployer, error) {
+		return InitializeDockerEngineClient(conf)
+	},
+	WebhookDeployer: func(conf *viper.Viper) (Deployer, error) {
+		return InitializeWebhookClient(conf), nil
+	},
+}
+
+// Initializes the deployer backends used by the watched repositories
+func InitializeDeployers(conf *viper.Viper) (map[string]Deployer, error) {
+	deployers := map[string]Deployer{}
+	for _, repoName := range conf.GetStringSlice("watched_repositories") {
+		name := utils.GetRepoDeployer(conf, repoName)
+		deployer, ok := deployers[name]
+		if !ok {
+			constructor, ok := deployerConstructors[name]
+			if !ok {
+				return nil, fmt.Errorf("unsupported deployer %s for repo %s", name, repoName)
+			}
+			var err error
+			if deployer, err = constructor(conf); err != nil {
+				return nil, fmt.Errorf("starting %s deployer failed: %v", name, err)
+			}
+			deployers[name] = deployer
+		}
+		if name == NomadDeployer {
+			if _, err := utils.GetRepoNomadJob(conf, repoName); err != nil {
+				return nil, err
+			}
+			if _, err := utils.GetRepoNomadTaskName(conf, repoName); err != nil {
+				return nil, err
+			}
+		}
+		// other deployers would silently deploy by tag
+		if _, ok := deployer.(DigestDeployer); !ok && utils.GetRepoDeployByDigest(conf, repoName) {
+			return nil, fmt.Errorf("deploy_by_digest is not supported by the %s deployer of repo %s", name, repoName)
+		}
+	}
+	return deployers, nil
+}
@@ -0,0 +1,33 @@
+//go:build unit
+// +build unit
+
+package client
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitializeDeployersRejectsDeployByDigest(t *testing.T) {
+	conf := viper.New()
+	conf.Set("watched_repositories", []string{"api", "web"})
+	conf.Set("repo_map.api.deployer", WebhookDeployer)
+	conf.Set("repo_map.web.deployer", WebhookDeployer)
+	deployers, err := InitializeDeployers(conf)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(deployers))
+
+	conf.Set("repo_map.web.deploy_by_digest", true)
+	_, err = InitializeDeployers(conf)
+	assert.EqualError(t, err, "deploy_by_digest is not supported by the webhook deployer of repo web")
+}
+
+func TestInitializeDeployersRequiresNomadJob(t *testing.T) {
+	conf := viper.New()
+	conf.Set("watched_repositories", []string{"api"})
+	conf.Set("repo_map.api.nomad_task_name", "api")
+	_, err := InitializeDeployers(conf)
+	assert.EqualError(t, err, "nomad_job_name is not set for repo api")
+}
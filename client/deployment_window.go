@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dsaidgovsg/registrywatcher/utils"
+)
+
+// Checks that the cron schedule and timezone of a deployment window are valid
+func ValidateDeploymentWindow(window DeploymentWindowRow) error {
+	if _, err := utils.ParseCronSchedule(window.Schedule); err != nil {
+		return err
+	}
+	if _, err := time.LoadLocation(window.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %s: %v", window.Timezone, err)
+	}
+	return nil
+}
+
+/*
+ * Returns whether repoName can be auto deployed at t, and if not, why.
+ * Auto deployments are blocked during a freeze of repoName or of every
+ * repository. If repoName has deployment windows of its own, it can only be
+ * deployed inside one of them, otherwise the global windows apply. Without
+ * any windows it can be deployed at any time.
+ */
+func (client *Clients) CheckDeploymentWindow(ctx context.Context, repoName string, t time.Time) (bool, string, error) {
+	freezes, err := client.PostgresClient.GetDeploymentFreezes(ctx, repoName, t)
+	if err != nil {
+		return false, "", err
+	}
+	windows, err := client.PostgresClient.GetDeploymentWindows(ctx, repoName)
+	if err != nil {
+		return false, "", err
+	}
+	allowed, reason := isDeploymentAllowed(repoName, windows, freezes, t)
+	return allowed, reason, nil
+}
+
+func isDeploymentAllowed(repoName string, windows []DeploymentWindowRow, freezes []DeploymentFreezeRow, t time.Time) (bool, string) {
+	for _, freeze := range freezes {
+		if (freeze.RepositoryName == "" || freeze.RepositoryName == repoName) &&
+			!t.Before(freeze.StartsAt) && t.Before(freeze.EndsAt) {
+			reason := fmt.Sprintf("change freeze until %s", freeze.EndsAt.UTC().Format(time.RFC3339))
+			if freeze.Reason != "" {
+				reason = fmt.Sprintf("%s (%s)", reason, freeze.Reason)
+			}
+			return false, reason
+		}
+	}
+
+	// the repository's own windows replace the global ones
+	applicable := []DeploymentWindowRow{}
+	for _, window := range windows {
+		if window.RepositoryName == repoName {
+			applicable = append(applicable, window)
+		}
+	}
+	if len(applicable) == 0 {
+		for _, window := range windows {
+			if window.RepositoryName == "" {
+				applicable = append(applicable, window)
+			}
+		}
+	}
+	if len(applicable) == 0 {
+		return true, ""
+	}
+
+	for _, window := range applicable {
+		schedule, err := utils.ParseCronSchedule(window.Schedule)
+		if err != nil {
+			continue
+		}
+		loc, err := time.LoadLocation(window.Timezone)
+		if err != nil {
+			continue
+		}
+		if schedule.Matches(t.In(loc)) {
+			return true, ""
+		}
+	}
+	return false, "outside of the deployment windows"
+}
@@ -0,0 +1,44 @@
+//go:build unit
+// +build unit
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsDeploymentAllowed(t *testing.T) {
+	// a Friday, 18:00 in Singapore
+	now := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+	businessHours := DeploymentWindowRow{Schedule: "* 9-17 * * 1-5", Timezone: "Asia/Singapore"}
+	mornings := DeploymentWindowRow{RepositoryName: "api", Schedule: "* 6-11 * * *", Timezone: "UTC"}
+
+	allowed, _ := isDeploymentAllowed("web", nil, nil, now)
+	assert.True(t, allowed)
+
+	allowed, reason := isDeploymentAllowed("web", []DeploymentWindowRow{businessHours}, nil, now)
+	assert.False(t, allowed)
+	assert.Equal(t, "outside of the deployment windows", reason)
+
+	// the repository's own window replaces the global one
+	allowed, _ = isDeploymentAllowed("api", []DeploymentWindowRow{businessHours, mornings}, nil, now)
+	assert.True(t, allowed)
+	allowed, _ = isDeploymentAllowed("api", []DeploymentWindowRow{businessHours, mornings}, nil, now.Add(2*time.Hour))
+	assert.False(t, allowed)
+
+	freeze := DeploymentFreezeRow{
+		StartsAt: now.Add(-time.Hour),
+		EndsAt:   now.Add(time.Hour),
+		Reason:   "year end",
+	}
+	allowed, reason = isDeploymentAllowed("api", []DeploymentWindowRow{mornings}, []DeploymentFreezeRow{freeze}, now)
+	assert.False(t, allowed)
+	assert.Equal(t, "change freeze until 2026-10-16T11:00:00Z (year end)", reason)
+
+	freeze.RepositoryName = "web"
+	allowed, _ = isDeploymentAllowed("api", []DeploymentWindowRow{mornings}, []DeploymentFreezeRow{freeze}, now)
+	assert.True(t, allowed)
+}
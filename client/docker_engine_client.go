@@ -0,0 +1,408 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	docker "github.com/docker/docker/client"
+	"github.com/dsaidgovsg/registrywatcher/log"
+	"github.com/dsaidgovsg/registrywatcher/utils"
+	"github.com/spf13/viper"
+)
+
+const DockerDeployer = "docker"
+
+const composeServiceLabel = "com.docker.compose.service"
+const composeProjectLabel = "com.docker.compose.project"
+
+// Deploys to containers on a single Docker Engine host by recreating
+// them with the new image
+type DockerEngineClient struct {
+	dc           docker.APIClient
+	conf         *viper.Viper
+	pollInterval time.Duration
+	timeout      time.Duration
+}
+
+// Connects to the Docker Engine with the standard DOCKER_HOST,
+// DOCKER_API_VERSION, DOCKER_CERT_PATH and DOCKER_TLS_VERIFY environment
+// variables, defaulting to the local socket
+func InitializeDockerEngineClient(conf *viper.Viper) (*DockerEngineClient, error) {
+	dc, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	return NewDockerEngineClient(conf, dc), nil
+}
+
+func NewDockerEngineClient(conf *viper.Viper, dc docker.APIClient) *DockerEngineClient {
+	return &DockerEngineClient{
+		dc:           dc,
+		conf:         conf,
+		pollInterval: 2 * time.Second,
+		timeout:      defaultDeploymentTimeout,
+	}
+}
+
+// A container replaced by recreateContainer
+type recreatedContainer struct {
+	ID   string
+	Name string
+	// the image the replaced container ran, to put it back with
+	PreviousImage string
+	// false if the replaced container was stopped, so its replacement wasn't started
+	Running bool
+}
+
+// Returns the IDs of the containers repoName is deployed as. Containers are
+// matched by the docker_compose_service label if set, otherwise by the
+// docker_container name which defaults to the repository name.
+func (client *DockerEngineClient) findContainers(ctx context.Context, repoName string) ([]string, error) {
+	service := utils.GetRepoSetting(client.conf, repoName, "docker_compose_service")
+	if service == "" {
+		name := utils.GetRepoSetting(client.conf, repoName, "docker_container")
+		if name == "" {
+			name = repoName
+		}
+		c, err := client.dc.ContainerInspect(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		return []string{c.ID}, nil
+	}
+
+	args := filters.NewArgs(filters.Arg("label", fmt.Sprintf("%s=%s", composeServiceLabel, service)))
+	if project := utils.GetRepoSetting(client.conf, repoName, "docker_compose_project"); project != "" {
+		args.Add("label", fmt.Sprintf("%s=%s", composeProjectLabel, project))
+	}
+	containers, err := client.dc.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: args})
+	if err != nil {
+		return nil, err
+	}
+	if len(containers) == 0 {
+		return nil, fmt.Errorf("no containers found for compose service %s", service)
+	}
+	rtn := []string{}
+	for _, c := range containers {
+		rtn = append(rtn, c.ID)
+	}
+	return rtn, nil
+}
+
+func (client *DockerEngineClient) describeTarget(repoName string) string {
+	if service := utils.GetRepoSetting(client.conf, repoName, "docker_compose_service"); service != "" {
+		return fmt.Sprintf("Docker compose service `%s`", service)
+	}
+	name := utils.GetRepoSetting(client.conf, repoName, "docker_container")
+	if name == "" {
+		name = repoName
+	}
+	return fmt.Sprintf("Docker container `%s`", name)
+}
+
+func (client *DockerEngineClient) GetDeployedTag(ctx context.Context, repoName string) (string, error) {
+	ids, err := client.findContainers(ctx, repoName)
+	if err != nil {
+		return "", err
+	}
+	c, err := client.dc.ContainerInspect(ctx, ids[0])
+	if err != nil {
+		return "", err
+	}
+	_, tag := utils.SplitImageName(c.Config.Image)
+	return tag, nil
+}
+
+func (client *DockerEngineClient) Deploy(ctx context.Context, request DeployRequest) (*Deployment, error) {
+	_, registryDomain, registryPrefix, registryAuth := utils.ExtractRegistryInfo(client.conf, request.RepoName)
+	image := utils.ConstructImageName(registryDomain, registryPrefix, request.RepoName, request.Tag)
+	log.LogAppInfo(fmt.Sprintf("Full image name to deploy %s", image))
+
+	ids, err := client.findContainers(ctx, request.RepoName)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.pullImage(ctx, image, registryDomain, registryAuth); err != nil {
+		return nil, fmt.Errorf("failed to pull image %s: %v", image, err)
+	}
+
+	recreated := []recreatedContainer{}
+	// only the containers that were started are waited for
+	newIDs := []string{}
+	for _, id := range ids {
+		c, err := client.recreateContainer(id, image)
+		if err != nil {
+			return nil, client.restoreContainers(recreated, err)
+		}
+		recreated = append(recreated, c)
+		if c.Running {
+			newIDs = append(newIDs, c.ID)
+		}
+	}
+	return &Deployment{
+		RepoName: request.RepoName,
+		Tag:      request.Tag,
+		Target:   client.describeTarget(request.RepoName),
+		ID:       strings.Join(newIDs, ","),
+	}, nil
+}
+
+func (client *DockerEngineClient) pullImage(ctx context.Context, image, registryDomain, registryAuth string) error {
+	options := types.ImagePullOptions{}
+	if registryAuth != "" {
+		username, password, err := utils.DecodeAuthString(registryAuth)
+		if err != nil {
+			return err
+		}
+		buf, err := json.Marshal(types.AuthConfig{
+			Username:      username,
+			Password:      password,
+			ServerAddress: registryDomain,
+		})
+		if err != nil {
+			return err
+		}
+		options.RegistryAuth = base64.URLEncoding.EncodeToString(buf)
+	}
+
+	resp, err := client.dc.ImagePull(ctx, image, options)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	// the pull only completes once the progress stream is consumed
+	_, err = io.Copy(ioutil.Discard, resp)
+	return err
+}
+
+/*
+ * Puts the containers already recreated by a failed deployment back on their
+ * previous image, so that it doesn't leave a mix of old and new containers.
+ * Returns cause, along with the containers that couldn't be put back.
+ */
+func (client *DockerEngineClient) restoreContainers(recreated []recreatedContainer, cause error) error {
+	if len(recreated) == 0 {
+		return cause
+	}
+	stranded := []string{}
+	for _, c := range recreated {
+		if _, err := client.recreateContainer(c.ID, c.PreviousImage); err != nil {
+			log.LogAppErr(fmt.Sprintf("Couldn't put container %s back on image %s", c.Name, c.PreviousImage), err)
+			stranded = append(stranded, c.Name)
+		}
+	}
+	if len(stranded) > 0 {
+		return fmt.Errorf("%v, and containers %s are left on the new image", cause, strings.Join(stranded, ", "))
+	}
+	return fmt.Errorf("%v, the %d containers already recreated were put back on their previous image", cause, len(recreated))
+}
+
+/*
+ * Replaces the container with one running image, keeping its name, config,
+ * mounts and networks, and started only if the old container was running.
+ * The old container is renamed and stopped first, and restored if the new
+ * container cannot be started. This does not take a context, so a shutdown
+ * can't leave the container renamed and stopped.
+ */
+func (client *DockerEngineClient) recreateContainer(id, image string) (recreatedContainer, error) {
+	ctx := context.Background()
+	old, err := client.dc.ContainerInspect(ctx, id)
+	if err != nil {
+		return recreatedContainer{}, err
+	}
+	name := strings.TrimPrefix(old.Name, "/")
+	previousImage := old.Config.Image
+	// the tag was pulled again, so only the image ID still refers to what the container ran
+	if previousImage == image {
+		previousImage = old.Image
+	}
+	backupName := fmt.Sprintf("%s-registrywatcher-%d", name, time.Now().Unix())
+
+	config := *old.Config
+	config.Image = image
+	// let docker assign the hostname of the new container if it was the default
+	if strings.HasPrefix(old.ID, config.Hostname) {
+		config.Hostname = ""
+	}
+	hostConfig := *old.HostConfig
+	hostConfig.Binds = append(hostConfig.Binds, anonymousVolumeBinds(old)...)
+	networks := copyNetworks(old)
+
+	if err := client.dc.ContainerRename(ctx, id, backupName); err != nil {
+		return recreatedContainer{}, err
+	}
+	if err := client.dc.ContainerStop(ctx, id, nil); err != nil {
+		client.restoreContainer(id, name, old.State.Running)
+		return recreatedContainer{}, err
+	}
+
+	newID, err := client.createContainer(name, &config, &hostConfig, networks, old.State.Running)
+	if err != nil {
+		client.restoreContainer(id, name, old.State.Running)
+		return recreatedContainer{}, fmt.Errorf("failed to recreate container %s: %v", name, err)
+	}
+
+	if err := client.dc.ContainerRemove(ctx, id, types.ContainerRemoveOptions{}); err != nil {
+		log.LogAppErr(fmt.Sprintf("Couldn't remove old container %s", backupName), err)
+	}
+	return recreatedContainer{ID: newID, Name: name, PreviousImage: previousImage, Running: old.State.Running}, nil
+}
+
+func (client *DockerEngineClient) createContainer(name string, config *container.Config,
+	hostConfig *container.HostConfig, networks map[string]*network.EndpointSettings, start bool) (string, error) {
+	ctx := context.Background()
+	// older engines only accept one network on create, the rest are connected after
+	networkingConfig := &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{}}
+	remaining := map[string]*network.EndpointSettings{}
+	for networkName, settings := range networks {
+		isPrimary := networkName == hostConfig.NetworkMode.NetworkName() ||
+			(hostConfig.NetworkMode.IsDefault() && networkName == "bridge")
+		if isPrimary {
+			networkingConfig.EndpointsConfig[networkName] = settings
+		} else {
+			remaining[networkName] = settings
+		}
+	}
+
+	created, err := client.dc.ContainerCreate(ctx, config, hostConfig, networkingConfig, nil, name)
+	if err != nil {
+		return "", err
+	}
+	for networkName, settings := range remaining {
+		if err := client.dc.NetworkConnect(ctx, networkName, created.ID, settings); err != nil {
+			client.dc.ContainerRemove(ctx, created.ID, types.ContainerRemoveOptions{Force: true})
+			return "", err
+		}
+	}
+	if !start {
+		return created.ID, nil
+	}
+	if err := client.dc.ContainerStart(ctx, created.ID, types.ContainerStartOptions{}); err != nil {
+		client.dc.ContainerRemove(ctx, created.ID, types.ContainerRemoveOptions{Force: true})
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// puts back the original container after a failed recreate
+func (client *DockerEngineClient) restoreContainer(id, name string, wasRunning bool) {
+	ctx := context.Background()
+	if err := client.dc.ContainerRename(ctx, id, name); err != nil {
+		log.LogAppErr(fmt.Sprintf("Couldn't restore name of container %s", name), err)
+	}
+	if wasRunning {
+		if err := client.dc.ContainerStart(ctx, id, types.ContainerStartOptions{}); err != nil {
+			log.LogAppErr(fmt.Sprintf("Couldn't restart container %s", name), err)
+		}
+	}
+}
+
+// anonymous volumes are not part of the host config, bind them by
+// name so the new container keeps their data
+func anonymousVolumeBinds(c types.ContainerJSON) []string {
+	declared := map[string]bool{}
+	for _, m := range c.HostConfig.Mounts {
+		declared[m.Target] = true
+	}
+	for _, bind := range c.HostConfig.Binds {
+		parts := strings.Split(bind, ":")
+		if len(parts) > 1 {
+			declared[parts[1]] = true
+		}
+	}
+	rtn := []string{}
+	for _, m := range c.Mounts {
+		if m.Type == mount.TypeVolume && m.Name != "" && !declared[m.Destination] {
+			rtn = append(rtn, fmt.Sprintf("%s:%s", m.Name, m.Destination))
+		}
+	}
+	return rtn
+}
+
+// copies the user supplied settings of each network, dropping
+// the addresses assigned to the old container
+func copyNetworks(c types.ContainerJSON) map[string]*network.EndpointSettings {
+	rtn := map[string]*network.EndpointSettings{}
+	if c.NetworkSettings == nil || c.HostConfig.NetworkMode.IsHost() || c.HostConfig.NetworkMode.IsContainer() {
+		return rtn
+	}
+	for networkName, settings := range c.NetworkSettings.Networks {
+		aliases := []string{}
+		for _, alias := range settings.Aliases {
+			// docker adds the short container ID as an alias
+			if !strings.HasPrefix(c.ID, alias) {
+				aliases = append(aliases, alias)
+			}
+		}
+		rtn[networkName] = &network.EndpointSettings{
+			IPAMConfig: settings.IPAMConfig,
+			Links:      settings.Links,
+			Aliases:    aliases,
+			DriverOpts: settings.DriverOpts,
+		}
+	}
+	return rtn
+}
+
+// Waits until every recreated container is healthy, or running if
+// the image has no healthcheck
+func (client *DockerEngineClient) WaitForDeployment(ctx context.Context, deployment *Deployment) DeploymentOutcome {
+	if deployment.ID == "" {
+		return DeploymentOutcome{Status: DeploymentSuccessful, Description: "all containers are stopped, none were started"}
+	}
+	ids := strings.Split(deployment.ID, ",")
+	status := "waiting for containers to start"
+
+	ticker := time.NewTicker(client.pollInterval)
+	defer ticker.Stop()
+	timeout := time.After(client.timeout)
+	for {
+		select {
+		case <-ctx.Done():
+			return DeploymentOutcome{Status: DeploymentInterrupted, Description: status}
+		case <-timeout:
+			return DeploymentOutcome{Status: DeploymentTimedOut, Description: status}
+		case <-ticker.C:
+			ready := 0
+			for _, id := range ids {
+				c, err := client.dc.ContainerInspect(ctx, id)
+				if err != nil && ctx.Err() != nil {
+					return DeploymentOutcome{Status: DeploymentInterrupted, Description: status}
+				} else if err != nil {
+					return DeploymentOutcome{Status: DeploymentFailed, Description: err.Error()}
+				}
+				state := c.State
+				switch {
+				case state.Health != nil && state.Health.Status == types.Unhealthy:
+					return DeploymentOutcome{
+						Status:      DeploymentFailed,
+						Description: fmt.Sprintf("container %s is unhealthy", strings.TrimPrefix(c.Name, "/")),
+					}
+				case !state.Running && !state.Restarting:
+					return DeploymentOutcome{
+						Status:      DeploymentFailed,
+						Description: fmt.Sprintf("container %s exited with code %d", strings.TrimPrefix(c.Name, "/"), state.ExitCode),
+					}
+				case state.Restarting:
+				case state.Health == nil || state.Health.Status == types.Healthy:
+					ready++
+				}
+			}
+			status = fmt.Sprintf("%d of %d containers are ready", ready, len(ids))
+			if ready == len(ids) {
+				return DeploymentOutcome{Status: DeploymentSuccessful, Description: status}
+			}
+		}
+	}
+}
@@ -0,0 +1,376 @@
+//go:build unit
+// +build unit
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	docker "github.com/docker/docker/client"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+const dockerEngineTestConfig = `
+watched_repositories = ["webapp", "worker", "api"]
+
+[registry_map.localregistry]
+registry_scheme = "https"
+registry_domain = "localhost:5000"
+registry_prefix = "prefix"
+registry_auth = ""
+
+[repo_map.webapp]
+registry_name = "localregistry"
+deployer = "docker"
+
+[repo_map.worker]
+registry_name = "localregistry"
+deployer = "docker"
+docker_container = "worker-1"
+
+[repo_map.api]
+registry_name = "localregistry"
+deployer = "docker"
+docker_compose_service = "api"
+docker_compose_project = "shop"
+`
+
+// An in-memory Docker Engine with just the calls the deployer makes.
+// Any other call panics on the nil embedded APIClient.
+type fakeDockerAPI struct {
+	docker.APIClient
+	containers map[string]*types.ContainerJSON
+	created    int
+	// fails the creation of a container, if set
+	createErr func(name string, config *container.Config) error
+}
+
+func newFakeDockerAPI() *fakeDockerAPI {
+	return &fakeDockerAPI{containers: map[string]*types.ContainerJSON{}}
+}
+
+func (api *fakeDockerAPI) addContainer(id, name, image string, labels map[string]string) *types.ContainerJSON {
+	c := &types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         id,
+			Name:       "/" + name,
+			Image:      "sha256:" + image,
+			State:      &types.ContainerState{Running: true},
+			HostConfig: &container.HostConfig{},
+		},
+		Config:          &container.Config{Image: image, Labels: labels},
+		NetworkSettings: &types.NetworkSettings{},
+	}
+	api.containers[id] = c
+	return c
+}
+
+func (api *fakeDockerAPI) find(idOrName string) (*types.ContainerJSON, error) {
+	for _, c := range api.containers {
+		if c.ID == idOrName || c.Name == "/"+idOrName {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("no such container: %s", idOrName)
+}
+
+func (api *fakeDockerAPI) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	c, err := api.find(containerID)
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+	// the real API returns a snapshot, not the live state
+	base := *c.ContainerJSONBase
+	state := *c.State
+	base.State = &state
+	inspected := *c
+	inspected.ContainerJSONBase = &base
+	return inspected, nil
+}
+
+func (api *fakeDockerAPI) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	rtn := []types.Container{}
+	for _, c := range api.containers {
+		matches := true
+		for _, label := range options.Filters.Get("label") {
+			parts := strings.SplitN(label, "=", 2)
+			if c.Config.Labels[parts[0]] != parts[1] {
+				matches = false
+			}
+		}
+		if matches {
+			rtn = append(rtn, types.Container{ID: c.ID, Labels: c.Config.Labels})
+		}
+	}
+	return rtn, nil
+}
+
+func (api *fakeDockerAPI) ContainerRename(ctx context.Context, containerID, newContainerName string) error {
+	c, err := api.find(containerID)
+	if err != nil {
+		return err
+	}
+	c.Name = "/" + newContainerName
+	return nil
+}
+
+func (api *fakeDockerAPI) ContainerStop(ctx context.Context, containerID string, timeout *time.Duration) error {
+	c, err := api.find(containerID)
+	if err != nil {
+		return err
+	}
+	c.State.Running = false
+	return nil
+}
+
+func (api *fakeDockerAPI) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig,
+	networkingConfig *network.NetworkingConfig, platform *specs.Platform, containerName string) (container.ContainerCreateCreatedBody, error) {
+	if api.createErr != nil {
+		if err := api.createErr(containerName, config); err != nil {
+			return container.ContainerCreateCreatedBody{}, err
+		}
+	}
+	api.created++
+	id := fmt.Sprintf("created-%d", api.created)
+	c := api.addContainer(id, containerName, config.Image, config.Labels)
+	c.State.Running = false
+	c.HostConfig = hostConfig
+	return container.ContainerCreateCreatedBody{ID: id}, nil
+}
+
+func (api *fakeDockerAPI) NetworkConnect(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error {
+	return nil
+}
+
+func (api *fakeDockerAPI) ContainerStart(ctx context.Context, containerID string, options types.ContainerStartOptions) error {
+	c, err := api.find(containerID)
+	if err != nil {
+		return err
+	}
+	c.State.Running = true
+	return nil
+}
+
+func (api *fakeDockerAPI) ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error {
+	c, err := api.find(containerID)
+	if err != nil {
+		return err
+	}
+	delete(api.containers, c.ID)
+	return nil
+}
+
+func (api *fakeDockerAPI) ImagePull(ctx context.Context, refStr string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader("")), nil
+}
+
+func setUpDockerEngineTest(t *testing.T) (*DockerEngineClient, *fakeDockerAPI) {
+	conf := viper.New()
+	conf.SetConfigType("toml")
+	if err := conf.ReadConfig(strings.NewReader(dockerEngineTestConfig)); err != nil {
+		t.Fatalf("couldn't read config: %v", err)
+	}
+	api := newFakeDockerAPI()
+	client := NewDockerEngineClient(conf, api)
+	client.pollInterval = 10 * time.Millisecond
+	return client, api
+}
+
+func TestDockerEngineFindContainers(t *testing.T) {
+	client, api := setUpDockerEngineTest(t)
+	api.addContainer("webapp-id", "webapp", "localhost:5000/prefix/webapp:v1.0.0", nil)
+	api.addContainer("worker-id", "worker-1", "localhost:5000/prefix/worker:v1.0.0", nil)
+	api.addContainer("api-1", "shop_api_1", "localhost:5000/prefix/api:v1.0.0",
+		map[string]string{composeServiceLabel: "api", composeProjectLabel: "shop"})
+	api.addContainer("api-2", "shop_api_2", "localhost:5000/prefix/api:v1.0.0",
+		map[string]string{composeServiceLabel: "api", composeProjectLabel: "shop"})
+	api.addContainer("other-api", "staging_api_1", "localhost:5000/prefix/api:v0.9.0",
+		map[string]string{composeServiceLabel: "api", composeProjectLabel: "staging"})
+
+	ids, err := client.findContainers(context.Background(), "webapp")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"webapp-id"}, ids)
+
+	ids, err = client.findContainers(context.Background(), "worker")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"worker-id"}, ids)
+
+	ids, err = client.findContainers(context.Background(), "api")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"api-1", "api-2"}, ids)
+
+	delete(api.containers, "webapp-id")
+	_, err = client.findContainers(context.Background(), "webapp")
+	assert.NotNil(t, err)
+}
+
+func TestAnonymousVolumeBinds(t *testing.T) {
+	c := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{HostConfig: &container.HostConfig{
+			Binds:  []string{"/srv/config:/config:ro"},
+			Mounts: []mount.Mount{{Type: mount.TypeVolume, Source: "cache", Target: "/cache"}},
+		}},
+		Mounts: []types.MountPoint{
+			{Type: mount.TypeBind, Source: "/srv/config", Destination: "/config"},
+			{Type: mount.TypeVolume, Name: "cache", Destination: "/cache"},
+			{Type: mount.TypeVolume, Name: "3f2a9c", Destination: "/data"},
+		},
+	}
+	assert.Equal(t, []string{"3f2a9c:/data"}, anonymousVolumeBinds(c))
+}
+
+func TestCopyNetworks(t *testing.T) {
+	c := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         "0123456789abcdef",
+			HostConfig: &container.HostConfig{NetworkMode: "shop_default"},
+		},
+		NetworkSettings: &types.NetworkSettings{Networks: map[string]*network.EndpointSettings{
+			"shop_default": {
+				Aliases:   []string{"api", "0123456789ab"},
+				IPAddress: "172.18.0.5",
+				NetworkID: "abc",
+			},
+		}},
+	}
+	networks := copyNetworks(c)
+	assert.Equal(t, 1, len(networks))
+	assert.Equal(t, []string{"api"}, networks["shop_default"].Aliases)
+	assert.Equal(t, "", networks["shop_default"].IPAddress)
+	assert.Equal(t, "", networks["shop_default"].NetworkID)
+
+	c.HostConfig.NetworkMode = "host"
+	assert.Equal(t, 0, len(copyNetworks(c)))
+}
+
+func TestDockerEngineWaitForDeployment(t *testing.T) {
+	cases := []struct {
+		name        string
+		state       types.ContainerState
+		status      DeploymentStatus
+		description string
+	}{
+		{"running without healthcheck", types.ContainerState{Running: true}, DeploymentSuccessful, "1 of 1 containers are ready"},
+		{"healthy", types.ContainerState{Running: true, Health: &types.Health{Status: types.Healthy}}, DeploymentSuccessful, "1 of 1 containers are ready"},
+		{"unhealthy", types.ContainerState{Running: true, Health: &types.Health{Status: types.Unhealthy}}, DeploymentFailed, "container webapp is unhealthy"},
+		{"exited", types.ContainerState{ExitCode: 3}, DeploymentFailed, "container webapp exited with code 3"},
+		{"still starting", types.ContainerState{Running: true, Health: &types.Health{Status: types.Starting}}, DeploymentTimedOut, "0 of 1 containers are ready"},
+		{"restarting", types.ContainerState{Restarting: true}, DeploymentTimedOut, "0 of 1 containers are ready"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, api := setUpDockerEngineTest(t)
+			client.timeout = 100 * time.Millisecond
+			c := api.addContainer("webapp-id", "webapp", "localhost:5000/prefix/webapp:v1.1.0", nil)
+			state := tc.state
+			c.State = &state
+
+			outcome := client.WaitForDeployment(context.Background(), &Deployment{ID: "webapp-id"})
+			assert.Equal(t, tc.status, outcome.Status)
+			assert.Equal(t, tc.description, outcome.Description)
+		})
+	}
+}
+
+func TestDockerEngineDeploy(t *testing.T) {
+	client, api := setUpDockerEngineTest(t)
+	api.addContainer("webapp-id", "webapp", "localhost:5000/prefix/webapp:v1.0.0", nil)
+
+	deployment, err := client.Deploy(context.Background(), DeployRequest{RepoName: "webapp", Tag: "v1.1.0"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Docker container `webapp`", deployment.Target)
+	tag, err := client.GetDeployedTag(context.Background(), "webapp")
+	assert.Nil(t, err)
+	assert.Equal(t, "v1.1.0", tag)
+	// the old container is removed
+	assert.Equal(t, 1, len(api.containers))
+}
+
+func TestDockerEngineDeployKeepsStoppedContainersStopped(t *testing.T) {
+	client, api := setUpDockerEngineTest(t)
+	labels := map[string]string{composeServiceLabel: "api", composeProjectLabel: "shop"}
+	api.addContainer("api-1", "shop_api_1", "localhost:5000/prefix/api:v1.0.0", labels)
+	stopped := api.addContainer("api-2", "shop_api_2", "localhost:5000/prefix/api:v1.0.0", labels)
+	stopped.State.Running = false
+
+	deployment, err := client.Deploy(context.Background(), DeployRequest{RepoName: "api", Tag: "v1.1.0"})
+	assert.Nil(t, err)
+	for _, c := range api.containers {
+		assert.Equal(t, "localhost:5000/prefix/api:v1.1.0", c.Config.Image, c.Name)
+		assert.Equal(t, c.Name == "/shop_api_1", c.State.Running, c.Name)
+	}
+	// only the started container is waited for
+	assert.NotContains(t, deployment.ID, ",")
+	outcome := client.WaitForDeployment(context.Background(), deployment)
+	assert.Equal(t, DeploymentSuccessful, outcome.Status)
+	assert.Equal(t, "1 of 1 containers are ready", outcome.Description)
+
+	stopped, _ = api.find("shop_api_1")
+	stopped.State.Running = false
+	deployment, err = client.Deploy(context.Background(), DeployRequest{RepoName: "api", Tag: "v1.2.0"})
+	assert.Nil(t, err)
+	assert.Equal(t, DeploymentSuccessful, client.WaitForDeployment(context.Background(), deployment).Status)
+}
+
+func TestDockerEngineDeployRestoresOnFailure(t *testing.T) {
+	client, api := setUpDockerEngineTest(t)
+	labels := map[string]string{composeServiceLabel: "api", composeProjectLabel: "shop"}
+	api.addContainer("api-1", "shop_api_1", "localhost:5000/prefix/api:v1.0.0", labels)
+	api.addContainer("api-2", "shop_api_2", "localhost:5000/prefix/api:v1.0.0", labels)
+	newImage := "localhost:5000/prefix/api:v1.1.0"
+	// whichever container is recreated second fails
+	attempts := 0
+	api.createErr = func(name string, config *container.Config) error {
+		if config.Image != newImage {
+			return nil
+		}
+		attempts++
+		if attempts == 2 {
+			return fmt.Errorf("port is already allocated")
+		}
+		return nil
+	}
+
+	_, err := client.Deploy(context.Background(), DeployRequest{RepoName: "api", Tag: "v1.1.0"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "port is already allocated")
+	assert.Contains(t, err.Error(), "the 1 containers already recreated were put back on their previous image")
+
+	assert.Equal(t, 2, len(api.containers))
+	for _, c := range api.containers {
+		assert.Equal(t, "localhost:5000/prefix/api:v1.0.0", c.Config.Image, c.Name)
+		assert.True(t, c.State.Running, c.Name)
+		assert.Contains(t, []string{"/shop_api_1", "/shop_api_2"}, c.Name)
+	}
+}
+
+func TestDockerEngineDeployReportsStrandedContainers(t *testing.T) {
+	client, api := setUpDockerEngineTest(t)
+	labels := map[string]string{composeServiceLabel: "api", composeProjectLabel: "shop"}
+	api.addContainer("api-1", "shop_api_1", "localhost:5000/prefix/api:v1.0.0", labels)
+	api.addContainer("api-2", "shop_api_2", "localhost:5000/prefix/api:v1.0.0", labels)
+	// the first container is recreated, then nothing can be created anymore
+	api.createErr = func(name string, config *container.Config) error {
+		if api.created > 0 {
+			return fmt.Errorf("no space left on device")
+		}
+		return nil
+	}
+
+	_, err := client.Deploy(context.Background(), DeployRequest{RepoName: "api", Tag: "v1.1.0"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no space left on device")
+	assert.Regexp(t, "containers shop_api_[12] are left on the new image", err.Error())
+}
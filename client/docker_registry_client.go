@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"github.com/dsaidgovsg/registrywatcher/registry"
 	"github.com/dsaidgovsg/registrywatcher/utils"
@@ -56,9 +57,58 @@ func InitializeDockerRegistryClient(conf *viper.Viper) *DockerRegistryClient {
 	return &drc
 }
 
-func (e *DockerRegistryClient) GetAllTags(repoName string) ([]string, error) {
+func (e *DockerRegistryClient) GetAllTags(ctx context.Context, repoName string) ([]string, error) {
 	_, _, registryPrefix, _ := utils.ExtractRegistryInfo(e.conf, repoName)
 	repoRegistry := e.Hubs[repoName]
-	tags, err := repoRegistry.Tags(fmt.Sprintf("%s/%s", registryPrefix, repoName))
+	tags, err := repoRegistry.Tags(ctx, fmt.Sprintf("%s/%s", registryPrefix, repoName))
 	return tags, err
 }
+
+// Returns the manifest digest that tag currently points to in repoName's registry.
+// If a platform is configured for repoName, manifest lists are resolved to the
+// digest of that platform's manifest.
+func (e *DockerRegistryClient) GetTagDigest(ctx context.Context, repoName, tag string) (string, error) {
+	_, _, registryPrefix, _ := utils.ExtractRegistryInfo(e.conf, repoName)
+	repoRegistry := e.Hubs[repoName]
+	repository := fmt.Sprintf("%s/%s", registryPrefix, repoName)
+	platform, err := e.getPlatform(repoName)
+	if err != nil {
+		return "", err
+	}
+	if platform == nil {
+		return repoRegistry.Digest(ctx, repository, tag)
+	}
+	return repoRegistry.PlatformDigest(ctx, repository, tag, *platform)
+}
+
+// Returns the manifest and config metadata of the image tag points to in repoName's registry
+func (e *DockerRegistryClient) GetImage(ctx context.Context, repoName, tag string) (*registry.Image, error) {
+	_, _, registryPrefix, _ := utils.ExtractRegistryInfo(e.conf, repoName)
+	repoRegistry := e.Hubs[repoName]
+	repository := fmt.Sprintf("%s/%s", registryPrefix, repoName)
+	platform, err := e.getPlatform(repoName)
+	if err != nil {
+		return nil, err
+	}
+	reference := tag
+	if platform != nil {
+		reference, err = repoRegistry.PlatformDigest(ctx, repository, tag, *platform)
+		if err != nil {
+			return nil, err
+		}
+	}
+	image, err := repoRegistry.Image(ctx, repository, reference)
+	return image, err
+}
+
+func (e *DockerRegistryClient) getPlatform(repoName string) (*registry.Platform, error) {
+	platformString := utils.GetRepoPlatform(e.conf, repoName)
+	if platformString == "" {
+		return nil, nil
+	}
+	platform, err := registry.ParsePlatform(platformString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid platform for repo %s: %v", repoName, err)
+	}
+	return &platform, nil
+}
@@ -2,10 +2,14 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/dsaidgovsg/registrywatcher/log"
 	"github.com/pkg/errors"
@@ -51,7 +55,7 @@ func InitializeDockerhubApi(conf *viper.Viper) (*DockerhubApi, error) {
 		token:     "",
 	}
 
-	jwt, err := client.Authenticate()
+	jwt, err := client.Authenticate(context.Background())
 	if err != nil {
 		return &client, err
 	}
@@ -60,14 +64,14 @@ func InitializeDockerhubApi(conf *viper.Viper) (*DockerhubApi, error) {
 	return &client, nil
 }
 
-func (api *DockerhubApi) Authenticate() (*string, error) {
+func (api *DockerhubApi) Authenticate(ctx context.Context) (*string, error) {
 	addr := fmt.Sprintf("%s%s", api.url, "/v2/users/login")
 	log.LogAppInfo(fmt.Sprintf("dockerhub.users.login url=%s", addr))
 
 	data := map[string]string{"username": api.username, "password": api.secret}
 	jsonData, err := json.Marshal(data)
 
-	req, err := http.NewRequest("POST", addr, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", addr, bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -89,14 +93,14 @@ func (api *DockerhubApi) Authenticate() (*string, error) {
 	return &deserialized.Token, nil
 }
 
-func (api *DockerhubApi) CheckImageIsCurrent(repository, digest string, checkTag string) (
+func (api *DockerhubApi) CheckImageIsCurrent(ctx context.Context, repository, digest string, checkTag string) (
 	*bool, error) {
 	endpoint := fmt.Sprintf("/v2/namespaces/%s/repositories/%s/images/%s/tags",
 		api.namespace, repository, digest)
 	addr := fmt.Sprintf("%s%s", api.url, endpoint)
 	log.LogAppInfo(fmt.Sprintf("dockerhub check if image is current, url=%s", addr))
 
-	req, err := http.NewRequest("GET", addr, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", addr, nil)
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", api.token))
 	req.Header.Set("Accept", "application/json")
 
@@ -108,7 +112,7 @@ func (api *DockerhubApi) CheckImageIsCurrent(repository, digest string, checkTag
 	// Obtain JWT if it has expired
 	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
 		log.LogAppInfo("Obtaining new JWT")
-		jwt, err := api.Authenticate()
+		jwt, err := api.Authenticate(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -147,7 +151,7 @@ func (api *DockerhubApi) CheckImageIsCurrent(repository, digest string, checkTag
 
 // Note that the digest returned from the Dockerhub API does not match
 // the digest from docker registry manifest V2 API
-func (api *DockerhubApi) GetTagDigestFromApi(repository string, checkTag string) (
+func (api *DockerhubApi) GetTagDigestFromApi(ctx context.Context, repository string, checkTag string) (
 	*string, error) {
 	endpoint := fmt.Sprintf("/v2/namespaces/%s/repositories/%s/images?", api.namespace,
 		repository)
@@ -157,7 +161,7 @@ func (api *DockerhubApi) GetTagDigestFromApi(repository string, checkTag string)
 	addr := fmt.Sprintf("%s%s%s", api.url, endpoint, queryParams)
 	log.LogAppInfo(fmt.Sprintf("dockerhub get tag digest url=%s", addr))
 
-	req, err := http.NewRequest("GET", addr, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", addr, nil)
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", api.token))
 	req.Header.Set("Accept", "application/json")
 
@@ -169,7 +173,7 @@ func (api *DockerhubApi) GetTagDigestFromApi(repository string, checkTag string)
 	// Obtain JWT if it has expired
 	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
 		log.LogAppInfo("Obtaining new JWT")
-		jwt, err := api.Authenticate()
+		jwt, err := api.Authenticate(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -204,3 +208,69 @@ func (api *DockerhubApi) GetTagDigestFromApi(repository string, checkTag string)
 	// image tag not found in repository's 100 last active tags
 	return nil, errors.New(fmt.Sprintf("Tag %s not found in repository %s", checkTag, repository))
 }
+
+// Body of a Dockerhub repository webhook
+type DockerhubWebhookPayload struct {
+	CallbackURL string `json:"callback_url"`
+	PushData    struct {
+		PushedAt int64  `json:"pushed_at"`
+		Pusher   string `json:"pusher"`
+		Tag      string `json:"tag"`
+	} `json:"push_data"`
+	Repository struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+		RepoName  string `json:"repo_name"`
+	} `json:"repository"`
+}
+
+// Body posted to the callback_url of a webhook to validate it
+type DockerhubWebhookCallback struct {
+	// one of success, failure, error
+	State       string `json:"state"`
+	Description string `json:"description,omitempty"`
+	Context     string `json:"context,omitempty"`
+	TargetURL   string `json:"target_url,omitempty"`
+}
+
+/*
+ * Validates a webhook by posting to its callback URL. The callback URL is
+ * only posted to if it is on the same domain as dockerhub_url (or one of its
+ * subdomains), since the webhook payload itself is not authenticated.
+ */
+func PostDockerhubWebhookCallback(ctx context.Context, conf *viper.Viper, callbackURL string, callback DockerhubWebhookCallback) error {
+	hubURL, err := url.Parse(conf.GetString("dockerhub_url"))
+	if err != nil {
+		return err
+	}
+	target, err := url.Parse(callbackURL)
+	if err != nil {
+		return err
+	}
+	hubHost := strings.TrimPrefix(hubURL.Hostname(), "hub.")
+	if target.Scheme != "https" ||
+		(target.Hostname() != hubHost && !strings.HasSuffix(target.Hostname(), "."+hubHost)) {
+		return errors.New(fmt.Sprintf("callback_url %s is not a Dockerhub URL", callbackURL))
+	}
+
+	jsonData, err := json.Marshal(callback)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", callbackURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	httpClient := http.Client{Timeout: 30 * time.Second}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(fmt.Sprintf("Response status %d message %s", resp.StatusCode, string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+//go:build unit
+// +build unit
+
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostDockerhubWebhookCallbackRejectsForeignURL(t *testing.T) {
+	conf := viper.New()
+	conf.Set("dockerhub_url", "https://hub.docker.com")
+	callback := DockerhubWebhookCallback{State: "success"}
+
+	for _, callbackURL := range []string{
+		"http://registry.hub.docker.com/u/namespace/repo/hook/abc/",
+		"https://example.com/u/namespace/repo/hook/abc/",
+		"https://docker.com.example.com/hook/",
+		"://invalid",
+	} {
+		err := PostDockerhubWebhookCallback(context.Background(), conf, callbackURL, callback)
+		assert.NotNil(t, err, callbackURL)
+	}
+}
@@ -0,0 +1,71 @@
+//go:build unit
+// +build unit
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dsaidgovsg/registrywatcher/registry"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateImageCache(t *testing.T) {
+	manifestRequests := 0
+	registryServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/library/api/manifests/v1.0.0":
+			manifestRequests++
+			w.Header().Set("Content-Type", registry.MediaTypeOCIManifest)
+			w.Header().Set("Docker-Content-Digest", "sha256:manifest")
+			w.Write([]byte(`{"schemaVersion": 2, "config": {"size": 100, "digest": "sha256:config"}, "layers": []}`))
+		case "/v2/library/api/blobs/sha256:config":
+			w.Write([]byte(`{"created": "2020-01-02T03:04:05Z", "architecture": "amd64", "os": "linux"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer registryServer.Close()
+
+	conf := viper.New()
+	conf.Set("repo_map.api.registry_name", "hub")
+	conf.Set("registry_map.hub.registry_prefix", "library")
+	clients := &Clients{
+		DockerRegistryClient: &DockerRegistryClient{
+			Hubs: map[string]registry.Registry{
+				"api": {
+					URL:    registryServer.URL,
+					Client: &http.Client{Transport: &registry.ErrorTransport{Transport: http.DefaultTransport}},
+					Logf:   registry.Quiet,
+				},
+			},
+			conf: conf,
+		},
+		conf: conf,
+	}
+	ctx := context.Background()
+
+	// nothing is fetched before the digest is cached
+	clients.updateImageCache(ctx, "api", "v1.0.0")
+	assert.Nil(t, clients.GetCachedImage("api"))
+	assert.Equal(t, 0, manifestRequests)
+
+	clients.updateDigestCache("api", "v1.0.0", "sha256:manifest")
+	clients.updateImageCache(ctx, "api", "v1.0.0")
+	image := clients.GetCachedImage("api")
+	assert.NotNil(t, image)
+	assert.Equal(t, "linux", image.OS)
+	assert.Equal(t, 1, manifestRequests)
+
+	clients.updateImageCache(ctx, "api", "v1.0.0")
+	assert.Equal(t, 1, manifestRequests)
+
+	// the tag was pushed again
+	clients.updateDigestCache("api", "v1.0.0", "sha256:other")
+	clients.updateImageCache(ctx, "api", "v1.0.0")
+	assert.Equal(t, 2, manifestRequests)
+}
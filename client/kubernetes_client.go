@@ -0,0 +1,369 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dsaidgovsg/registrywatcher/log"
+	"github.com/dsaidgovsg/registrywatcher/utils"
+	"github.com/spf13/viper"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
+)
+
+const KubernetesDeployer = "kubernetes"
+
+const (
+	kindDeployment  = "deployment"
+	kindStatefulSet = "statefulset"
+	kindDaemonSet   = "daemonset"
+)
+
+type KubernetesClient struct {
+	clientset    kubernetes.Interface
+	conf         *viper.Viper
+	pollInterval time.Duration
+	timeout      time.Duration
+}
+
+// The workload and container a watched repository is deployed to
+type kubernetesTarget struct {
+	namespace string
+	kind      string
+	name      string
+	container string
+}
+
+func (target kubernetesTarget) String() string {
+	return fmt.Sprintf("Kubernetes %s `%s/%s`", target.kind, target.namespace, target.name)
+}
+
+// Connects using the kubeconfig config path if set, otherwise
+// the in-cluster service account is used
+func InitializeKubernetesClient(conf *viper.Viper) (*KubernetesClient, error) {
+	var restConfig *rest.Config
+	var err error
+	if kubeconfig := conf.GetString("kubeconfig"); kubeconfig != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		restConfig, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return NewKubernetesClient(conf, clientset), nil
+}
+
+func NewKubernetesClient(conf *viper.Viper, clientset kubernetes.Interface) *KubernetesClient {
+	return &KubernetesClient{
+		clientset:    clientset,
+		conf:         conf,
+		pollInterval: 2 * time.Second,
+		timeout:      defaultDeploymentTimeout,
+	}
+}
+
+func (client *KubernetesClient) getTarget(repoName string) (kubernetesTarget, error) {
+	target := kubernetesTarget{
+		namespace: utils.GetRepoSetting(client.conf, repoName, "kubernetes_namespace"),
+		kind:      strings.ToLower(utils.GetRepoSetting(client.conf, repoName, "kubernetes_kind")),
+		name:      utils.GetRepoSetting(client.conf, repoName, "kubernetes_name"),
+		container: utils.GetRepoSetting(client.conf, repoName, "kubernetes_container"),
+	}
+	if target.namespace == "" {
+		target.namespace = metav1.NamespaceDefault
+	}
+	if target.kind == "" {
+		target.kind = kindDeployment
+	}
+	if target.name == "" {
+		target.name = repoName
+	}
+	if target.container == "" {
+		target.container = repoName
+	}
+	switch target.kind {
+	case kindDeployment, kindStatefulSet, kindDaemonSet:
+		return target, nil
+	default:
+		return target, fmt.Errorf("unsupported kubernetes_kind %s for repo %s", target.kind, repoName)
+	}
+}
+
+// Returns the pod template of the target workload
+func (client *KubernetesClient) getPodTemplate(ctx context.Context, target kubernetesTarget) (*corev1.PodTemplateSpec, error) {
+	switch target.kind {
+	case kindStatefulSet:
+		sts, err := client.clientset.AppsV1().StatefulSets(target.namespace).Get(ctx, target.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &sts.Spec.Template, nil
+	case kindDaemonSet:
+		ds, err := client.clientset.AppsV1().DaemonSets(target.namespace).Get(ctx, target.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &ds.Spec.Template, nil
+	default:
+		d, err := client.clientset.AppsV1().Deployments(target.namespace).Get(ctx, target.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &d.Spec.Template, nil
+	}
+}
+
+// Sets the image of the target container and returns the new generation of the workload
+func (client *KubernetesClient) updateImage(ctx context.Context, target kubernetesTarget, image string) (int64, error) {
+	var generation int64
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		switch target.kind {
+		case kindStatefulSet:
+			sts, err := client.clientset.AppsV1().StatefulSets(target.namespace).Get(ctx, target.name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if err := setContainerImage(&sts.Spec.Template, target.container, image); err != nil {
+				return err
+			}
+			sts, err = client.clientset.AppsV1().StatefulSets(target.namespace).Update(ctx, sts, metav1.UpdateOptions{})
+			if err != nil {
+				return err
+			}
+			generation = sts.Generation
+		case kindDaemonSet:
+			ds, err := client.clientset.AppsV1().DaemonSets(target.namespace).Get(ctx, target.name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if err := setContainerImage(&ds.Spec.Template, target.container, image); err != nil {
+				return err
+			}
+			ds, err = client.clientset.AppsV1().DaemonSets(target.namespace).Update(ctx, ds, metav1.UpdateOptions{})
+			if err != nil {
+				return err
+			}
+			generation = ds.Generation
+		default:
+			d, err := client.clientset.AppsV1().Deployments(target.namespace).Get(ctx, target.name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if err := setContainerImage(&d.Spec.Template, target.container, image); err != nil {
+				return err
+			}
+			d, err = client.clientset.AppsV1().Deployments(target.namespace).Update(ctx, d, metav1.UpdateOptions{})
+			if err != nil {
+				return err
+			}
+			generation = d.Generation
+		}
+		return nil
+	})
+	return generation, err
+}
+
+func setContainerImage(template *corev1.PodTemplateSpec, containerName, image string) error {
+	for i, container := range template.Spec.Containers {
+		if container.Name == containerName {
+			template.Spec.Containers[i].Image = image
+			// the same tag may have been overwritten in the registry
+			template.Spec.Containers[i].ImagePullPolicy = corev1.PullAlways
+			// image tags may be unchanged, so force a new rollout
+			if template.Annotations == nil {
+				template.Annotations = map[string]string{}
+			}
+			template.Annotations["registrywatcher/restartedAt"] = time.Now().Format(time.RFC3339)
+			return nil
+		}
+	}
+	return fmt.Errorf("container %s not found", containerName)
+}
+
+func (client *KubernetesClient) GetDeployedTag(ctx context.Context, repoName string) (string, error) {
+	target, err := client.getTarget(repoName)
+	if err != nil {
+		return "", err
+	}
+	template, err := client.getPodTemplate(ctx, target)
+	if err != nil {
+		return "", err
+	}
+	for _, container := range template.Spec.Containers {
+		if container.Name == target.container {
+			_, tag := utils.SplitImageName(container.Image)
+			return tag, nil
+		}
+	}
+	return "", fmt.Errorf("container %s not found in %s", target.container, target)
+}
+
+func (client *KubernetesClient) Deploy(ctx context.Context, request DeployRequest) (*Deployment, error) {
+	target, err := client.getTarget(request.RepoName)
+	if err != nil {
+		return nil, err
+	}
+	_, registryDomain, registryPrefix, _ := utils.ExtractRegistryInfo(client.conf, request.RepoName)
+	image := utils.ConstructImageName(registryDomain, registryPrefix, request.RepoName, request.Tag)
+	log.LogAppInfo(fmt.Sprintf("Full image name to deploy %s", image))
+
+	generation, err := client.updateImage(ctx, target, image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update image of %s: %v", target, err)
+	}
+	return &Deployment{
+		RepoName: request.RepoName,
+		Tag:      request.Tag,
+		Target:   target.String(),
+		ID:       strconv.FormatInt(generation, 10),
+	}, nil
+}
+
+// Polls the rollout status of the workload, the same way `kubectl rollout status` does
+func (client *KubernetesClient) WaitForDeployment(ctx context.Context, deployment *Deployment) DeploymentOutcome {
+	target, err := client.getTarget(deployment.RepoName)
+	if err != nil {
+		return DeploymentOutcome{Status: DeploymentFailed, Description: err.Error()}
+	}
+	generation, err := strconv.ParseInt(deployment.ID, 10, 64)
+	if err != nil {
+		return DeploymentOutcome{Status: DeploymentFailed, Description: err.Error()}
+	}
+
+	status := "waiting for rollout to start"
+	ticker := time.NewTicker(client.pollInterval)
+	defer ticker.Stop()
+	timeout := time.After(client.timeout)
+	for {
+		select {
+		case <-ctx.Done():
+			return DeploymentOutcome{Status: DeploymentInterrupted, Description: status}
+		case <-timeout:
+			return DeploymentOutcome{Status: DeploymentTimedOut, Description: status}
+		case <-ticker.C:
+			done, failed, msg, err := client.rolloutStatus(ctx, target, generation)
+			if err != nil {
+				log.LogAppErr(fmt.Sprintf("Couldn't fetch rollout status of %s", target), err)
+				continue
+			}
+			status = msg
+			if failed {
+				return DeploymentOutcome{Status: DeploymentFailed, Description: msg}
+			}
+			if done {
+				return DeploymentOutcome{Status: DeploymentSuccessful, Description: msg}
+			}
+		}
+	}
+}
+
+// Returns whether the rollout of generation is done or has failed,
+// and a description of its progress
+func (client *KubernetesClient) rolloutStatus(ctx context.Context, target kubernetesTarget, generation int64) (bool, bool, string, error) {
+	switch target.kind {
+	case kindStatefulSet:
+		sts, err := client.clientset.AppsV1().StatefulSets(target.namespace).Get(ctx, target.name, metav1.GetOptions{})
+		if err != nil {
+			return false, false, "", err
+		}
+		done, msg := statefulSetRolloutStatus(sts, generation)
+		return done, false, msg, nil
+	case kindDaemonSet:
+		ds, err := client.clientset.AppsV1().DaemonSets(target.namespace).Get(ctx, target.name, metav1.GetOptions{})
+		if err != nil {
+			return false, false, "", err
+		}
+		done, msg := daemonSetRolloutStatus(ds, generation)
+		return done, false, msg, nil
+	default:
+		d, err := client.clientset.AppsV1().Deployments(target.namespace).Get(ctx, target.name, metav1.GetOptions{})
+		if err != nil {
+			return false, false, "", err
+		}
+		done, failed, msg := deploymentRolloutStatus(d, generation)
+		return done, failed, msg, nil
+	}
+}
+
+func deploymentRolloutStatus(d *appsv1.Deployment, generation int64) (bool, bool, string) {
+	if d.Status.ObservedGeneration < generation {
+		return false, false, "waiting for deployment spec update to be observed"
+	}
+	for _, condition := range d.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			return false, true, fmt.Sprintf("deployment exceeded its progress deadline: %s", condition.Message)
+		}
+	}
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	if d.Status.UpdatedReplicas < replicas {
+		return false, false, fmt.Sprintf("%d of %d new replicas have been updated", d.Status.UpdatedReplicas, replicas)
+	}
+	if d.Status.Replicas > d.Status.UpdatedReplicas {
+		return false, false, fmt.Sprintf("%d old replicas are pending termination", d.Status.Replicas-d.Status.UpdatedReplicas)
+	}
+	if d.Status.AvailableReplicas < d.Status.UpdatedReplicas {
+		return false, false, fmt.Sprintf("%d of %d updated replicas are available", d.Status.AvailableReplicas, d.Status.UpdatedReplicas)
+	}
+	return true, false, fmt.Sprintf("%d replicas rolled out", d.Status.UpdatedReplicas)
+}
+
+func statefulSetRolloutStatus(sts *appsv1.StatefulSet, generation int64) (bool, string) {
+	if sts.Status.ObservedGeneration < generation {
+		return false, "waiting for statefulset spec update to be observed"
+	}
+	if sts.Spec.UpdateStrategy.Type != appsv1.RollingUpdateStatefulSetStrategyType {
+		return true, "statefulset does not use rolling updates, pods must be deleted to pick up the new image"
+	}
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	if sts.Status.ReadyReplicas < replicas {
+		return false, fmt.Sprintf("%d of %d pods are ready", sts.Status.ReadyReplicas, replicas)
+	}
+	rollingUpdate := sts.Spec.UpdateStrategy.RollingUpdate
+	if rollingUpdate != nil && rollingUpdate.Partition != nil && *rollingUpdate.Partition > 0 {
+		partitioned := replicas - *rollingUpdate.Partition
+		if sts.Status.UpdatedReplicas < partitioned {
+			return false, fmt.Sprintf("%d of %d partitioned pods have been updated", sts.Status.UpdatedReplicas, partitioned)
+		}
+		return true, fmt.Sprintf("%d partitioned pods rolled out", partitioned)
+	}
+	if sts.Status.UpdateRevision != sts.Status.CurrentRevision {
+		return false, fmt.Sprintf("%d of %d pods have been updated", sts.Status.UpdatedReplicas, replicas)
+	}
+	return true, fmt.Sprintf("%d pods rolled out", replicas)
+}
+
+func daemonSetRolloutStatus(ds *appsv1.DaemonSet, generation int64) (bool, string) {
+	if ds.Status.ObservedGeneration < generation {
+		return false, "waiting for daemonset spec update to be observed"
+	}
+	if ds.Spec.UpdateStrategy.Type != appsv1.RollingUpdateDaemonSetStrategyType {
+		return true, "daemonset does not use rolling updates, pods must be deleted to pick up the new image"
+	}
+	desired := ds.Status.DesiredNumberScheduled
+	if ds.Status.UpdatedNumberScheduled < desired {
+		return false, fmt.Sprintf("%d of %d updated pods have been scheduled", ds.Status.UpdatedNumberScheduled, desired)
+	}
+	if ds.Status.NumberAvailable < desired {
+		return false, fmt.Sprintf("%d of %d updated pods are available", ds.Status.NumberAvailable, desired)
+	}
+	return true, fmt.Sprintf("%d pods rolled out", desired)
+}
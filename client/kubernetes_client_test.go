@@ -0,0 +1,164 @@
+//go:build unit
+// +build unit
+
+package client
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const kubernetesTestConfig = `
+watched_repositories = ["webapp", "worker"]
+
+[registry_map.localregistry]
+registry_scheme = "https"
+registry_domain = "localhost:5000"
+registry_prefix = "prefix"
+registry_auth = ""
+
+[repo_map.webapp]
+registry_name = "localregistry"
+deployer = "kubernetes"
+kubernetes_namespace = "apps"
+kubernetes_name = "webapp-deployment"
+
+[repo_map.worker]
+registry_name = "localregistry"
+deployer = "kubernetes"
+kubernetes_namespace = "apps"
+kubernetes_kind = "StatefulSet"
+kubernetes_container = "main"
+`
+
+func setUpKubernetesTest(t *testing.T) (*KubernetesClient, *fake.Clientset) {
+	conf := viper.New()
+	conf.SetConfigType("toml")
+	if err := conf.ReadConfig(strings.NewReader(kubernetesTestConfig)); err != nil {
+		t.Fatalf("couldn't read config: %v", err)
+	}
+
+	replicas := int32(2)
+	clientset := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "webapp-deployment", Namespace: "apps", Generation: 1},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{Containers: []corev1.Container{
+						{Name: "sidecar", Image: "envoy:v1"},
+						{Name: "webapp", Image: "localhost:5000/prefix/webapp:v1.0.0"},
+					}},
+				},
+			},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "apps", Generation: 1},
+			Spec: appsv1.StatefulSetSpec{
+				Replicas: &replicas,
+				UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+					Type: appsv1.RollingUpdateStatefulSetStrategyType,
+				},
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{Containers: []corev1.Container{
+						{Name: "main", Image: "localhost:5000/prefix/worker:v0.1.0"},
+					}},
+				},
+			},
+		},
+	)
+	client := NewKubernetesClient(conf, clientset)
+	client.pollInterval = 10 * time.Millisecond
+	client.timeout = time.Second
+	return client, clientset
+}
+
+func TestKubernetesDeploy(t *testing.T) {
+	client, clientset := setUpKubernetesTest(t)
+	deployments := clientset.AppsV1().Deployments("apps")
+
+	tag, err := client.GetDeployedTag(context.Background(), "webapp")
+	assert.Nil(t, err)
+	assert.Equal(t, "v1.0.0", tag)
+
+	deployment, err := client.Deploy(context.Background(), DeployRequest{RepoName: "webapp", Tag: "v1.1.0"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Kubernetes deployment `apps/webapp-deployment`", deployment.Target)
+
+	d, _ := deployments.Get(context.Background(), "webapp-deployment", metav1.GetOptions{})
+	assert.Equal(t, "envoy:v1", d.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, "localhost:5000/prefix/webapp:v1.1.0", d.Spec.Template.Spec.Containers[1].Image)
+	tag, _ = client.GetDeployedTag(context.Background(), "webapp")
+	assert.Equal(t, "v1.1.0", tag)
+
+	// nothing rolls out without a controller, so the monitor times out
+	outcome := client.WaitForDeployment(context.Background(), deployment)
+	assert.Equal(t, DeploymentTimedOut, outcome.Status)
+
+	// simulate the deployment controller finishing the rollout
+	d.Status = appsv1.DeploymentStatus{
+		ObservedGeneration: d.Generation,
+		Replicas:           2,
+		UpdatedReplicas:    2,
+		AvailableReplicas:  2,
+	}
+	_, err = deployments.UpdateStatus(context.Background(), d, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	outcome = client.WaitForDeployment(context.Background(), deployment)
+	assert.Equal(t, DeploymentSuccessful, outcome.Status)
+
+	// simulate a rollout stuck on unhealthy pods
+	d.Status.AvailableReplicas = 1
+	d.Status.Conditions = []appsv1.DeploymentCondition{{
+		Type:    appsv1.DeploymentProgressing,
+		Status:  corev1.ConditionFalse,
+		Reason:  "ProgressDeadlineExceeded",
+		Message: "ReplicaSet has timed out progressing.",
+	}}
+	_, err = deployments.UpdateStatus(context.Background(), d, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	outcome = client.WaitForDeployment(context.Background(), deployment)
+	assert.Equal(t, DeploymentFailed, outcome.Status)
+}
+
+func TestKubernetesDeployStatefulSet(t *testing.T) {
+	client, clientset := setUpKubernetesTest(t)
+	statefulSets := clientset.AppsV1().StatefulSets("apps")
+
+	deployment, err := client.Deploy(context.Background(), DeployRequest{RepoName: "worker", Tag: "v0.2.0"})
+	assert.Nil(t, err)
+	tag, _ := client.GetDeployedTag(context.Background(), "worker")
+	assert.Equal(t, "v0.2.0", tag)
+
+	sts, _ := statefulSets.Get(context.Background(), "worker", metav1.GetOptions{})
+	sts.Status = appsv1.StatefulSetStatus{
+		ObservedGeneration: sts.Generation,
+		ReadyReplicas:      2,
+		UpdatedReplicas:    2,
+		CurrentRevision:    "worker-2",
+		UpdateRevision:     "worker-2",
+	}
+	_, err = statefulSets.UpdateStatus(context.Background(), sts, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	outcome := client.WaitForDeployment(context.Background(), deployment)
+	assert.Equal(t, DeploymentSuccessful, outcome.Status)
+}
+
+func TestKubernetesDeployMissingContainer(t *testing.T) {
+	client, _ := setUpKubernetesTest(t)
+	client.conf.Set("repo_map.webapp.kubernetes_container", "missing")
+
+	_, err := client.Deploy(context.Background(), DeployRequest{RepoName: "webapp", Tag: "v1.1.0"})
+	assert.NotNil(t, err)
+	_, err = client.GetDeployedTag(context.Background(), "webapp")
+	assert.NotNil(t, err)
+}
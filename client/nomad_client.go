@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -31,14 +32,24 @@ func InitializeNomadClient(conf *viper.Viper) *NomadClient {
 	return &rtn
 }
 
-func getNomadJobTagFromTask(task *nomad.Task) string {
-	rtnArray := strings.Split(task.Config["image"].(string), ":")
-	return rtnArray[1]
+// Job meta key holding the tag of a task's image when it is deployed by digest
+func nomadTagMetaKey(taskName string) string {
+	return fmt.Sprintf("registrywatcher_%s_tag", taskName)
+}
+
+// Returns the tag of the task's image, which is kept in job meta if the image is referenced by digest
+func getNomadJobTagFromTask(job *nomad.Job, task *nomad.Task) string {
+	image := task.Config["image"].(string)
+	_, tag := utils.SplitImageName(image)
+	if tag == "" && utils.ImageDigest(image) != "" {
+		tag = job.Meta[nomadTagMetaKey(task.Name)]
+	}
+	return tag
 }
 
 func getNomadJobImageFromTask(task *nomad.Task) string {
-	rtnArray := strings.Split(task.Config["image"].(string), ":")
-	return rtnArray[0]
+	name, _ := utils.SplitImageName(task.Config["image"].(string))
+	return name
 }
 
 func (client *NomadClient) getNomadJob(jobID string) (nomad.Job, error) {
@@ -49,37 +60,109 @@ func (client *NomadClient) getNomadJob(jobID string) (nomad.Job, error) {
 	return *job, nil
 }
 
-func (client *NomadClient) GetNomadJobTag(jobID, imageName string) (string, error) {
-	job, err := client.getNomadJob(jobID)
-	if err != nil {
-		return "", err
-	}
-	var tag string
-	for i, taskGroup := range job.TaskGroups {
-		for j, task := range taskGroup.Tasks {
+// Returns the task of the job whose image is imageName, nil if there is none
+func findNomadTaskByImage(job *nomad.Job, imageName string) *nomad.Task {
+	var rtn *nomad.Task
+	for _, taskGroup := range job.TaskGroups {
+		for _, task := range taskGroup.Tasks {
 			fullImageName := getNomadJobImageFromTask(task)
 			arr := strings.Split(fullImageName, "/")
 			taskImageName := arr[len(arr)-1]
 			if taskImageName == imageName {
-				tag = getNomadJobTagFromTask(job.TaskGroups[i].Tasks[j])
+				rtn = task
 			}
 		}
 	}
-	return tag, nil
+	return rtn
+}
+
+func (client *NomadClient) GetNomadJobTag(jobID, imageName string) (string, error) {
+	job, err := client.getNomadJob(jobID)
+	if err != nil {
+		return "", err
+	}
+	task := findNomadTaskByImage(&job, imageName)
+	if task == nil {
+		return "", nil
+	}
+	return getNomadJobTagFromTask(&job, task), nil
+}
+
+func (client *NomadClient) GetDeployedTag(ctx context.Context, repoName string) (string, error) {
+	jobID, err := utils.GetRepoNomadJob(client.conf, repoName)
+	if err != nil {
+		return "", err
+	}
+	return client.GetNomadJobTag(jobID, repoName)
+}
+
+// Returns the digest of repoName's image in its Nomad job, empty if it is deployed by tag
+func (client *NomadClient) GetDeployedDigest(ctx context.Context, repoName string) (string, error) {
+	jobID, err := utils.GetRepoNomadJob(client.conf, repoName)
+	if err != nil {
+		return "", err
+	}
+	job, err := client.getNomadJob(jobID)
+	if err != nil {
+		return "", err
+	}
+	task := findNomadTaskByImage(&job, repoName)
+	if task == nil {
+		return "", nil
+	}
+	return utils.ImageDigest(task.Config["image"].(string)), nil
+}
+
+func (client *NomadClient) Deploy(ctx context.Context, request DeployRequest) (*Deployment, error) {
+	jobID, err := utils.GetRepoNomadJob(client.conf, request.RepoName)
+	if err != nil {
+		return nil, err
+	}
+	taskName, err := utils.GetRepoNomadTaskName(client.conf, request.RepoName)
+	if err != nil {
+		return nil, err
+	}
+	digest := ""
+	if utils.GetRepoDeployByDigest(client.conf, request.RepoName) {
+		if request.Digest == "" {
+			return nil, fmt.Errorf("couldn't resolve the digest of tag %s to deploy by digest", request.Tag)
+		}
+		digest = request.Digest
+	}
+	evalID, err := client.UpdateNomadJobTag(jobID, request.RepoName, taskName, request.Tag, digest)
+	if err != nil {
+		return nil, err
+	}
+	return &Deployment{
+		RepoName: request.RepoName,
+		Tag:      request.Tag,
+		Target:   fmt.Sprintf("Nomad job `%s`", jobID),
+		ID:       evalID,
+	}, nil
+}
+
+func (client *NomadClient) WaitForDeployment(ctx context.Context, deployment *Deployment) DeploymentOutcome {
+	return client.MonitorNomadJob(ctx, deployment.ID)
 }
 
 // Updates one image in a Nomad job, unless the Nomad jobspec is registrywatcher itself.
 // Since the registrywatcher Nomad jobspec contains 2 images (UI and backend), it will update
 // both images before it restarts itself.
-func (client *NomadClient) UpdateNomadJobTag(jobID, imageName, taskName, desiredTag string) {
+// If desiredDigest is set, the image is referenced by that digest so every
+// allocation runs the same image, and desiredTag is kept in the job meta.
+// Returns the ID of the evaluation created by the job update.
+func (client *NomadClient) UpdateNomadJobTag(jobID, imageName, taskName, desiredTag, desiredDigest string) (string, error) {
 	_, registryDomain, registryPrefix, _ := utils.ExtractRegistryInfo(client.conf, imageName)
 	desiredFullImageName := utils.ConstructImageName(registryDomain, registryPrefix, imageName, desiredTag)
+	if desiredDigest != "" {
+		desiredFullImageName = utils.ConstructImageDigestName(registryDomain, registryPrefix, imageName, desiredDigest)
+	}
 	matchFound := false
 	log.LogAppInfo(fmt.Sprintf("Full image name to deploy %s", desiredFullImageName))
 	job, err := client.getNomadJob(jobID)
 	if err != nil {
 		log.LogAppErr(fmt.Sprintf("Couldn't find jobID %s", jobID), err)
-		return
+		return "", fmt.Errorf("couldn't find Nomad job %s: %v", jobID, err)
 	}
 	for i, taskGroup := range job.TaskGroups {
 		for j, task := range taskGroup.Tasks {
@@ -91,6 +174,14 @@ func (client *NomadClient) UpdateNomadJobTag(jobID, imageName, taskName, desired
 				// nomad jobs with this config set to false may end up
 				// with this config as true in the process of calling this endpoint
 				job.TaskGroups[i].Tasks[j].Config["force_pull"] = true
+				if job.Meta == nil {
+					job.Meta = map[string]string{}
+				}
+				if desiredDigest != "" {
+					job.Meta[nomadTagMetaKey(taskName)] = desiredTag
+				} else {
+					delete(job.Meta, nomadTagMetaKey(taskName))
+				}
 			}
 			// bootstrapping. the nomad job "registrywatcher" also contains
 			// a task/container of the ui image.
@@ -109,94 +200,101 @@ func (client *NomadClient) UpdateNomadJobTag(jobID, imageName, taskName, desired
 		job.VaultToken = &vaultToken
 	}
 
-	if matchFound {
-		go client.RestartNomadJob(&job, desiredTag)
-	} else {
-		utils.PostSlackError(client.conf, fmt.Sprintf("Mapped task name %s not found in Nomad job %s. Please check deployment configuration.", taskName, *job.ID))
+	if !matchFound {
+		return "", fmt.Errorf("Mapped task name %s not found in Nomad job %s. Please check deployment configuration.", taskName, *job.ID)
 	}
+	return client.RestartNomadJob(&job)
 }
 
 // Modify a flag that should not affect the operation of a Nomad jobspec
 func (client *NomadClient) flipJobMeta(job *nomad.Job) {
-	if _, ok := job.Meta["restart"]; ok {
-		if job.Meta["restart"] == "foo" {
-			job.Meta["restart"] = "bar"
-		} else {
-			job.Meta["restart"] = "foo"
-		}
+	if job.Meta == nil {
+		job.Meta = map[string]string{}
+	}
+	if job.Meta["restart"] == "bar" {
+		job.Meta["restart"] = "foo"
 	} else {
-		job.Meta = map[string]string{
-			"restart": "bar",
-		}
+		job.Meta["restart"] = "bar"
 	}
 }
 
 // There is no way to restart a job through the API currently
 // https://github.com/hashicorp/nomad/issues/698
-func (client *NomadClient) RestartNomadJob(job *nomad.Job, desiredTag string) {
+func (client *NomadClient) RestartNomadJob(job *nomad.Job) (string, error) {
 	jobID := *job.ID
 
 	// stupid hack to force a restart when registering a job
 	client.flipJobMeta(job)
 
-	utils.PostSlackUpdate(client.conf, fmt.Sprintf("Update: deploying job `%s` to tag `%s`", jobID, desiredTag))
 	resp, _, err := client.nc.Jobs().RegisterOpts(job, nil, nil)
 	if err != nil {
 		log.LogAppErr(fmt.Sprintf("Failed to restart job %s", jobID), err)
-		utils.PostSlackError(client.conf, fmt.Sprintf("Error: failed to force redeploy job `%s` for tag `%s`", jobID, desiredTag))
-	} else {
-		client.MonitorNomadJob(resp.EvalID, jobID, desiredTag)
+		return "", fmt.Errorf("failed to force redeploy Nomad job %s: %v", jobID, err)
 	}
+	return resp.EvalID, nil
 }
 
-// Monitor the progress of a Nomad job deployment
-// and posts a slack update on the outcome
-func (client *NomadClient) MonitorNomadJob(evalID, jobID, desiredTag string) {
-	evalStatusDesc := ""
-	deploymentStatus := "running"
+// Monitor the progress of the Nomad deployment created by evalID
+// until it finishes, for a maximum of 20 minutes or until ctx is done
+func (client *NomadClient) MonitorNomadJob(ctx context.Context, evalID string) DeploymentOutcome {
 	evalDeploymentID := ""
+	deploymentStatus := "pending"
+	deploymentStatusDesc := ""
 
 	ticker := time.NewTicker(500 * time.Millisecond)
 	defer ticker.Stop()
-	done := make(chan bool)
-	go func() {
-		// monitor for max 20 mins
-		time.Sleep(1200 * time.Second)
-		done <- true
-	}()
+	timeout := time.After(defaultDeploymentTimeout)
 	for {
 		select {
-		case <-done:
-			return
-		case _ = <-ticker.C:
-			for evalStatusDesc != "complete" {
+		case <-ctx.Done():
+			return DeploymentOutcome{
+				Status:      DeploymentInterrupted,
+				Description: fmt.Sprintf("Nomad deployment status is `%s`", deploymentStatus),
+			}
+		case <-timeout:
+			return DeploymentOutcome{
+				Status:      DeploymentTimedOut,
+				Description: fmt.Sprintf("Nomad deployment status is `%s`", deploymentStatus),
+			}
+		case <-ticker.C:
+			if evalDeploymentID == "" {
 				eval, _, err := client.nc.Evaluations().Info(evalID, nil)
 				if err != nil {
 					continue
 				}
-				evalDeploymentID = eval.DeploymentID
-				evalStatusDesc = eval.StatusDescription
-				if evalStatusDesc == "" {
-					evalStatusDesc = eval.Status
+				switch eval.Status {
+				case "complete":
+				case "failed", "canceled":
+					return DeploymentOutcome{
+						Status:      DeploymentFailed,
+						Description: fmt.Sprintf("Nomad evaluation %s: %s", eval.Status, eval.StatusDescription),
+					}
+				default:
+					continue
+				}
+				if eval.DeploymentID == "" {
+					// jobs without an update stanza are placed without a deployment
+					return DeploymentOutcome{Status: DeploymentSuccessful}
 				}
+				evalDeploymentID = eval.DeploymentID
 			}
 
-			for deploymentStatus == "running" {
-				d, _, err := client.nc.Deployments().Info(evalDeploymentID, nil)
-				if err != nil {
-					continue
-				}
-				deploymentStatus = d.Status
+			d, _, err := client.nc.Deployments().Info(evalDeploymentID, nil)
+			if err != nil {
+				continue
 			}
+			deploymentStatus = d.Status
+			deploymentStatusDesc = d.StatusDescription
 
-			if deploymentStatus == "successful" {
-				utils.PostSlackSuccess(client.conf, fmt.Sprintf("Success: Nomad deployment for job `%s` succeeded for tag `%s`", jobID, desiredTag))
-			} else if deploymentStatus == "failed" {
-				utils.PostSlackError(client.conf, fmt.Sprintf("Error: Nomad deployment job `%s` failed for tag `%s`, nomad server will roll back to last working version if possible", jobID, desiredTag))
-			} else {
-				utils.PostSlackUpdate(client.conf, fmt.Sprintf("Update: Nomad deployment status is `%s` for job `%s` for tag `%s`. Monitoring timeout", deploymentStatus, jobID, desiredTag))
+			switch deploymentStatus {
+			case "successful":
+				return DeploymentOutcome{Status: DeploymentSuccessful, Description: deploymentStatusDesc}
+			case "failed", "cancelled":
+				return DeploymentOutcome{
+					Status:      DeploymentFailed,
+					Description: fmt.Sprintf("%s, nomad server will roll back to last working version if possible", deploymentStatusDesc),
+				}
 			}
-			done <- true
 		}
 	}
 }
@@ -0,0 +1,34 @@
+//go:build unit
+// +build unit
+
+package client
+
+import (
+	"testing"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetNomadJobTagFromTask(t *testing.T) {
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	job := &nomad.Job{
+		Meta: map[string]string{nomadTagMetaKey("bydigest"): "v1.2.0"},
+		TaskGroups: []*nomad.TaskGroup{{
+			Tasks: []*nomad.Task{
+				{Name: "bytag", Config: map[string]interface{}{"image": "localhost:5000/prefix/bytag:v1.0.0"}},
+				{Name: "bydigest", Config: map[string]interface{}{"image": "localhost:5000/prefix/bydigest@" + digest}},
+			},
+		}},
+	}
+
+	task := findNomadTaskByImage(job, "bytag")
+	assert.Equal(t, "bytag", task.Name)
+	assert.Equal(t, "v1.0.0", getNomadJobTagFromTask(job, task))
+
+	task = findNomadTaskByImage(job, "bydigest")
+	assert.Equal(t, "bydigest", task.Name)
+	assert.Equal(t, "v1.2.0", getNomadJobTagFromTask(job, task))
+
+	assert.Nil(t, findNomadTaskByImage(job, "missing"))
+}
@@ -1,20 +1,31 @@
 package client
 
 import (
+	"context"
 	"database/sql"
+	"hash/fnv"
 	"math"
 	"os"
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 )
 
+const (
+	// Channel on which replicas forward push notifications to the leader of the pushed repository
+	pushChannel = "registrywatcher_push"
+	// Channel on which replicas announce the repositories they deployed, so
+	// that the other replicas refresh their caches of them
+	deployChannel = "registrywatcher_deploy"
+)
+
 type PostgresClient struct {
-	db   *sqlx.DB
-	conf *viper.Viper
+	db    *sqlx.DB
+	dburl string
+	conf  *viper.Viper
 }
 
 // Initialize creates tables if they do not exist
@@ -29,6 +40,7 @@ func InitializePostgresClient(conf *viper.Viper) (*PostgresClient, error) {
 	} else {
 		dburl = conf.GetString("database_url")
 	}
+	client.dburl = dburl
 	createSchema := conf.GetBool("create_database_schema")
 
 	var err error
@@ -87,8 +99,8 @@ func (client *PostgresClient) initRows() error {
 	return nil
 }
 
-func (client *PostgresClient) UpdateAutoDeployFlag(repoName string, autoDeploy bool) error {
-	tx, err := client.db.Begin()
+func (client *PostgresClient) UpdateAutoDeployFlag(ctx context.Context, repoName string, autoDeploy bool) error {
+	tx, err := client.db.BeginTx(ctx, nil)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -96,7 +108,7 @@ func (client *PostgresClient) UpdateAutoDeployFlag(repoName string, autoDeploy b
 	update := `
           UPDATE deployed_repository_version SET auto_deploy = $2 WHERE repository_name = $1;`
 
-	if _, err = tx.Exec(
+	if _, err = tx.ExecContext(ctx,
 		update, repoName, autoDeploy); err != nil {
 		tx.Rollback()
 		return errors.WithStack(err)
@@ -109,16 +121,17 @@ func (client *PostgresClient) UpdateAutoDeployFlag(repoName string, autoDeploy b
 	return nil
 }
 
-func (client *PostgresClient) UpdatePinnedTag(repoName, pinnedTag string) error {
-	tx, err := client.db.Begin()
+func (client *PostgresClient) UpdatePinnedTag(ctx context.Context, repoName, pinnedTag string) error {
+	tx, err := client.db.BeginTx(ctx, nil)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	// a pinned tag replaces any version constraint
 	update := `
-          UPDATE deployed_repository_version SET pinned_tag = $2 WHERE repository_name = $1;`
+          UPDATE deployed_repository_version SET pinned_tag = $2, version_constraint = '' WHERE repository_name = $1;`
 
-	if _, err = tx.Exec(
+	if _, err = tx.ExecContext(ctx,
 		update, repoName, pinnedTag); err != nil {
 		tx.Rollback()
 		return errors.WithStack(err)
@@ -131,16 +144,83 @@ func (client *PostgresClient) UpdatePinnedTag(repoName, pinnedTag string) error
 	return nil
 }
 
+// Sets a version constraint, which replaces any pinned tag, so the latest
+// tag inside the constraint is deployed. An empty constraint tracks the latest tag.
+func (client *PostgresClient) UpdateVersionConstraint(ctx context.Context, repoName, versionConstraint string) error {
+	tx, err := client.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	update := `
+          UPDATE deployed_repository_version SET pinned_tag = '', version_constraint = $2 WHERE repository_name = $1;`
+
+	if _, err = tx.ExecContext(ctx,
+		update, repoName, versionConstraint); err != nil {
+		tx.Rollback()
+		return errors.WithStack(err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
 type DeployedRepositoryVersionRow struct {
-	PinnedTag      string `json:"pinned_tag" db:"pinned_tag"`
-	RepositoryName string `json:"repository_name" db:"repository_name"`
-	AutoDeploy     bool   `json:"auto_deploy" db:"auto_deploy"`
+	PinnedTag         string `json:"pinned_tag" db:"pinned_tag"`
+	RepositoryName    string `json:"repository_name" db:"repository_name"`
+	AutoDeploy        bool   `json:"auto_deploy" db:"auto_deploy"`
+	VersionConstraint string `json:"version_constraint" db:"version_constraint"`
+	// trigger of an auto deployment waiting for a deployment window, empty if there is none
+	DeferredTrigger string `json:"deferred_trigger" db:"deferred_trigger"`
+}
+
+func (client *PostgresClient) GetVersionConstraint(ctx context.Context, repoName string) (string, error) {
+	var rtn DeployedRepositoryVersionRow
+	sqlStatement := "select * from deployed_repository_version where repository_name = $1"
+	err := client.db.GetContext(ctx, &rtn, sqlStatement, repoName)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.Wrapf(err, "version_constraint with repoName %s not found", repoName)
+		} else {
+			return "", errors.Wrapf(err, "issue getting version_constraint with repoName [%s]", repoName)
+		}
+	}
+	return rtn.VersionConstraint, err
 }
 
-func (client *PostgresClient) GetAutoDeployFlag(repoName string) (bool, error) {
+func (client *PostgresClient) GetDeferredTrigger(ctx context.Context, repoName string) (string, error) {
 	var rtn DeployedRepositoryVersionRow
 	sqlStatement := "select * from deployed_repository_version where repository_name = $1"
-	err := client.db.Get(&rtn, sqlStatement, repoName)
+	err := client.db.GetContext(ctx, &rtn, sqlStatement, repoName)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.Wrapf(err, "deferred_trigger with repoName %s not found", repoName)
+		} else {
+			return "", errors.Wrapf(err, "issue getting deferred_trigger with repoName [%s]", repoName)
+		}
+	}
+	return rtn.DeferredTrigger, err
+}
+
+// Records that an auto deployment of repoName is waiting for a deployment
+// window, an empty trigger clears it
+func (client *PostgresClient) UpdateDeferredTrigger(ctx context.Context, repoName, trigger string) error {
+	update := `
+          UPDATE deployed_repository_version SET deferred_trigger = $2 WHERE repository_name = $1;`
+
+	if _, err := client.db.ExecContext(ctx, update, repoName, trigger); err != nil {
+		return errors.Wrapf(err, "issue updating deferred_trigger with repoName [%s]", repoName)
+	}
+	return nil
+}
+
+func (client *PostgresClient) GetAutoDeployFlag(ctx context.Context, repoName string) (bool, error) {
+	var rtn DeployedRepositoryVersionRow
+	sqlStatement := "select * from deployed_repository_version where repository_name = $1"
+	err := client.db.GetContext(ctx, &rtn, sqlStatement, repoName)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return false, errors.Wrapf(err, "auto_deploy with repoName %s not found", repoName)
@@ -151,10 +231,10 @@ func (client *PostgresClient) GetAutoDeployFlag(repoName string) (bool, error) {
 	return rtn.AutoDeploy, err
 }
 
-func (client *PostgresClient) GetPinnedTag(repoName string) (string, error) {
+func (client *PostgresClient) GetPinnedTag(ctx context.Context, repoName string) (string, error) {
 	var rtn DeployedRepositoryVersionRow
 	sqlStatement := "select * from deployed_repository_version where repository_name = $1"
-	err := client.db.Get(&rtn, sqlStatement, repoName)
+	err := client.db.GetContext(ctx, &rtn, sqlStatement, repoName)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return "", errors.Wrapf(err, "pinned_tag with repoName %s not found", repoName)
@@ -165,11 +245,11 @@ func (client *PostgresClient) GetPinnedTag(repoName string) (string, error) {
 	return rtn.PinnedTag, err
 }
 
-func (client *PostgresClient) GetAllTags() (map[string]string, error) {
+func (client *PostgresClient) GetAllTags(ctx context.Context) (map[string]string, error) {
 	var rows []DeployedRepositoryVersionRow
 	sqlStatement := "select * from deployed_repository_version"
 
-	err := client.db.Select(&rows, sqlStatement)
+	err := client.db.SelectContext(ctx, &rows, sqlStatement)
 	rtn := make(map[string]string)
 	for _, row := range rows {
 		rtn[row.RepositoryName] = row.PinnedTag
@@ -177,6 +257,406 @@ func (client *PostgresClient) GetAllTags() (map[string]string, error) {
 	return rtn, err
 }
 
+// One deployment of a watched repository
+type DeploymentHistoryRow struct {
+	ID             int64      `json:"id" db:"id"`
+	RepositoryName string     `json:"repository_name" db:"repository_name"`
+	FromTag        string     `json:"from_tag" db:"from_tag"`
+	ToTag          string     `json:"to_tag" db:"to_tag"`
+	Digest         string     `json:"digest" db:"digest"`
+	Trigger        string     `json:"trigger" db:"trigger"`
+	Requester      string     `json:"requester" db:"requester"`
+	StartedAt      time.Time  `json:"started_at" db:"started_at"`
+	FinishedAt     *time.Time `json:"finished_at" db:"finished_at"`
+	Outcome        string     `json:"outcome" db:"outcome"`
+	Description    string     `json:"description" db:"description"`
+}
+
+// Records the start of a deployment and returns the ID of its history row
+func (client *PostgresClient) InsertDeploymentHistory(ctx context.Context, row DeploymentHistoryRow) (int64, error) {
+	insert := `
+          INSERT INTO deployment_history
+            (repository_name, from_tag, to_tag, digest, trigger, requester, started_at, outcome, description)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id;`
+
+	var id int64
+	err := client.db.QueryRowContext(ctx, insert,
+		row.RepositoryName, row.FromTag, row.ToTag, row.Digest, row.Trigger,
+		row.Requester, row.StartedAt, row.Outcome, row.Description).Scan(&id)
+	if err != nil {
+		return 0, errors.Wrapf(err, "issue inserting deployment history for repoName [%s]", row.RepositoryName)
+	}
+	return id, nil
+}
+
+// Records the outcome of the deployment with history row id
+func (client *PostgresClient) FinishDeploymentHistory(ctx context.Context, id int64, outcome, description string, finishedAt time.Time) error {
+	update := `
+          UPDATE deployment_history SET outcome = $2, description = $3, finished_at = $4 WHERE id = $1;`
+
+	if _, err := client.db.ExecContext(ctx, update, id, outcome, description, finishedAt); err != nil {
+		return errors.Wrapf(err, "issue updating deployment history with id [%d]", id)
+	}
+	return nil
+}
+
+// Returns a page of repoName's deployment history, most recent first,
+// along with the total number of deployments recorded for repoName
+func (client *PostgresClient) GetDeploymentHistory(ctx context.Context, repoName string, limit, offset int) ([]DeploymentHistoryRow, int, error) {
+	var total int
+	err := client.db.GetContext(ctx, &total, "select count(*) from deployment_history where repository_name = $1", repoName)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "issue counting deployment history with repoName [%s]", repoName)
+	}
+
+	rows := []DeploymentHistoryRow{}
+	sqlStatement := `
+          select * from deployment_history where repository_name = $1
+            order by started_at desc, id desc limit $2 offset $3`
+	if err = client.db.SelectContext(ctx, &rows, sqlStatement, repoName, limit, offset); err != nil {
+		return nil, 0, errors.Wrapf(err, "issue getting deployment history with repoName [%s]", repoName)
+	}
+	return rows, total, nil
+}
+
+// Returns the deployment history row with id
+func (client *PostgresClient) GetDeploymentHistoryEntry(ctx context.Context, id int64) (DeploymentHistoryRow, error) {
+	var rtn DeploymentHistoryRow
+	err := client.db.GetContext(ctx, &rtn, "select * from deployment_history where id = $1", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return rtn, errors.Wrapf(err, "deployment history with id %d not found", id)
+		}
+		return rtn, errors.Wrapf(err, "issue getting deployment history with id [%d]", id)
+	}
+	return rtn, nil
+}
+
+// Returns the most recent successful deployment of repoName to a tag other than excludeTag
+func (client *PostgresClient) GetLastSuccessfulDeployment(ctx context.Context, repoName, excludeTag string) (DeploymentHistoryRow, error) {
+	var rtn DeploymentHistoryRow
+	sqlStatement := `
+          select * from deployment_history
+            where repository_name = $1 and outcome = $2 and to_tag <> $3
+            order by started_at desc, id desc limit 1`
+	err := client.db.GetContext(ctx, &rtn, sqlStatement, repoName, "successful", excludeTag)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return rtn, errors.Wrapf(err, "no successful deployment of a tag other than %s found for repoName %s", excludeTag, repoName)
+		}
+		return rtn, errors.Wrapf(err, "issue getting last successful deployment with repoName [%s]", repoName)
+	}
+	return rtn, nil
+}
+
+// A cron schedule of minutes in which repositories may be auto deployed
+type DeploymentWindowRow struct {
+	ID int64 `json:"id" db:"id"`
+	// empty for windows that apply to every repository
+	RepositoryName string    `json:"repository_name" db:"repository_name"`
+	Schedule       string    `json:"schedule" db:"schedule"`
+	Timezone       string    `json:"timezone" db:"timezone"`
+	CreatedBy      string    `json:"created_by" db:"created_by"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// A period in which repositories must not be auto deployed
+type DeploymentFreezeRow struct {
+	ID int64 `json:"id" db:"id"`
+	// empty for freezes that apply to every repository
+	RepositoryName string    `json:"repository_name" db:"repository_name"`
+	StartsAt       time.Time `json:"starts_at" db:"starts_at"`
+	EndsAt         time.Time `json:"ends_at" db:"ends_at"`
+	Reason         string    `json:"reason" db:"reason"`
+	CreatedBy      string    `json:"created_by" db:"created_by"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+func (client *PostgresClient) InsertDeploymentWindow(ctx context.Context, row DeploymentWindowRow) (int64, error) {
+	insert := `
+          INSERT INTO deployment_windows (repository_name, schedule, timezone, created_by, created_at)
+            VALUES ($1, $2, $3, $4, $5) RETURNING id;`
+
+	var id int64
+	err := client.db.QueryRowContext(ctx, insert,
+		row.RepositoryName, row.Schedule, row.Timezone, row.CreatedBy, row.CreatedAt).Scan(&id)
+	if err != nil {
+		return 0, errors.Wrapf(err, "issue inserting deployment window for repoName [%s]", row.RepositoryName)
+	}
+	return id, nil
+}
+
+// Returns every deployment window, or if repoName is set, the windows
+// of repoName along with the global ones
+func (client *PostgresClient) GetDeploymentWindows(ctx context.Context, repoName string) ([]DeploymentWindowRow, error) {
+	rows := []DeploymentWindowRow{}
+	sqlStatement := `
+          select * from deployment_windows
+            where $1 = '' or repository_name = $1 or repository_name = '' order by id`
+	if err := client.db.SelectContext(ctx, &rows, sqlStatement, repoName); err != nil {
+		return nil, errors.Wrapf(err, "issue getting deployment windows with repoName [%s]", repoName)
+	}
+	return rows, nil
+}
+
+// Returns whether a window with id existed
+func (client *PostgresClient) DeleteDeploymentWindow(ctx context.Context, id int64) (bool, error) {
+	res, err := client.db.ExecContext(ctx, "delete from deployment_windows where id = $1", id)
+	if err != nil {
+		return false, errors.Wrapf(err, "issue deleting deployment window with id [%d]", id)
+	}
+	deleted, err := res.RowsAffected()
+	return deleted > 0, errors.WithStack(err)
+}
+
+func (client *PostgresClient) InsertDeploymentFreeze(ctx context.Context, row DeploymentFreezeRow) (int64, error) {
+	insert := `
+          INSERT INTO deployment_freezes (repository_name, starts_at, ends_at, reason, created_by, created_at)
+            VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`
+
+	var id int64
+	err := client.db.QueryRowContext(ctx, insert,
+		row.RepositoryName, row.StartsAt, row.EndsAt, row.Reason, row.CreatedBy, row.CreatedAt).Scan(&id)
+	if err != nil {
+		return 0, errors.Wrapf(err, "issue inserting deployment freeze for repoName [%s]", row.RepositoryName)
+	}
+	return id, nil
+}
+
+// Returns the freezes that haven't ended by after, for every repository or
+// if repoName is set, those of repoName along with the global ones
+func (client *PostgresClient) GetDeploymentFreezes(ctx context.Context, repoName string, after time.Time) ([]DeploymentFreezeRow, error) {
+	rows := []DeploymentFreezeRow{}
+	sqlStatement := `
+          select * from deployment_freezes
+            where ($1 = '' or repository_name = $1 or repository_name = '') and ends_at > $2
+            order by starts_at, id`
+	if err := client.db.SelectContext(ctx, &rows, sqlStatement, repoName, after); err != nil {
+		return nil, errors.Wrapf(err, "issue getting deployment freezes with repoName [%s]", repoName)
+	}
+	return rows, nil
+}
+
+// Returns whether a freeze with id existed
+func (client *PostgresClient) DeleteDeploymentFreeze(ctx context.Context, id int64) (bool, error) {
+	res, err := client.db.ExecContext(ctx, "delete from deployment_freezes where id = $1", id)
+	if err != nil {
+		return false, errors.Wrapf(err, "issue deleting deployment freeze with id [%d]", id)
+	}
+	deleted, err := res.RowsAffected()
+	return deleted > 0, errors.WithStack(err)
+}
+
+const (
+	PendingDeploymentPending    = "pending"
+	PendingDeploymentApproved   = "approved"
+	PendingDeploymentRejected   = "rejected"
+	PendingDeploymentExpired    = "expired"
+	PendingDeploymentSuperseded = "superseded"
+)
+
+// An auto deployment waiting for approval
+type PendingDeploymentRow struct {
+	ID             int64      `json:"id" db:"id"`
+	RepositoryName string     `json:"repository_name" db:"repository_name"`
+	Tag            string     `json:"tag" db:"tag"`
+	Digest         string     `json:"digest" db:"digest"`
+	Trigger        string     `json:"trigger" db:"trigger"`
+	Status         string     `json:"status" db:"status"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt      time.Time  `json:"expires_at" db:"expires_at"`
+	DecidedBy      string     `json:"decided_by" db:"decided_by"`
+	DecidedAt      *time.Time `json:"decided_at" db:"decided_at"`
+}
+
+// Records a pending deployment, superseding the ones of the same repository
+// that are still pending, and returns the ID of its row
+func (client *PostgresClient) InsertPendingDeployment(ctx context.Context, row PendingDeploymentRow) (int64, error) {
+	supersede := `
+          UPDATE pending_deployments SET status = $2 WHERE repository_name = $1 AND status = $3;`
+	insert := `
+          INSERT INTO pending_deployments (repository_name, tag, digest, trigger, status, created_at, expires_at)
+            VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id;`
+
+	tx, err := client.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if _, err = tx.ExecContext(ctx, supersede, row.RepositoryName, PendingDeploymentSuperseded, PendingDeploymentPending); err != nil {
+		tx.Rollback()
+		return 0, errors.Wrapf(err, "issue superseding pending deployments with repoName [%s]", row.RepositoryName)
+	}
+	var id int64
+	err = tx.QueryRowContext(ctx, insert,
+		row.RepositoryName, row.Tag, row.Digest, row.Trigger,
+		PendingDeploymentPending, row.CreatedAt, row.ExpiresAt).Scan(&id)
+	if err != nil {
+		tx.Rollback()
+		return 0, errors.Wrapf(err, "issue inserting pending deployment for repoName [%s]", row.RepositoryName)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return id, nil
+}
+
+// Returns the deployments still pending, for every repository or only repoName's if set
+func (client *PostgresClient) GetPendingDeployments(ctx context.Context, repoName string) ([]PendingDeploymentRow, error) {
+	rows := []PendingDeploymentRow{}
+	sqlStatement := `
+          select * from pending_deployments
+            where ($1 = '' or repository_name = $1) and status = $2 order by id`
+	if err := client.db.SelectContext(ctx, &rows, sqlStatement, repoName, PendingDeploymentPending); err != nil {
+		return nil, errors.Wrapf(err, "issue getting pending deployments with repoName [%s]", repoName)
+	}
+	return rows, nil
+}
+
+// Returns the pending deployment row with id, whatever its status
+func (client *PostgresClient) GetPendingDeployment(ctx context.Context, id int64) (PendingDeploymentRow, error) {
+	var rtn PendingDeploymentRow
+	err := client.db.GetContext(ctx, &rtn, "select * from pending_deployments where id = $1", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return rtn, errors.Wrapf(err, "pending deployment with id %d not found", id)
+		}
+		return rtn, errors.Wrapf(err, "issue getting pending deployment with id [%d]", id)
+	}
+	return rtn, nil
+}
+
+// Sets the status of the pending deployment with id if it is still pending,
+// and returns whether it was. Only one caller can decide a pending deployment.
+func (client *PostgresClient) DecidePendingDeployment(ctx context.Context, id int64, status, decidedBy string, decidedAt time.Time) (bool, error) {
+	update := `
+          UPDATE pending_deployments SET status = $2, decided_by = $3, decided_at = $4
+            WHERE id = $1 AND status = $5;`
+	res, err := client.db.ExecContext(ctx, update, id, status, decidedBy, decidedAt, PendingDeploymentPending)
+	if err != nil {
+		return false, errors.Wrapf(err, "issue updating pending deployment with id [%d]", id)
+	}
+	updated, err := res.RowsAffected()
+	return updated > 0, errors.WithStack(err)
+}
+
+// Marks repoName's pending deployments as superseded, e.g. once it has been deployed
+func (client *PostgresClient) SupersedePendingDeployments(ctx context.Context, repoName string) error {
+	update := `
+          UPDATE pending_deployments SET status = $2 WHERE repository_name = $1 AND status = $3;`
+	if _, err := client.db.ExecContext(ctx, update, repoName, PendingDeploymentSuperseded, PendingDeploymentPending); err != nil {
+		return errors.Wrapf(err, "issue superseding pending deployments with repoName [%s]", repoName)
+	}
+	return nil
+}
+
+// Marks repoName's pending deployments that expired by now as expired, and returns them
+func (client *PostgresClient) ExpirePendingDeployments(ctx context.Context, repoName string, now time.Time) ([]PendingDeploymentRow, error) {
+	rows := []PendingDeploymentRow{}
+	update := `
+          UPDATE pending_deployments SET status = $2, decided_at = $3
+            WHERE repository_name = $1 AND status = $4 AND expires_at <= $3 RETURNING *;`
+	if err := client.db.SelectContext(ctx, &rows, update, repoName, PendingDeploymentExpired, now, PendingDeploymentPending); err != nil {
+		return nil, errors.Wrapf(err, "issue expiring pending deployments with repoName [%s]", repoName)
+	}
+	return rows, nil
+}
+
+// A Postgres session level advisory lock. It is held on its own
+// connection, so it is released if that connection is lost.
+type AdvisoryLock struct {
+	name string
+	key  int64
+	conn *sql.Conn
+}
+
+// Tries to take the advisory lock identified by name without waiting.
+// Returns a nil lock if another session holds it.
+func (client *PostgresClient) TryAdvisoryLock(ctx context.Context, name string) (*AdvisoryLock, error) {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	key := int64(h.Sum64())
+
+	conn, err := client.db.Conn(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get postgres connection for advisory lock")
+	}
+	var acquired bool
+	if err = conn.QueryRowContext(ctx, "select pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "issue taking advisory lock [%s]", name)
+	}
+	if !acquired {
+		conn.Close()
+		return nil, nil
+	}
+	return &AdvisoryLock{name: name, key: key, conn: conn}, nil
+}
+
+// Whether the lock is still held, i.e. its connection is alive
+func (lock *AdvisoryLock) IsHeld(ctx context.Context) bool {
+	return lock.conn.PingContext(ctx) == nil
+}
+
+// Releases the lock. It does not take a context so that it can still be
+// released while shutting down, the connection is closed regardless.
+func (lock *AdvisoryLock) Release() error {
+	defer lock.conn.Close()
+	if _, err := lock.conn.ExecContext(context.Background(), "select pg_advisory_unlock($1)", lock.key); err != nil {
+		return errors.Wrapf(err, "issue releasing advisory lock [%s]", lock.name)
+	}
+	return nil
+}
+
+// Tells the listening replicas that repoName was pushed to
+func (client *PostgresClient) NotifyPush(ctx context.Context, repoName string) error {
+	if _, err := client.db.ExecContext(ctx, "select pg_notify($1, $2)", pushChannel, repoName); err != nil {
+		return errors.Wrapf(err, "issue notifying push to [%s]", repoName)
+	}
+	return nil
+}
+
+// Tells the listening replicas that repoName was deployed
+func (client *PostgresClient) NotifyDeploy(ctx context.Context, repoName string) error {
+	if _, err := client.db.ExecContext(ctx, "select pg_notify($1, $2)", deployChannel, repoName); err != nil {
+		return errors.Wrapf(err, "issue notifying deployment of [%s]", repoName)
+	}
+	return nil
+}
+
+// Calls onPush with the repository of each push, and onDeploy with the
+// repository of each deployment, notified by a replica until ctx is done.
+// Notifications sent while the connection is re-established are lost.
+func (client *PostgresClient) ListenForReplicas(ctx context.Context, onPush, onDeploy func(repoName string)) error {
+	listener := pq.NewListener(client.dburl, 10*time.Second, time.Minute, nil)
+	defer listener.Close()
+	for _, channel := range []string{pushChannel, deployChannel} {
+		if err := listener.Listen(channel); err != nil {
+			return errors.Wrapf(err, "unable to listen on [%s]", channel)
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case notification := <-listener.Notify:
+			// nil after the connection was re-established
+			if notification == nil {
+				continue
+			}
+			if notification.Channel == pushChannel {
+				onPush(notification.Extra)
+			} else {
+				onDeploy(notification.Extra)
+			}
+		case <-time.After(90 * time.Second):
+			// notices a dead connection that no notification arrives on
+			go listener.Ping()
+		}
+	}
+}
+
 const InsertRowSql = `
 INSERT INTO deployed_repository_version
   (repository_name, pinned_tag, auto_deploy) VALUES ($1, $2, $3)
@@ -187,4 +667,62 @@ CREATE TABLE IF NOT EXISTS deployed_repository_version (
   repository_name character varying NOT NULL PRIMARY KEY UNIQUE,
   pinned_tag character varying NOT NULL,
   auto_deploy boolean NOT NULL default true
-);`
+);
+
+ALTER TABLE deployed_repository_version
+  ADD COLUMN IF NOT EXISTS version_constraint character varying NOT NULL default '';
+
+ALTER TABLE deployed_repository_version
+  ADD COLUMN IF NOT EXISTS deferred_trigger character varying NOT NULL default '';
+
+CREATE TABLE IF NOT EXISTS deployment_history (
+  id bigserial PRIMARY KEY,
+  repository_name character varying NOT NULL,
+  from_tag character varying NOT NULL default '',
+  to_tag character varying NOT NULL,
+  digest character varying NOT NULL default '',
+  trigger character varying NOT NULL,
+  requester character varying NOT NULL default '',
+  started_at timestamp with time zone NOT NULL,
+  finished_at timestamp with time zone,
+  outcome character varying NOT NULL,
+  description character varying NOT NULL default ''
+);
+
+CREATE INDEX IF NOT EXISTS deployment_history_repository_name_idx
+  ON deployment_history (repository_name, started_at DESC);
+
+CREATE TABLE IF NOT EXISTS deployment_windows (
+  id bigserial PRIMARY KEY,
+  repository_name character varying NOT NULL default '',
+  schedule character varying NOT NULL,
+  timezone character varying NOT NULL default 'UTC',
+  created_by character varying NOT NULL default '',
+  created_at timestamp with time zone NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS deployment_freezes (
+  id bigserial PRIMARY KEY,
+  repository_name character varying NOT NULL default '',
+  starts_at timestamp with time zone NOT NULL,
+  ends_at timestamp with time zone NOT NULL,
+  reason character varying NOT NULL default '',
+  created_by character varying NOT NULL default '',
+  created_at timestamp with time zone NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS pending_deployments (
+  id bigserial PRIMARY KEY,
+  repository_name character varying NOT NULL,
+  tag character varying NOT NULL,
+  digest character varying NOT NULL default '',
+  trigger character varying NOT NULL,
+  status character varying NOT NULL,
+  created_at timestamp with time zone NOT NULL,
+  expires_at timestamp with time zone NOT NULL,
+  decided_by character varying NOT NULL default '',
+  decided_at timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS pending_deployments_repository_name_idx
+  ON pending_deployments (repository_name, status);`
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -29,15 +30,15 @@ type testEngine struct {
 }
 
 func (te *testEngine) printState() {
-	registryTags, _ := te.Clients.DockerRegistryClient.GetAllTags(te.TestRepoName)
+	registryTags, _ := te.Clients.DockerRegistryClient.GetAllTags(context.Background(), te.TestRepoName)
 	fmt.Println("registry tags", registryTags)
 
 	cachedTags, _ := te.Clients.GetCachedTags(te.TestRepoName)
 	fmt.Println("cached tags", cachedTags)
 
-	pinnedTag, _ := te.Clients.GetFormattedPinnedTag(te.TestRepoName)
-	tagDigest, _ := te.Clients.DockerhubApi.GetTagDigestFromApi(te.TestRepoName, pinnedTag)
-	fmt.Println("new tag digest", *tagDigest)
+	pinnedTag, _ := te.Clients.GetFormattedPinnedTag(context.Background(), te.TestRepoName)
+	tagDigest, _ := te.Clients.DockerRegistryClient.GetTagDigest(context.Background(), te.TestRepoName, pinnedTag)
+	fmt.Println("new tag digest", tagDigest)
 
 	cachedTagDigest, _ := te.Clients.GetCachedTagDigest(te.TestRepoName)
 	fmt.Println("cached tag digest", cachedTagDigest)
@@ -133,8 +134,8 @@ func SetUpClientTest(t *testing.T) *testEngine {
 }
 
 func (te *testEngine) RegisterJob() {
-	jobID := utils.GetRepoNomadJob(te.Conf, te.TestRepoName)
-	tags, _ := te.Clients.DockerRegistryClient.GetAllTags(te.TestRepoName)
+	jobID, _ := utils.GetRepoNomadJob(te.Conf, te.TestRepoName)
+	tags, _ := te.Clients.DockerRegistryClient.GetAllTags(context.Background(), te.TestRepoName)
 	dockerImage := fmt.Sprintf("%s:%s", te.TestRepoName, tags[0])
 	job := testJob(jobID, dockerImage)
 	jobs := te.Clients.NomadClient.nc.Jobs()
@@ -220,7 +221,7 @@ func (te *testEngine) PushNewTag(namedTag, actualTag string) {
 }
 
 func (te *testEngine) UpdatePinnedTag(newTag string) {
-	err := te.Clients.PostgresClient.UpdatePinnedTag(te.TestRepoName, newTag)
+	err := te.Clients.PostgresClient.UpdatePinnedTag(context.Background(), te.TestRepoName, newTag)
 	if err != nil {
 		panic(fmt.Errorf("couldn't update postgres client pinned_tag: %v", err))
 	}
@@ -0,0 +1,100 @@
+//go:build unit
+// +build unit
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dsaidgovsg/registrywatcher/registry"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// Sets up Clients whose registry always fails and whose Dockerhub API knows
+// the digest of v1.0.0, returning whether the Dockerhub API was called
+func setUpDigestFallbackTest(t *testing.T, conf *viper.Viper) (*Clients, *bool) {
+	registryServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(registryServer.Close)
+	hubCalled := false
+	hubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hubCalled = true
+		w.Write([]byte(`{"results": [{"digest": "sha256:index", "tags": [{"tag": "v1.0.0", "is_current": true}]}]}`))
+	}))
+	t.Cleanup(hubServer.Close)
+
+	conf.Set("watched_repositories", []string{"api"})
+	conf.Set("repo_map.api.registry_name", "hub")
+	conf.Set("registry_map.hub.registry_prefix", "library")
+	clients := &Clients{
+		DockerRegistryClient: &DockerRegistryClient{
+			Hubs: map[string]registry.Registry{
+				"api": {
+					URL:    registryServer.URL,
+					Client: &http.Client{Transport: &registry.ErrorTransport{Transport: http.DefaultTransport}},
+					Logf:   registry.Quiet,
+				},
+			},
+			conf: conf,
+		},
+		DockerhubApi: &DockerhubApi{url: hubServer.URL, namespace: "library"},
+		conf:         conf,
+	}
+	return clients, &hubCalled
+}
+
+func TestGetTagDigestFallsBackToDockerhub(t *testing.T) {
+	clients, hubCalled := setUpDigestFallbackTest(t, viper.New())
+	digest, err := clients.getTagDigest(context.Background(), "api", "v1.0.0")
+	assert.Nil(t, err)
+	assert.Equal(t, "sha256:index", digest)
+	assert.True(t, *hubCalled)
+}
+
+func TestGetTagDigestSkipsDockerhubForPlatform(t *testing.T) {
+	conf := viper.New()
+	conf.Set("repo_map.api.platform", "linux/arm64")
+	clients, hubCalled := setUpDigestFallbackTest(t, conf)
+	_, err := clients.getTagDigest(context.Background(), "api", "v1.0.0")
+	assert.NotNil(t, err)
+	assert.False(t, *hubCalled)
+}
+
+type fakeDigestDeployer struct {
+	Deployer
+	tag    string
+	digest string
+}
+
+func (deployer *fakeDigestDeployer) GetDeployedTag(ctx context.Context, repoName string) (string, error) {
+	return deployer.tag, nil
+}
+
+func (deployer *fakeDigestDeployer) GetDeployedDigest(ctx context.Context, repoName string) (string, error) {
+	return deployer.digest, nil
+}
+
+func TestIsTagDeployed(t *testing.T) {
+	conf := viper.New()
+	clients, _ := setUpDigestFallbackTest(t, conf)
+	ctx := context.Background()
+
+	deployed, err := clients.isTagDeployed(ctx, &fakeDigestDeployer{tag: "v1.0.0", digest: "sha256:old"}, "api", "v1.0.0")
+	assert.Nil(t, err)
+	assert.True(t, deployed)
+
+	// the tag was overwritten since it was deployed
+	conf.Set("repo_map.api.deploy_by_digest", true)
+	deployed, err = clients.isTagDeployed(ctx, &fakeDigestDeployer{tag: "v1.0.0", digest: "sha256:old"}, "api", "v1.0.0")
+	assert.Nil(t, err)
+	assert.False(t, deployed)
+
+	deployed, err = clients.isTagDeployed(ctx, &fakeDigestDeployer{digest: "sha256:index"}, "api", "v1.0.0")
+	assert.Nil(t, err)
+	assert.True(t, deployed)
+}
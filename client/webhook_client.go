@@ -0,0 +1,216 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dsaidgovsg/registrywatcher/log"
+	"github.com/dsaidgovsg/registrywatcher/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+const WebhookDeployer = "webhook"
+
+// Hands deployments over to an external system by POSTing to a per repository URL
+type WebhookClient struct {
+	httpClient   *http.Client
+	conf         *viper.Viper
+	pollInterval time.Duration
+	timeout      time.Duration
+}
+
+// Body of the deploy request
+type WebhookPayload struct {
+	Repository string `json:"repository"`
+	OldTag     string `json:"old_tag"`
+	NewTag     string `json:"new_tag"`
+	Digest     string `json:"digest"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// Optional body of the deploy response, and the body of status responses
+type WebhookStatus struct {
+	Status      string `json:"status"`
+	Description string `json:"description"`
+	// only read from the deploy response, overrides deploy_webhook_status_url
+	StatusURL string `json:"status_url"`
+	// only read from status responses, the currently deployed tag
+	Tag string `json:"tag"`
+}
+
+func InitializeWebhookClient(conf *viper.Viper) *WebhookClient {
+	return &WebhookClient{
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
+		conf:         conf,
+		pollInterval: 5 * time.Second,
+		timeout:      defaultDeploymentTimeout,
+	}
+}
+
+// Returns the tag reported by deploy_webhook_status_url
+func (client *WebhookClient) GetDeployedTag(ctx context.Context, repoName string) (string, error) {
+	statusURL := utils.GetRepoSetting(client.conf, repoName, "deploy_webhook_status_url")
+	if statusURL == "" {
+		return "", fmt.Errorf("deploy_webhook_status_url is not set for repo %s, deployed tag is unknown", repoName)
+	}
+	status, err := client.getStatus(ctx, statusURL)
+	if err != nil {
+		return "", err
+	}
+	return status.Tag, nil
+}
+
+/*
+ * POSTs a signed WebhookPayload to deploy_webhook_url. If neither the
+ * response nor the config has a status URL to poll, a 2xx response is taken
+ * to mean the deployment succeeded.
+ */
+func (client *WebhookClient) Deploy(ctx context.Context, request DeployRequest) (*Deployment, error) {
+	url := utils.GetRepoSetting(client.conf, request.RepoName, "deploy_webhook_url")
+	if url == "" {
+		return nil, fmt.Errorf("deploy_webhook_url is not set for repo %s", request.RepoName)
+	}
+	payload, err := json.Marshal(WebhookPayload{
+		Repository: request.RepoName,
+		OldTag:     request.PreviousTag,
+		NewTag:     request.Tag,
+		Digest:     request.Digest,
+		Timestamp:  time.Now().Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret := utils.GetRepoSetting(client.conf, request.RepoName, "deploy_webhook_secret"); secret != "" {
+		req.Header.Set(utils.WebhookSignatureHeader, utils.SignWebhookPayload(secret, payload))
+	}
+	log.LogAppInfo(fmt.Sprintf("Posting deployment of %s:%s to webhook %s", request.RepoName, request.Tag, url))
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, errors.New(fmt.Sprintf("Response status %d message %s", resp.StatusCode, string(body)))
+	}
+
+	// the response body is optional, so decoding errors are ignored
+	var status WebhookStatus
+	json.Unmarshal(body, &status)
+	if webhookStatusToDeploymentStatus(status.Status) == DeploymentFailed {
+		return nil, errors.New(fmt.Sprintf("webhook rejected deployment: %s", status.Description))
+	}
+
+	statusURL := status.StatusURL
+	if statusURL == "" {
+		statusURL = utils.GetRepoSetting(client.conf, request.RepoName, "deploy_webhook_status_url")
+	}
+	return &Deployment{
+		RepoName: request.RepoName,
+		Tag:      request.Tag,
+		Target:   fmt.Sprintf("webhook `%s`", url),
+		ID:       statusURL,
+	}, nil
+}
+
+/*
+ * Polls the status URL of the deployment, if any. A status only settles the
+ * deployment once it reports the deployment's tag, since deploy_webhook_status_url
+ * still reports the outcome of the previous rollout at first. A status URL
+ * returned for this deployment alone may leave the tag out.
+ */
+func (client *WebhookClient) WaitForDeployment(ctx context.Context, deployment *Deployment) DeploymentOutcome {
+	if deployment.ID == "" {
+		return DeploymentOutcome{Status: DeploymentSuccessful, Description: "accepted by webhook"}
+	}
+	sharedStatusURL := deployment.ID == utils.GetRepoSetting(client.conf, deployment.RepoName, "deploy_webhook_status_url")
+
+	lastStatus := WebhookStatus{Status: "pending"}
+	ticker := time.NewTicker(client.pollInterval)
+	defer ticker.Stop()
+	timeout := time.After(client.timeout)
+	for {
+		select {
+		case <-ctx.Done():
+			return DeploymentOutcome{
+				Status:      DeploymentInterrupted,
+				Description: fmt.Sprintf("webhook status is `%s`", lastStatus.Status),
+			}
+		case <-timeout:
+			return DeploymentOutcome{
+				Status:      DeploymentTimedOut,
+				Description: fmt.Sprintf("webhook status is `%s`", lastStatus.Status),
+			}
+		case <-ticker.C:
+			status, err := client.getStatus(ctx, deployment.ID)
+			if err != nil {
+				log.LogAppErr(fmt.Sprintf("Couldn't fetch webhook deployment status for %s", deployment.RepoName), err)
+				continue
+			}
+			lastStatus = *status
+			if status.Tag != deployment.Tag && (sharedStatusURL || status.Tag != "") {
+				continue
+			}
+			switch webhookStatusToDeploymentStatus(status.Status) {
+			case DeploymentSuccessful:
+				return DeploymentOutcome{Status: DeploymentSuccessful, Description: status.Description}
+			case DeploymentFailed:
+				return DeploymentOutcome{Status: DeploymentFailed, Description: status.Description}
+			}
+		}
+	}
+}
+
+func (client *WebhookClient) getStatus(ctx context.Context, url string) (*WebhookStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("Response status %d message %s", resp.StatusCode, string(body)))
+	}
+	var status WebhookStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Returns an empty status for values that mean the deployment is still in progress
+func webhookStatusToDeploymentStatus(status string) DeploymentStatus {
+	switch strings.ToLower(status) {
+	case "success", "successful", "succeeded":
+		return DeploymentSuccessful
+	case "failure", "failed", "error":
+		return DeploymentFailed
+	default:
+		return ""
+	}
+}
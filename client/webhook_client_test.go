@@ -0,0 +1,146 @@
+//go:build unit
+// +build unit
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dsaidgovsg/registrywatcher/utils"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func setUpWebhookTest(t *testing.T, handler http.HandlerFunc) (*WebhookClient, *httptest.Server) {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	conf := viper.New()
+	conf.SetConfigType("toml")
+	err := conf.ReadConfig(strings.NewReader(`
+[repo_map.hooked]
+registry_name = "localregistry"
+deployer = "webhook"
+deploy_webhook_secret = "secret"
+`))
+	if err != nil {
+		t.Fatalf("couldn't read config: %v", err)
+	}
+	conf.Set("repo_map.hooked.deploy_webhook_url", ts.URL+"/deploy")
+
+	deployer := InitializeWebhookClient(conf)
+	deployer.pollInterval = 10 * time.Millisecond
+	deployer.timeout = time.Second
+	return deployer, ts
+}
+
+func TestWebhookDeploy(t *testing.T) {
+	polls := 0
+	deployer, ts := setUpWebhookTest(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/deploy":
+			body, _ := ioutil.ReadAll(r.Body)
+			assert.Equal(t, utils.SignWebhookPayload("secret", body), r.Header.Get(utils.WebhookSignatureHeader))
+
+			var payload WebhookPayload
+			assert.Nil(t, json.Unmarshal(body, &payload))
+			assert.Equal(t, WebhookPayload{
+				Repository: "hooked",
+				OldTag:     "v1.0.0",
+				NewTag:     "v1.1.0",
+				Digest:     "sha256:abc",
+				Timestamp:  payload.Timestamp,
+			}, payload)
+			w.Write([]byte(`{"status": "pending", "status_url": "` + "http://" + r.Host + `/status"}`))
+		case "/status":
+			polls++
+			if polls < 3 {
+				w.Write([]byte(`{"status": "running"}`))
+			} else {
+				w.Write([]byte(`{"status": "succeeded", "description": "all good", "tag": "v1.1.0"}`))
+			}
+		}
+	})
+
+	deployment, err := deployer.Deploy(context.Background(), DeployRequest{
+		RepoName:    "hooked",
+		Tag:         "v1.1.0",
+		PreviousTag: "v1.0.0",
+		Digest:      "sha256:abc",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, ts.URL+"/status", deployment.ID)
+
+	outcome := deployer.WaitForDeployment(context.Background(), deployment)
+	assert.Equal(t, DeploymentOutcome{Status: DeploymentSuccessful, Description: "all good"}, outcome)
+	assert.Equal(t, 3, polls)
+
+	deployer.conf.Set("repo_map.hooked.deploy_webhook_status_url", ts.URL+"/status")
+	tag, err := deployer.GetDeployedTag(context.Background(), "hooked")
+	assert.Nil(t, err)
+	assert.Equal(t, "v1.1.0", tag)
+}
+
+func TestWebhookDeployWithoutStatus(t *testing.T) {
+	status := http.StatusAccepted
+	deployer, _ := setUpWebhookTest(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	})
+
+	deployment, err := deployer.Deploy(context.Background(), DeployRequest{RepoName: "hooked", Tag: "v1.1.0"})
+	assert.Nil(t, err)
+	outcome := deployer.WaitForDeployment(context.Background(), deployment)
+	assert.Equal(t, DeploymentSuccessful, outcome.Status)
+
+	_, err = deployer.GetDeployedTag(context.Background(), "hooked")
+	assert.NotNil(t, err)
+
+	status = http.StatusInternalServerError
+	_, err = deployer.Deploy(context.Background(), DeployRequest{RepoName: "hooked", Tag: "v1.1.0"})
+	assert.NotNil(t, err)
+}
+
+func TestWebhookWaitForDeploymentInterrupted(t *testing.T) {
+	deployer, ts := setUpWebhookTest(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "running"}`))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	outcome := deployer.WaitForDeployment(ctx, &Deployment{RepoName: "hooked", ID: ts.URL + "/status"})
+	assert.Equal(t, DeploymentOutcome{Status: DeploymentInterrupted, Description: "webhook status is `running`"}, outcome)
+}
+
+func TestWebhookWaitForDeploymentIgnoresPreviousRollout(t *testing.T) {
+	polls := 0
+	deployer, ts := setUpWebhookTest(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/deploy":
+			w.WriteHeader(http.StatusAccepted)
+		case "/status":
+			polls++
+			switch {
+			case polls < 3:
+				w.Write([]byte(`{"status": "success", "tag": "v1.0.0"}`))
+			case polls < 5:
+				w.Write([]byte(`{"status": "success"}`))
+			default:
+				w.Write([]byte(`{"status": "failure", "description": "crash looping", "tag": "v1.1.0"}`))
+			}
+		}
+	})
+	deployer.conf.Set("repo_map.hooked.deploy_webhook_status_url", ts.URL+"/status")
+
+	deployment, err := deployer.Deploy(context.Background(), DeployRequest{RepoName: "hooked", Tag: "v1.1.0", PreviousTag: "v1.0.0"})
+	assert.Nil(t, err)
+	outcome := deployer.WaitForDeployment(context.Background(), deployment)
+	assert.Equal(t, DeploymentOutcome{Status: DeploymentFailed, Description: "crash looping"}, outcome)
+	assert.Equal(t, 5, polls)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dsaidgovsg/registrywatcher/client"
+	"github.com/dsaidgovsg/registrywatcher/log"
+	"github.com/gin-gonic/gin"
+)
+
+type deploymentWindowBody struct {
+	// empty for a window that applies to every repository
+	RepositoryName string `json:"repository_name"`
+	Schedule       string `json:"schedule" binding:"required"`
+	// defaults to UTC
+	Timezone string `json:"timezone"`
+}
+
+type deploymentFreezeBody struct {
+	// empty for a freeze that applies to every repository
+	RepositoryName string    `json:"repository_name"`
+	StartsAt       time.Time `json:"starts_at" binding:"required"`
+	EndsAt         time.Time `json:"ends_at" binding:"required"`
+	Reason         string    `json:"reason"`
+}
+
+// whether repoName is empty, meaning every repository, or is watched
+func (h *Handler) isWatchedOrGlobal(repoName string) bool {
+	if repoName == "" {
+		return true
+	}
+	for _, repo := range h.conf.GetStringSlice("watched_repositories") {
+		if repo == repoName {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) GetDeploymentWindowsHandler(c *gin.Context) {
+	repoName := c.Query("repo_name")
+	if !h.isWatchedOrGlobal(repoName) {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: Repo %s is not being watched", repoName),
+		})
+		return
+	}
+
+	windows, err := h.clients.PostgresClient.GetDeploymentWindows(c.Request.Context(), repoName)
+	if err != nil {
+		log.LogAppErr("Couldn't fetch deployment windows", err)
+		c.JSON(500, gin.H{
+			"message": fmt.Sprintf("Unable to fetch deployment windows, err: %s", err),
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"windows": windows,
+	})
+}
+
+func (h *Handler) CreateDeploymentWindowHandler(c *gin.Context) {
+	var body deploymentWindowBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: %s", err),
+		})
+		return
+	}
+	if !h.isWatchedOrGlobal(body.RepositoryName) {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: Repo %s is not being watched", body.RepositoryName),
+		})
+		return
+	}
+	window := client.DeploymentWindowRow{
+		RepositoryName: body.RepositoryName,
+		Schedule:       body.Schedule,
+		Timezone:       body.Timezone,
+		CreatedBy:      requester(c),
+		CreatedAt:      time.Now(),
+	}
+	if window.Timezone == "" {
+		window.Timezone = "UTC"
+	}
+	if err := client.ValidateDeploymentWindow(window); err != nil {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: %s", err),
+		})
+		return
+	}
+
+	id, err := h.clients.PostgresClient.InsertDeploymentWindow(c.Request.Context(), window)
+	if err != nil {
+		log.LogAppErr("Couldn't create deployment window", err)
+		c.JSON(500, gin.H{
+			"message": fmt.Sprintf("Unable to create deployment window, err: %s", err),
+		})
+		return
+	}
+	log.LogAppInfo(fmt.Sprintf("Added deployment window %d `%s` (%s) for repo %q", id, window.Schedule, window.Timezone, window.RepositoryName))
+	c.JSON(200, gin.H{
+		"id": id,
+	})
+}
+
+func (h *Handler) DeleteDeploymentWindowHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{
+			"message": "Error: id must be an integer",
+		})
+		return
+	}
+	deleted, err := h.clients.PostgresClient.DeleteDeploymentWindow(c.Request.Context(), id)
+	if err != nil {
+		log.LogAppErr(fmt.Sprintf("Couldn't delete deployment window %d", id), err)
+		c.JSON(500, gin.H{
+			"message": fmt.Sprintf("Unable to delete deployment window %d, err: %s", id, err),
+		})
+		return
+	} else if !deleted {
+		c.JSON(404, gin.H{
+			"message": fmt.Sprintf("Error: Deployment window %d not found", id),
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": fmt.Sprintf("Deleted deployment window %d", id),
+	})
+}
+
+// Lists the freezes that haven't ended yet
+func (h *Handler) GetDeploymentFreezesHandler(c *gin.Context) {
+	repoName := c.Query("repo_name")
+	if !h.isWatchedOrGlobal(repoName) {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: Repo %s is not being watched", repoName),
+		})
+		return
+	}
+
+	freezes, err := h.clients.PostgresClient.GetDeploymentFreezes(c.Request.Context(), repoName, time.Now())
+	if err != nil {
+		log.LogAppErr("Couldn't fetch deployment freezes", err)
+		c.JSON(500, gin.H{
+			"message": fmt.Sprintf("Unable to fetch deployment freezes, err: %s", err),
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"freezes": freezes,
+	})
+}
+
+func (h *Handler) CreateDeploymentFreezeHandler(c *gin.Context) {
+	var body deploymentFreezeBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: %s", err),
+		})
+		return
+	}
+	if !h.isWatchedOrGlobal(body.RepositoryName) {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: Repo %s is not being watched", body.RepositoryName),
+		})
+		return
+	}
+	if !body.EndsAt.After(body.StartsAt) {
+		c.JSON(400, gin.H{
+			"message": "Error: ends_at must be after starts_at",
+		})
+		return
+	}
+
+	freeze := client.DeploymentFreezeRow{
+		RepositoryName: body.RepositoryName,
+		StartsAt:       body.StartsAt,
+		EndsAt:         body.EndsAt,
+		Reason:         body.Reason,
+		CreatedBy:      requester(c),
+		CreatedAt:      time.Now(),
+	}
+	id, err := h.clients.PostgresClient.InsertDeploymentFreeze(c.Request.Context(), freeze)
+	if err != nil {
+		log.LogAppErr("Couldn't create deployment freeze", err)
+		c.JSON(500, gin.H{
+			"message": fmt.Sprintf("Unable to create deployment freeze, err: %s", err),
+		})
+		return
+	}
+	log.LogAppInfo(fmt.Sprintf("Added deployment freeze %d from %s to %s for repo %q", id, freeze.StartsAt, freeze.EndsAt, freeze.RepositoryName))
+	c.JSON(200, gin.H{
+		"id": id,
+	})
+}
+
+func (h *Handler) DeleteDeploymentFreezeHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{
+			"message": "Error: id must be an integer",
+		})
+		return
+	}
+	deleted, err := h.clients.PostgresClient.DeleteDeploymentFreeze(c.Request.Context(), id)
+	if err != nil {
+		log.LogAppErr(fmt.Sprintf("Couldn't delete deployment freeze %d", id), err)
+		c.JSON(500, gin.H{
+			"message": fmt.Sprintf("Unable to delete deployment freeze %d, err: %s", id, err),
+		})
+		return
+	} else if !deleted {
+		c.JSON(404, gin.H{
+			"message": fmt.Sprintf("Error: Deployment freeze %d not found", id),
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": fmt.Sprintf("Deleted deployment freeze %d", id),
+	})
+}
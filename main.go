@@ -1,14 +1,23 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	// deployment windows are timezone aware, and the release image has no tzdata
+	_ "time/tzdata"
 
 	"github.com/dsaidgovsg/registrywatcher/client"
 	"github.com/dsaidgovsg/registrywatcher/config"
 	"github.com/dsaidgovsg/registrywatcher/log"
+	"github.com/dsaidgovsg/registrywatcher/notifier"
 	"github.com/dsaidgovsg/registrywatcher/utils"
 	"github.com/dsaidgovsg/registrywatcher/worker"
 	"github.com/gin-contrib/cors"
@@ -16,29 +25,110 @@ import (
 	"github.com/spf13/viper"
 )
 
+const defaultShutdownTimeout = 30 * time.Second
+
 func main() {
 	conf := config.SetUpConfig("staging")
+	if err := notifier.ValidateConfig(conf); err != nil {
+		panic(err)
+	}
 
 	clients := client.SetUpClients(conf)
 
-	SetUpWorkers(conf, clients)
+	// cancelled on SIGINT or SIGTERM to stop the workers and the server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	workers, workersDone := SetUpWorkers(ctx, conf, clients)
 
 	log.SetUpLogger()
 
-	r := SetUpRouter(conf, clients)
+	r, handler := SetUpRouter(conf, clients, workers)
 
-	r.Run(conf.GetString("server_listening_address"))
+	srv := &http.Server{
+		Addr:    conf.GetString("server_listening_address"),
+		Handler: r,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.LogAppErr("HTTP server stopped", err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	shutdown(conf, srv, handler, clients, workersDone)
+}
+
+/*
+ * Drains the HTTP server, waits for the responses sent after their request
+ * returned, for the workers to finish their current check and for in-flight
+ * deployments to finish, for at most shutdown_timeout. Deployments still
+ * being monitored after that are recorded as interrupted.
+ */
+func shutdown(conf *viper.Viper, srv *http.Server, handler *Handler, clients *client.Clients, workersDone <-chan struct{}) {
+	shutdownTimeout := defaultShutdownTimeout
+	if timeout := conf.GetString("shutdown_timeout"); timeout != "" {
+		var err error
+		if shutdownTimeout, err = time.ParseDuration(timeout); err != nil {
+			log.LogAppErr(fmt.Sprintf("Invalid shutdown_timeout %s, using %s", timeout, defaultShutdownTimeout), err)
+			shutdownTimeout = defaultShutdownTimeout
+		}
+	}
+	log.LogAppInfo(fmt.Sprintf("Shutting down, waiting up to %s", shutdownTimeout))
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.LogAppErr("Couldn't drain HTTP server", err)
+	}
+	// before clients.Shutdown, since Slack actions can start deployments
+	handler.Shutdown(ctx)
+	select {
+	case <-workersDone:
+	case <-ctx.Done():
+		log.LogAppWarn("Workers didn't stop before the shutdown timeout", ctx.Err())
+	}
+	clients.Shutdown(ctx)
+	log.LogAppInfo("Shut down")
 }
 
-func SetUpWorkers(conf *viper.Viper, clients *client.Clients) {
+// Starts a worker for each watched repository, keyed by repository name, and with
+// leader election a listener for the pushes and deployments of other replicas.
+// The workers stop when ctx is done, and the returned channel is closed once they all have.
+func SetUpWorkers(ctx context.Context, conf *viper.Viper, clients *client.Clients) (map[string]*worker.WatcherWorker, <-chan struct{}) {
+	workers := map[string]*worker.WatcherWorker{}
+	var wg sync.WaitGroup
 	for _, repoName := range conf.GetStringSlice("watched_repositories") {
-		pollInterval, err := time.ParseDuration(conf.GetString("poll_interval"))
+		schedule, err := worker.GetPollSchedule(conf, repoName)
 		if err != nil {
 			panic(fmt.Errorf("starting worker for %s failed: %v", repoName, err))
 		}
-		ww := worker.InitializeWatcherWorker(conf, pollInterval, repoName, clients)
-		go ww.Run()
+		if _, err = utils.GetRepoApprovalTimeout(conf, repoName); err != nil {
+			panic(fmt.Errorf("starting worker for %s failed: %v", repoName, err))
+		}
+		ww := worker.InitializeWatcherWorker(conf, schedule, repoName, clients)
+		workers[repoName] = ww
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ww.Run(ctx)
+		}()
+	}
+	if conf.GetBool("leader_election") {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker.ListenForReplicas(ctx, clients, workers)
+		}()
 	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return workers, done
 }
 
 type Config struct {
@@ -48,11 +138,13 @@ type Config struct {
 	CORSAllowMethods     string `mapstructure:"cors_allow_methods"`
 }
 
-func SetUpRouter(conf *viper.Viper, clients *client.Clients) *gin.Engine {
+// Routes the API to a Handler, which is returned to be shut down with the server
+func SetUpRouter(conf *viper.Viper, clients *client.Clients, workers map[string]*worker.WatcherWorker) (*gin.Engine, *Handler) {
 	r := gin.Default()
-	handler := Handler{
+	handler := &Handler{
 		clients: clients,
 		conf:    conf,
+		workers: workers,
 	}
 
 	routerConf := Config{
@@ -66,12 +158,27 @@ func SetUpRouter(conf *viper.Viper, clients *client.Clients) *gin.Engine {
 
 	r.GET("/ping", HealthCheckHandler)
 	r.POST("/tags/:repo_name/reset", handler.ResetTagHandler)
+	r.POST("/tags/:repo_name/rollback", handler.RollbackTagHandler)
 	r.POST("/tags/:repo_name", handler.DeployTagHandler)
 	r.GET("/tags/:repo_name", handler.GetTagHandler)
 	r.GET("/repos", handler.RepoSummaryHandler)
+	r.GET("/repos/:repo_name/history", handler.DeploymentHistoryHandler)
 	r.GET("/debug/caches", handler.CacheSummaryHandler)
-
-	return r
+	r.POST("/notifications/registry", handler.RegistryNotificationHandler)
+	r.POST("/notifications/dockerhub", handler.DockerhubNotificationHandler)
+	r.GET("/windows", handler.GetDeploymentWindowsHandler)
+	r.POST("/windows", handler.CreateDeploymentWindowHandler)
+	r.DELETE("/windows/:id", handler.DeleteDeploymentWindowHandler)
+	r.GET("/freezes", handler.GetDeploymentFreezesHandler)
+	r.POST("/freezes", handler.CreateDeploymentFreezeHandler)
+	r.DELETE("/freezes/:id", handler.DeleteDeploymentFreezeHandler)
+	r.GET("/pending", handler.GetPendingDeploymentsHandler)
+	r.POST("/pending/:id/approve", handler.ApprovePendingDeploymentHandler)
+	r.POST("/pending/:id/reject", handler.RejectPendingDeploymentHandler)
+	r.POST("/slack/interactions", handler.SlackInteractionHandler)
+	r.POST("/slack/commands", handler.SlackCommandHandler)
+
+	return r, handler
 }
 
 func corsMiddleware(conf *Config) gin.HandlerFunc {
@@ -101,15 +208,54 @@ func HealthCheckHandler(c *gin.Context) {
 type Handler struct {
 	clients *client.Clients
 	conf    *viper.Viper
+	workers map[string]*worker.WatcherWorker
+	// responses sent after their request returned, like Slack actions
+	// and Docker Hub callbacks, which Shutdown waits for
+	background sync.WaitGroup
+}
+
+// Runs f after the request returns, Shutdown waits for it
+func (h *Handler) runInBackground(f func()) {
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		f()
+	}()
+}
+
+// Waits for the responses running in the background to finish, or for ctx to
+// be done. The HTTP server must be shut down first, so that none are started.
+func (h *Handler) Shutdown(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		h.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.LogAppWarn("Background responses didn't finish before the shutdown timeout", ctx.Err())
+	}
+}
+
+// Identifies who made an API request, for the deployment history.
+// Uses the X-Requested-By header if it is set, since there is no authentication.
+func requester(c *gin.Context) string {
+	if requestedBy := c.GetHeader("X-Requested-By"); requestedBy != "" {
+		return requestedBy
+	}
+	return c.ClientIP()
 }
 
 type deployBody struct {
-	PinnedTag  *string `json:"pinned_tag",omitempty`
-	AutoDeploy *bool   `json:"auto_deploy",omitempty`
+	PinnedTag         *string `json:"pinned_tag,omitempty"`
+	AutoDeploy        *bool   `json:"auto_deploy,omitempty"`
+	VersionConstraint *string `json:"version_constraint,omitempty"`
 }
 
 func (h *Handler) ResetTagHandler(c *gin.Context) {
 
+	ctx := c.Request.Context()
 	pinnedTag := ""
 
 	// check if repoName is valid
@@ -129,37 +275,112 @@ func (h *Handler) ResetTagHandler(c *gin.Context) {
 		return
 	}
 
-	// if originalTag == pinnedTag, just terminate early
-	originalTag, err := h.clients.PostgresClient.GetPinnedTag(repoName)
-	if originalTag == pinnedTag {
+	deploying, err := h.resetTag(ctx, repoName, requester(c))
+	if err != nil {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: %s", err),
+		})
+	} else if !deploying {
 		c.JSON(200, gin.H{
 			"message": fmt.Sprintf("pinned_tag is already %s", pinnedTag),
 		})
-		return
+	} else {
+		c.JSON(200, gin.H{
+			"message": fmt.Sprintf("Deploying to %s", pinnedTag),
+		})
+	}
+}
+
+// Unpins repoName and turns auto deployment back on, then deploys the latest
+// tag. Returns false if repoName was not pinned, in which case nothing changes.
+func (h *Handler) resetTag(ctx context.Context, repoName, requester string) (bool, error) {
+	pinnedTag := ""
+
+	// if originalTag == pinnedTag, just terminate early
+	originalTag, err := h.clients.PostgresClient.GetPinnedTag(ctx, repoName)
+	originalConstraint, _ := h.clients.PostgresClient.GetVersionConstraint(ctx, repoName)
+	if originalTag == pinnedTag && originalConstraint == "" {
+		return false, nil
 	}
 
 	// update auto deployment
-	_ = h.clients.PostgresClient.UpdateAutoDeployFlag(repoName, true)
+	_ = h.clients.PostgresClient.UpdateAutoDeployFlag(ctx, repoName, true)
 
 	// update tag
-	err = h.clients.PostgresClient.UpdatePinnedTag(repoName, pinnedTag)
+	err = h.clients.PostgresClient.UpdatePinnedTag(ctx, repoName, pinnedTag)
 
 	if err != nil {
-		_ = h.clients.PostgresClient.UpdatePinnedTag(repoName, originalTag)
+		_ = h.clients.PostgresClient.UpdatePinnedTag(ctx, repoName, originalTag)
+		return false, fmt.Errorf("Failed to update pinned tag, %s", err)
+	}
+	log.LogAppInfo(fmt.Sprintf("Updated pinned_tag for repo %s from %s to %s succesfully, deployment of pinned_tag will happen shortly", repoName, originalTag, pinnedTag))
+	h.clients.DeployPinnedTag(ctx, h.conf, repoName, client.DeployTriggerManual, requester)
+	return true, nil
+}
+
+type rollbackBody struct {
+	HistoryID *int64 `json:"history_id"`
+}
+
+func (h *Handler) RollbackTagHandler(c *gin.Context) {
+
+	ctx := c.Request.Context()
+
+	// check if repoName is valid
+	repoName := c.Param("repo_name")
+	validName := false
+	for _, repo := range h.conf.GetStringSlice("watched_repositories") {
+		if repoName == repo {
+			validName = true
+			break
+		}
+	}
+	if !validName {
+		_, registryDomain, _, _ := utils.ExtractRegistryInfo(h.conf, repoName)
 		c.JSON(400, gin.H{
-			"message": fmt.Sprintf("Error: Failed to update pinned tag, %s", err),
+			"message": fmt.Sprintf("Error: The specified repo name %s is not inside the docker repository registry %s", repoName, registryDomain),
 		})
-	} else {
-		log.LogAppInfo(fmt.Sprintf("Updated pinned_tag for repo %s from %s to %s succesfully, deployment of pinned_tag will happen shortly", repoName, originalTag, pinnedTag))
-		h.clients.DeployPinnedTag(h.conf, repoName)
-		c.JSON(200, gin.H{
-			"message": fmt.Sprintf("Deploying to %s", pinnedTag),
+		return
+	}
+
+	// the body is optional, without it the repo is rolled back to the last successful deployment
+	var rollbackBody rollbackBody
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&rollbackBody); err != nil {
+			c.JSON(400, gin.H{
+				"message": fmt.Sprintf("Error: %s", err),
+			})
+			return
+		}
+	}
+	var historyID int64
+	if rollbackBody.HistoryID != nil {
+		historyID = *rollbackBody.HistoryID
+	}
+
+	tag, err := h.clients.GetRollbackTag(ctx, repoName, historyID)
+	if err != nil {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: Couldn't find a tag to roll back to, %s", err),
 		})
+		return
+	}
+
+	if err = h.clients.RollbackToTag(ctx, h.conf, repoName, tag, requester(c), ""); err != nil {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: Failed to roll back to %s, %s", tag, err),
+		})
+		return
 	}
+	c.JSON(200, gin.H{
+		"message": fmt.Sprintf("Rolling back to %s", tag),
+	})
 }
 
 func (h *Handler) DeployTagHandler(c *gin.Context) {
 
+	ctx := c.Request.Context()
+
 	// check if repoName is valid
 	repoName := c.Param("repo_name")
 	validName := false
@@ -185,9 +406,14 @@ func (h *Handler) DeployTagHandler(c *gin.Context) {
 			"message": fmt.Sprintf("Error: %s", err),
 		})
 		return
-	} else if deployBody.PinnedTag == nil && deployBody.AutoDeploy == nil {
+	} else if deployBody.PinnedTag == nil && deployBody.AutoDeploy == nil && deployBody.VersionConstraint == nil {
+		c.JSON(400, gin.H{
+			"message": "Either pinned_tag, version_constraint or auto_deploy must be specified.",
+		})
+		return
+	} else if deployBody.PinnedTag != nil && deployBody.VersionConstraint != nil {
 		c.JSON(400, gin.H{
-			"message": "Either pinned_tag or auto_deploy must be specified.",
+			"message": "Only one of pinned_tag and version_constraint can be specified.",
 		})
 		return
 	}
@@ -196,20 +422,12 @@ func (h *Handler) DeployTagHandler(c *gin.Context) {
 	// set autoDeploy if its present
 	if deployBody.AutoDeploy != nil {
 		newAutoDeployFlag = *deployBody.AutoDeploy
-		currentAutoDeployFlag, _ := h.clients.PostgresClient.GetAutoDeployFlag(repoName)
-		if newAutoDeployFlag != currentAutoDeployFlag {
-			_ = h.clients.PostgresClient.UpdateAutoDeployFlag(repoName, newAutoDeployFlag)
-			var msg string
-			if newAutoDeployFlag {
-				msg = fmt.Sprintf("Turned on auto deployment for repo `%s`", repoName)
-			} else {
-				msg = fmt.Sprintf("Turned off auto deployment for repo `%s`", repoName)
-			}
-			utils.PostSlackUpdate(h.conf, msg)
-			log.LogAppInfo(msg)
-		} else {
-			log.LogAppInfo(fmt.Sprintf("Auto deployment is already set to %s", strconv.FormatBool(newAutoDeployFlag)))
-		}
+		h.setAutoDeploy(ctx, repoName, newAutoDeployFlag)
+	}
+
+	if deployBody.VersionConstraint != nil {
+		h.deployVersionConstraint(c, repoName, *deployBody.VersionConstraint)
+		return
 	}
 
 	// exit if only autoDeploy in body
@@ -220,45 +438,118 @@ func (h *Handler) DeployTagHandler(c *gin.Context) {
 		return
 	}
 
-	// check if tag is valid
 	pinnedTag := *deployBody.PinnedTag
-	tags, err := h.clients.DockerRegistryClient.GetAllTags(repoName)
-	if err != nil || !utils.IsTagDeployable(pinnedTag, tags) {
+	if err = h.deployTag(ctx, repoName, pinnedTag, requester(c)); err != nil {
 		c.JSON(400, gin.H{
-			"message": fmt.Sprintf("Error: The specified pinned_tag %s is not inside the docker repository registry %s", pinnedTag, repoName),
+			"message": fmt.Sprintf("Error: %s", err),
+		})
+	} else {
+		c.JSON(200, gin.H{
+			"message": fmt.Sprintf("Deploying to %s", pinnedTag),
 		})
+	}
+}
+
+// Turns auto deployment of repoName on or off, announcing it if it changed
+func (h *Handler) setAutoDeploy(ctx context.Context, repoName string, autoDeploy bool) {
+	currentAutoDeployFlag, _ := h.clients.PostgresClient.GetAutoDeployFlag(ctx, repoName)
+	if autoDeploy == currentAutoDeployFlag {
+		log.LogAppInfo(fmt.Sprintf("Auto deployment is already set to %s", strconv.FormatBool(autoDeploy)))
 		return
 	}
+	_ = h.clients.PostgresClient.UpdateAutoDeployFlag(ctx, repoName, autoDeploy)
+	event := notifier.EventResumed
+	if !autoDeploy {
+		event = notifier.EventPaused
+	}
+	notifier.PostEvent(h.conf, event, notifier.EventData{Repo: repoName})
+	log.LogAppInfo(fmt.Sprintf("Auto deployment %s for repo %s", event, repoName))
+}
+
+// Pins repoName to pinnedTag, which must be in the registry, and deploys it
+func (h *Handler) deployTag(ctx context.Context, repoName, pinnedTag, requester string) error {
+	// check if tag is valid
+	tags, err := h.clients.DockerRegistryClient.GetAllTags(ctx, repoName)
+	if err != nil || !utils.IsTagDeployable(pinnedTag, tags) {
+		return fmt.Errorf("The specified pinned_tag %s is not inside the docker repository registry %s", pinnedTag, repoName)
+	}
 
 	// can terminate early if originalTag == pinnedTag
-	originalTag, err := h.clients.PostgresClient.GetPinnedTag(repoName)
+	originalTag, err := h.clients.PostgresClient.GetPinnedTag(ctx, repoName)
 	if originalTag == pinnedTag {
-		h.clients.DeployPinnedTag(h.conf, repoName)
-		c.JSON(200, gin.H{
-			"message": fmt.Sprintf("Deploying to %s", pinnedTag),
-		})
-		return
+		h.clients.DeployPinnedTag(ctx, h.conf, repoName, client.DeployTriggerManual, requester)
+		return nil
 	}
 
 	// update tag
-	err = h.clients.PostgresClient.UpdatePinnedTag(repoName, pinnedTag)
+	err = h.clients.PostgresClient.UpdatePinnedTag(ctx, repoName, pinnedTag)
 
 	if err != nil {
-		_ = h.clients.PostgresClient.UpdatePinnedTag(repoName, originalTag)
+		_ = h.clients.PostgresClient.UpdatePinnedTag(ctx, repoName, originalTag)
+		return fmt.Errorf("Failed to update pinned tag, %s", err)
+	}
+	log.LogAppInfo(fmt.Sprintf("Updated pinned_tag for repo %s from %s to %s succesfully, deployment of pinned_tag will happen shortly", repoName, originalTag, pinnedTag))
+	h.clients.DeployPinnedTag(ctx, h.conf, repoName, client.DeployTriggerManual, requester)
+	return nil
+}
+
+// Pins repoName to versionConstraint and deploys the latest tag inside it
+func (h *Handler) deployVersionConstraint(c *gin.Context, repoName, versionConstraint string) {
+	ctx := c.Request.Context()
+	tags, err := h.clients.DockerRegistryClient.GetAllTags(ctx, repoName)
+	if err != nil {
 		c.JSON(400, gin.H{
-			"message": fmt.Sprintf("Error: Failed to update pinned tag, %s", err),
+			"message": fmt.Sprintf("Error: Failed to fetch tags of %s, %s", repoName, err),
 		})
-	} else {
-		log.LogAppInfo(fmt.Sprintf("Updated pinned_tag for repo %s from %s to %s succesfully, deployment of pinned_tag will happen shortly", repoName, originalTag, pinnedTag))
-		h.clients.DeployPinnedTag(h.conf, repoName)
-		c.JSON(200, gin.H{
-			"message": fmt.Sprintf("Deploying to %s", pinnedTag),
+		return
+	}
+
+	// check that the constraint is valid and some tag is inside it
+	policy := h.clients.GetTagPolicy(repoName)
+	if versionConstraint != "" {
+		if policy.Type != utils.SemverTagPolicy {
+			c.JSON(400, gin.H{
+				"message": fmt.Sprintf("Error: version_constraint requires the semver tag_policy, repo %s uses %s", repoName, policy.Type),
+			})
+			return
+		}
+		constraint, err := utils.ParseVersionConstraint(versionConstraint)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"message": fmt.Sprintf("Error: %s", err),
+			})
+			return
+		}
+		policy = policy.WithConstraint(constraint)
+	}
+	tagToDeploy, err := utils.GetLatestReleaseTag(tags, policy)
+	if err != nil {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: No tag of %s satisfies version_constraint %s", repoName, versionConstraint),
 		})
+		return
 	}
+
+	originalTag, _ := h.clients.PostgresClient.GetPinnedTag(ctx, repoName)
+	originalConstraint, _ := h.clients.PostgresClient.GetVersionConstraint(ctx, repoName)
+	if originalTag != "" || originalConstraint != versionConstraint {
+		if err = h.clients.PostgresClient.UpdateVersionConstraint(ctx, repoName, versionConstraint); err != nil {
+			c.JSON(400, gin.H{
+				"message": fmt.Sprintf("Error: Failed to update version constraint, %s", err),
+			})
+			return
+		}
+		log.LogAppInfo(fmt.Sprintf("Updated version_constraint for repo %s from %s to %s succesfully, deployment of %s will happen shortly", repoName, originalConstraint, versionConstraint, tagToDeploy))
+	}
+	h.clients.DeployPinnedTag(ctx, h.conf, repoName, client.DeployTriggerManual, requester(c))
+	c.JSON(200, gin.H{
+		"message": fmt.Sprintf("Deploying to %s", tagToDeploy),
+	})
 }
 
 func (h *Handler) GetTagHandler(c *gin.Context) {
 
+	ctx := c.Request.Context()
 	repoName := c.Param("repo_name")
 
 	invalidRepoName := true
@@ -275,11 +566,11 @@ func (h *Handler) GetTagHandler(c *gin.Context) {
 		return
 	}
 
-	tag, err := h.clients.PostgresClient.GetPinnedTag(repoName)
+	tag, err := h.clients.PostgresClient.GetPinnedTag(ctx, repoName)
 	var rtn string
 	if tag == "" {
-		tags, err := h.clients.DockerRegistryClient.GetAllTags(repoName)
-		rtn, err = utils.GetLatestReleaseTag(tags)
+		tags, err := h.clients.DockerRegistryClient.GetAllTags(ctx, repoName)
+		rtn, err = h.clients.GetLatestReleaseTag(ctx, repoName, tags)
 		if err != nil {
 			c.JSON(400, gin.H{
 				"message": fmt.Sprintf("No valid tags %s for repo %s, err: %s", tags, repoName, err),
@@ -302,9 +593,10 @@ func (h *Handler) GetTagHandler(c *gin.Context) {
 
 func (h *Handler) RepoSummaryHandler(c *gin.Context) {
 
+	ctx := c.Request.Context()
 	rtn := map[string]map[string]interface{}{}
 
-	tagMap, err := h.clients.PostgresClient.GetAllTags()
+	tagMap, err := h.clients.PostgresClient.GetAllTags(ctx)
 	for _, repoName := range h.conf.GetStringSlice("watched_repositories") {
 		var tag string
 		if _, ok := tagMap[repoName]; ok {
@@ -313,32 +605,105 @@ func (h *Handler) RepoSummaryHandler(c *gin.Context) {
 			log.LogAppErr(fmt.Sprintf("Couldn't fetch tag from database for endpoint summary handler for repo %s", repoName), err)
 			continue
 		}
-		tags, err := h.clients.GetCachedTags(repoName)
-		if err != nil {
-			log.LogAppErr(fmt.Sprintf("Couldn't fetch tags from cache for endpoint summary handler for repo %s", repoName), err)
-			continue
-		}
-		tagValue, err := h.clients.GetFormattedPinnedTag(repoName)
+		summary, err := h.repoSummary(ctx, repoName, tag)
 		if err != nil {
-			log.LogAppErr(fmt.Sprintf("Couldn't fetch pinned tag for endpoint summary handler for repo %s", repoName), err)
+			log.LogAppErr(fmt.Sprintf("Couldn't fetch summary for endpoint summary handler for repo %s", repoName), err)
 			continue
 		}
-		autoDeployFlag, err := h.clients.PostgresClient.GetAutoDeployFlag(repoName)
-		if err != nil {
-			log.LogAppErr(fmt.Sprintf("Couldn't fetch auto deploy flag for endpoint summary handler for repo %s", repoName), err)
-			continue
-		}
-		rtn[repoName] = map[string]interface{}{
-			"pinned_tag":       tag,
-			"pinned_tag_value": tagValue,
-			"tags":             tags,
-			"auto_deploy":      autoDeployFlag,
-		}
+		rtn[repoName] = summary
 	}
 
 	c.JSON(200, rtn)
 }
 
+// Summarises how repoName, pinned to tag, is deployed
+func (h *Handler) repoSummary(ctx context.Context, repoName, tag string) (map[string]interface{}, error) {
+	tags, err := h.clients.GetCachedTags(repoName)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch tags from cache: %v", err)
+	}
+	tagValue, err := h.clients.GetFormattedPinnedTag(ctx, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch pinned tag: %v", err)
+	}
+	autoDeployFlag, err := h.clients.PostgresClient.GetAutoDeployFlag(ctx, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch auto deploy flag: %v", err)
+	}
+	versionConstraint, err := h.clients.PostgresClient.GetVersionConstraint(ctx, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch version constraint: %v", err)
+	}
+	deferredTrigger, err := h.clients.PostgresClient.GetDeferredTrigger(ctx, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch deferred deployment: %v", err)
+	}
+	return map[string]interface{}{
+		"pinned_tag":         tag,
+		"pinned_tag_value":   tagValue,
+		"version_constraint": versionConstraint,
+		"tags":               tags,
+		"auto_deploy":        autoDeployFlag,
+		"deferred_trigger":   deferredTrigger,
+		"pinned_image":       h.clients.GetCachedImage(repoName),
+	}, nil
+}
+
+const (
+	defaultHistoryPerPage = 20
+	maxHistoryPerPage     = 100
+)
+
+func (h *Handler) DeploymentHistoryHandler(c *gin.Context) {
+
+	repoName := c.Param("repo_name")
+
+	invalidRepoName := true
+	for _, repo := range h.conf.GetStringSlice("watched_repositories") {
+		if repo == repoName {
+			invalidRepoName = false
+			break
+		}
+	}
+	if invalidRepoName {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: Repo %s is not being watched", repoName),
+		})
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(400, gin.H{
+			"message": "Error: page must be a positive integer",
+		})
+		return
+	}
+	perPage, err := strconv.Atoi(c.DefaultQuery("per_page", strconv.Itoa(defaultHistoryPerPage)))
+	if err != nil || perPage < 1 || perPage > maxHistoryPerPage {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: per_page must be an integer between 1 and %d", maxHistoryPerPage),
+		})
+		return
+	}
+
+	history, total, err := h.clients.PostgresClient.GetDeploymentHistory(c.Request.Context(), repoName, perPage, (page-1)*perPage)
+	if err != nil {
+		log.LogAppErr(fmt.Sprintf("Couldn't fetch deployment history for repo %s", repoName), err)
+		c.JSON(500, gin.H{
+			"message": fmt.Sprintf("Unable to fetch repo %s deployment history, err: %s", repoName, err),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"history":  history,
+		"page":     page,
+		"per_page": perPage,
+		"total":    total,
+	})
+}
+
 func (h *Handler) CacheSummaryHandler(c *gin.Context) {
 
 	rtn := map[string]map[string]interface{}{}
@@ -350,6 +715,10 @@ func (h *Handler) CacheSummaryHandler(c *gin.Context) {
 			"cached_tags":   tags,
 			"cached_digest": cachedTagDigest,
 		}
+		if ww, ok := h.workers[repoName]; ok {
+			rtn[repoName]["leader"] = ww.IsLeader()
+			rtn[repoName]["backoff"] = ww.BackoffState()
+		}
 	}
 
 	c.JSON(200, rtn)
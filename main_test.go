@@ -4,11 +4,13 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/dsaidgovsg/registrywatcher/client"
 	"github.com/dsaidgovsg/registrywatcher/utils"
@@ -21,7 +23,7 @@ type RepoSummaryResult struct {
 
 func TestRepoSummaryHandler(t *testing.T) {
 	te := client.SetUpClientTest(t)
-	router := SetUpRouter(te.Conf, te.Clients)
+	router, _ := SetUpRouter(te.Conf, te.Clients, nil)
 	defer te.TearDown()
 	var rtn RepoSummaryResult
 
@@ -30,7 +32,7 @@ func TestRepoSummaryHandler(t *testing.T) {
 	for _, tag := range tags {
 		te.PushNewTag(tag, "latest")
 	}
-	te.Clients.PopulateCaches(te.TestRepoName)
+	te.Clients.PopulateCaches(context.Background(), te.TestRepoName)
 
 	request, _ := http.NewRequest("GET", "/repos", nil)
 	response := httptest.NewRecorder()
@@ -62,7 +64,7 @@ type GetTagResult struct {
 // also tests RepinnedTagHandler since setUp and tearDown is expensive
 func TestGetTagHandler(t *testing.T) {
 	te := client.SetUpClientTest(t)
-	router := SetUpRouter(te.Conf, te.Clients)
+	router, _ := SetUpRouter(te.Conf, te.Clients, nil)
 	defer te.TearDown()
 	var rtn GetTagResult
 
@@ -104,10 +106,10 @@ func TestGetTagHandler(t *testing.T) {
 	router.ServeHTTP(response, request)
 
 	// tag is reset to latest, which is now v2.0.0
-	tag, _ = te.Clients.PostgresClient.GetPinnedTag(te.TestRepoName)
+	tag, _ = te.Clients.PostgresClient.GetPinnedTag(context.Background(), te.TestRepoName)
 	assert.Equal(t, tag, "", "OK tags is latest")
-	tags, _ = te.Clients.DockerRegistryClient.GetAllTags(te.TestRepoName)
-	tagValue, _ := utils.GetLatestReleaseTag(tags)
+	tags, _ = te.Clients.DockerRegistryClient.GetAllTags(context.Background(), te.TestRepoName)
+	tagValue, _ := utils.GetLatestReleaseTag(tags, te.Clients.GetTagPolicy(te.TestRepoName))
 	assert.Equal(t, newTag, tagValue, "OK latest tag is v2.0.0")
 
 	// test querying on a repo that's not being watched
@@ -120,7 +122,7 @@ func TestGetTagHandler(t *testing.T) {
 
 func TestDeployTagHandler(t *testing.T) {
 	te := client.SetUpClientTest(t)
-	router := SetUpRouter(te.Conf, te.Clients)
+	router, _ := SetUpRouter(te.Conf, te.Clients, nil)
 	defer te.TearDown()
 
 	// populate with new tags
@@ -185,3 +187,310 @@ func TestDeployTagHandler(t *testing.T) {
 	router.ServeHTTP(response, request)
 	assert.Equal(t, 200, response.Code, "OK response is expected")
 }
+
+func TestDeployVersionConstraintHandler(t *testing.T) {
+	te := client.SetUpClientTest(t)
+	router, _ := SetUpRouter(te.Conf, te.Clients, nil)
+	defer te.TearDown()
+	ctx := context.Background()
+
+	for _, tag := range []string{"v1.0.0", "v1.1.0", "v2.0.0"} {
+		te.PushNewTag(tag, "latest")
+	}
+	te.Clients.PopulateCaches(ctx, te.TestRepoName)
+
+	deploy := func(body string) int {
+		request, _ := http.NewRequest("POST", fmt.Sprintf("/tags/%s", te.TestRepoName), bytes.NewBufferString(body))
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response.Code
+	}
+	assertState := func(pinnedTag, versionConstraint, tagValue string) {
+		storedTag, err := te.Clients.PostgresClient.GetPinnedTag(ctx, te.TestRepoName)
+		assert.Nil(t, err)
+		assert.Equal(t, pinnedTag, storedTag)
+		storedConstraint, err := te.Clients.PostgresClient.GetVersionConstraint(ctx, te.TestRepoName)
+		assert.Nil(t, err)
+		assert.Equal(t, versionConstraint, storedConstraint)
+		formattedTag, err := te.Clients.GetFormattedPinnedTag(ctx, te.TestRepoName)
+		assert.Nil(t, err)
+		assert.Equal(t, tagValue, formattedTag)
+	}
+
+	assert.Equal(t, 200, deploy(`{"version_constraint":"^1"}`))
+	assertState("", "^1", "v1.1.0")
+
+	// ranges crossing a major version are rejected, and leave the state as is
+	assert.Equal(t, 400, deploy(`{"version_constraint":">=1.0"}`))
+	assert.Equal(t, 400, deploy(`{"version_constraint":"*"}`))
+	// no tag is inside the range
+	assert.Equal(t, 400, deploy(`{"version_constraint":"^3"}`))
+	assert.Equal(t, 400, deploy(`{"version_constraint":"~1.0", "pinned_tag":"v1.0.0"}`))
+	assertState("", "^1", "v1.1.0")
+
+	assert.Equal(t, 200, deploy(`{"version_constraint":"~1.0"}`))
+	assertState("", "~1.0", "v1.0.0")
+
+	// pinning a tag clears the constraint
+	assert.Equal(t, 200, deploy(`{"pinned_tag":"v1.1.0"}`))
+	assertState("v1.1.0", "", "v1.1.0")
+
+	// an empty constraint tracks the latest tag again
+	assert.Equal(t, 200, deploy(`{"version_constraint":""}`))
+	assertState("", "", "v2.0.0")
+}
+
+type DeploymentHistoryResult struct {
+	History []client.DeploymentHistoryRow `json:"history"`
+	Page    int                           `json:"page"`
+	PerPage int                           `json:"per_page"`
+	Total   int                           `json:"total"`
+}
+
+// inserts a finished deployment of tag into the history, started minutesAgo
+func insertDeploymentHistory(t *testing.T, pc *client.PostgresClient, repoName, tag string, outcome client.DeploymentStatus, minutesAgo int) int64 {
+	id, err := pc.InsertDeploymentHistory(context.Background(), client.DeploymentHistoryRow{
+		RepositoryName: repoName,
+		ToTag:          tag,
+		Trigger:        string(client.DeployTriggerManual),
+		StartedAt:      time.Now().Add(-time.Duration(minutesAgo) * time.Minute),
+		Outcome:        string(outcome),
+	})
+	assert.Nil(t, err)
+	return id
+}
+
+func TestDeploymentHistoryHandler(t *testing.T) {
+	te := client.SetUpClientTest(t)
+	router, _ := SetUpRouter(te.Conf, te.Clients, nil)
+	defer te.TearDown()
+	var rtn DeploymentHistoryResult
+
+	// 5 deployments, the latest of which is v0.0.5
+	for i := 1; i <= 5; i++ {
+		insertDeploymentHistory(t, te.Clients.PostgresClient, te.TestRepoName, fmt.Sprintf("v0.0.%d", i), client.DeploymentSuccessful, 10-i)
+	}
+	insertDeploymentHistory(t, te.Clients.PostgresClient, "otherrepo", "v9.9.9", client.DeploymentSuccessful, 0)
+
+	request, _ := http.NewRequest("GET", fmt.Sprintf("/repos/%s/history?per_page=2", te.TestRepoName), nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	_ = json.NewDecoder(response.Body).Decode(&rtn)
+	assert.Equal(t, 200, response.Code, "OK response is expected")
+	assert.Equal(t, 5, rtn.Total, "OK only the repo's deployments are counted")
+	assert.Equal(t, 1, rtn.Page)
+	assert.Equal(t, 2, rtn.PerPage)
+	assert.Equal(t, 2, len(rtn.History))
+	// newest first
+	assert.Equal(t, "v0.0.5", rtn.History[0].ToTag)
+	assert.Equal(t, "v0.0.4", rtn.History[1].ToTag)
+
+	rtn = DeploymentHistoryResult{}
+	request, _ = http.NewRequest("GET", fmt.Sprintf("/repos/%s/history?page=3&per_page=2", te.TestRepoName), nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	_ = json.NewDecoder(response.Body).Decode(&rtn)
+	assert.Equal(t, 200, response.Code, "OK response is expected")
+	assert.Equal(t, 1, len(rtn.History), "OK last page is partial")
+	assert.Equal(t, "v0.0.1", rtn.History[0].ToTag)
+
+	// pages past the end are empty
+	rtn = DeploymentHistoryResult{}
+	request, _ = http.NewRequest("GET", fmt.Sprintf("/repos/%s/history?page=4&per_page=2", te.TestRepoName), nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	_ = json.NewDecoder(response.Body).Decode(&rtn)
+	assert.Equal(t, 200, response.Code, "OK response is expected")
+	assert.Equal(t, 0, len(rtn.History))
+	assert.Equal(t, 5, rtn.Total)
+
+	// test with invalid paging params
+	for _, query := range []string{"page=0", "page=first", "per_page=0", "per_page=101"} {
+		request, _ = http.NewRequest("GET", fmt.Sprintf("/repos/%s/history?%s", te.TestRepoName, query), nil)
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, 400, response.Code, query)
+	}
+
+	// test with a repo that's not being watched
+	request, _ = http.NewRequest("GET", "/repos/nonexistent-repo/history", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	assert.Equal(t, 400, response.Code, "OK response is expected")
+}
+
+func TestRollbackTagHandler(t *testing.T) {
+	te := client.SetUpClientTest(t)
+	router, _ := SetUpRouter(te.Conf, te.Clients, nil)
+	defer te.TearDown()
+	ctx := context.Background()
+
+	// populate with new tags
+	tags := []string{"v1.0.0", "v1.1.0"}
+	for _, tag := range tags {
+		te.PushNewTag(tag, "latest")
+	}
+	insertDeploymentHistory(t, te.Clients.PostgresClient, te.TestRepoName, "v1.0.0", client.DeploymentSuccessful, 30)
+	latestID := insertDeploymentHistory(t, te.Clients.PostgresClient, te.TestRepoName, "v1.1.0", client.DeploymentSuccessful, 20)
+	insertDeploymentHistory(t, te.Clients.PostgresClient, te.TestRepoName, "v1.1.0", client.DeploymentFailed, 10)
+	otherRepoID := insertDeploymentHistory(t, te.Clients.PostgresClient, "otherrepo", "v1.0.0", client.DeploymentSuccessful, 10)
+
+	// without a body, rolls back to the last successful deployment of another tag
+	request, _ := http.NewRequest("POST", fmt.Sprintf("/tags/%s/rollback", te.TestRepoName), nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	assert.Equal(t, 200, response.Code, "OK response is expected")
+	tag, _ := te.Clients.PostgresClient.GetPinnedTag(ctx, te.TestRepoName)
+	assert.Equal(t, "v1.0.0", tag, "OK rolled back to last successful deployment")
+	autoDeploy, _ := te.Clients.PostgresClient.GetAutoDeployFlag(ctx, te.TestRepoName)
+	assert.False(t, autoDeploy, "OK auto deployment is turned off")
+
+	// rolls back to the tag of an explicit history entry
+	data := []byte(fmt.Sprintf(`{"history_id": %d}`, latestID))
+	request, _ = http.NewRequest("POST", fmt.Sprintf("/tags/%s/rollback", te.TestRepoName), bytes.NewBuffer(data))
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	assert.Equal(t, 200, response.Code, "OK response is expected")
+	tag, _ = te.Clients.PostgresClient.GetPinnedTag(ctx, te.TestRepoName)
+	assert.Equal(t, "v1.1.0", tag, "OK rolled back to the history entry")
+
+	// test with history entries that don't exist or are of another repo
+	for _, historyID := range []int64{otherRepoID, 99999} {
+		data = []byte(fmt.Sprintf(`{"history_id": %d}`, historyID))
+		request, _ = http.NewRequest("POST", fmt.Sprintf("/tags/%s/rollback", te.TestRepoName), bytes.NewBuffer(data))
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, 400, response.Code, historyID)
+	}
+	tag, _ = te.Clients.PostgresClient.GetPinnedTag(ctx, te.TestRepoName)
+	assert.Equal(t, "v1.1.0", tag, "OK pinned tag is unchanged")
+
+	// test with invalid params
+	data = []byte(`{"history_id": "latest"}`)
+	request, _ = http.NewRequest("POST", fmt.Sprintf("/tags/%s/rollback", te.TestRepoName), bytes.NewBuffer(data))
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	assert.Equal(t, 400, response.Code, "OK response is expected")
+
+	// test with a repo that's not being watched
+	request, _ = http.NewRequest("POST", "/tags/nonexistent-repo/rollback", nil)
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	assert.Equal(t, 400, response.Code, "OK response is expected")
+
+	// let the rollback deployments finish before the containers are removed
+	shutdownCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	te.Clients.Shutdown(shutdownCtx)
+}
+
+func TestSlackPauseAction(t *testing.T) {
+	te := client.SetUpClientTest(t)
+	defer te.TearDown()
+	handler := Handler{conf: te.Conf, clients: te.Clients}
+	ctx := context.Background()
+
+	target := utils.SlackActionTarget{Repo: te.TestRepoName}
+	outcome, err := handler.performSlackAction(ctx, utils.SlackActionPause, target, "alice")
+	assert.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf("alice turned off auto deployment for repo `%s`.", te.TestRepoName), outcome)
+	autoDeploy, err := te.Clients.PostgresClient.GetAutoDeployFlag(ctx, te.TestRepoName)
+	assert.Nil(t, err)
+	assert.False(t, autoDeploy)
+
+	// pausing again is not an error
+	_, err = handler.performSlackAction(ctx, utils.SlackActionPause, target, "alice")
+	assert.Nil(t, err)
+}
+
+func decidePendingDeployment(router http.Handler, action string, id interface{}) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest("POST", fmt.Sprintf("/pending/%v/%s", id, action), nil)
+	request.Header.Set("X-Requested-By", "alice")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	return response
+}
+
+func TestRejectPendingDeploymentHandler(t *testing.T) {
+	te := client.SetUpClientTest(t)
+	router, _ := SetUpRouter(te.Conf, te.Clients, nil)
+	defer te.TearDown()
+	ctx := context.Background()
+
+	te.PushNewTag("v1.0.0", "latest")
+	te.UpdatePinnedTag("v1.0.0")
+	pending, err := te.Clients.RequestApproval(ctx, te.Conf, te.TestRepoName, "v1.0.0", client.DeployTriggerAuto)
+	assert.Nil(t, err)
+
+	response := decidePendingDeployment(router, "reject", pending.ID)
+	assert.Equal(t, 200, response.Code, "OK response is expected")
+	pending, _ = te.Clients.PostgresClient.GetPendingDeployment(ctx, pending.ID)
+	assert.Equal(t, client.PendingDeploymentRejected, pending.Status)
+	assert.Equal(t, "alice", pending.DecidedBy)
+
+	// already decided
+	response = decidePendingDeployment(router, "reject", pending.ID)
+	assert.Equal(t, 400, response.Code, "OK response is expected")
+	response = decidePendingDeployment(router, "approve", pending.ID)
+	assert.Equal(t, 400, response.Code, "OK response is expected")
+
+	// test with ids that don't exist or aren't integers
+	response = decidePendingDeployment(router, "reject", 99999)
+	assert.Equal(t, 400, response.Code, "OK response is expected")
+	response = decidePendingDeployment(router, "reject", "latest")
+	assert.Equal(t, 400, response.Code, "OK response is expected")
+}
+
+func TestApprovePendingDeploymentHandler(t *testing.T) {
+	te := client.SetUpClientTest(t)
+	router, _ := SetUpRouter(te.Conf, te.Clients, nil)
+	defer te.TearDown()
+	ctx := context.Background()
+
+	te.PushNewTag("v1.0.0", "latest")
+	te.PushNewTag("v1.1.0", "latest")
+	te.UpdatePinnedTag("v1.0.0")
+
+	// the pinned tag changed while waiting
+	pending, err := te.Clients.RequestApproval(ctx, te.Conf, te.TestRepoName, "v1.1.0", client.DeployTriggerAuto)
+	assert.Nil(t, err)
+	response := decidePendingDeployment(router, "approve", pending.ID)
+	assert.Equal(t, 400, response.Code, "OK response is expected")
+
+	// the tag was pushed again while waiting
+	pending, err = te.Clients.RequestApproval(ctx, te.Conf, te.TestRepoName, "v1.0.0", client.DeployTriggerAuto)
+	assert.Nil(t, err)
+	te.PushNewTag("v1.0.0", "alpine")
+	response = decidePendingDeployment(router, "approve", pending.ID)
+	assert.Equal(t, 400, response.Code, "OK response is expected")
+	pending, _ = te.Clients.PostgresClient.GetPendingDeployment(ctx, pending.ID)
+	assert.Equal(t, client.PendingDeploymentPending, pending.Status, "OK still pending")
+
+	// expired while waiting
+	expiredID, err := te.Clients.PostgresClient.InsertPendingDeployment(ctx, client.PendingDeploymentRow{
+		RepositoryName: te.TestRepoName,
+		Tag:            "v1.0.0",
+		Trigger:        string(client.DeployTriggerAuto),
+		CreatedAt:      time.Now().Add(-2 * time.Hour),
+		ExpiresAt:      time.Now().Add(-time.Hour),
+	})
+	assert.Nil(t, err)
+	response = decidePendingDeployment(router, "approve", expiredID)
+	assert.Equal(t, 400, response.Code, "OK response is expected")
+
+	pending, err = te.Clients.RequestApproval(ctx, te.Conf, te.TestRepoName, "v1.0.0", client.DeployTriggerAuto)
+	assert.Nil(t, err)
+	response = decidePendingDeployment(router, "approve", pending.ID)
+	assert.Equal(t, 200, response.Code, "OK response is expected")
+	pending, _ = te.Clients.PostgresClient.GetPendingDeployment(ctx, pending.ID)
+	assert.Equal(t, client.PendingDeploymentApproved, pending.Status)
+	assert.Equal(t, "alice", pending.DecidedBy)
+
+	// let the deployment finish before the containers are removed
+	shutdownCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	te.Clients.Shutdown(shutdownCtx)
+	history, _, err := te.Clients.PostgresClient.GetDeploymentHistory(ctx, te.TestRepoName, 10, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(history), "OK approving deploys the tag")
+}
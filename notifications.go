@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"github.com/dsaidgovsg/registrywatcher/client"
+	"github.com/dsaidgovsg/registrywatcher/log"
+	"github.com/dsaidgovsg/registrywatcher/registry"
+	"github.com/dsaidgovsg/registrywatcher/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// Receives Docker Distribution registry notifications and checks the
+// pushed repositories for updates straight away, instead of at the next poll
+func (h *Handler) RegistryNotificationHandler(c *gin.Context) {
+	if token := h.conf.GetString("registry_notification_token"); token != "" {
+		authorization := c.GetHeader("Authorization")
+		expected := fmt.Sprintf("Bearer %s", token)
+		if subtle.ConstantTimeCompare([]byte(authorization), []byte(expected)) != 1 {
+			c.JSON(401, gin.H{
+				"message": "Error: invalid notification token",
+			})
+			return
+		}
+	}
+
+	var envelope registry.Envelope
+	if err := c.ShouldBindJSON(&envelope); err != nil {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: %s", err),
+		})
+		return
+	}
+
+	triggered := []string{}
+	for _, event := range envelope.Events {
+		if !event.IsManifestPush() {
+			continue
+		}
+		repoName, ok := h.findWatchedRepo(event.Target.Repository)
+		if !ok {
+			continue
+		}
+		log.LogAppInfo(fmt.Sprintf("Received push notification for %s:%s", event.Target.Repository, event.Target.Tag))
+		if h.triggerWorker(c.Request.Context(), repoName) {
+			triggered = append(triggered, repoName)
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"triggered": triggered,
+	})
+}
+
+// maps a repository path in the registry, i.e. prefix/name, to a watched repository
+func (h *Handler) findWatchedRepo(repository string) (string, bool) {
+	for _, repoName := range h.conf.GetStringSlice("watched_repositories") {
+		_, _, registryPrefix, _ := utils.ExtractRegistryInfo(h.conf, repoName)
+		if strings.Trim(repository, "/") == strings.Trim(fmt.Sprintf("%s/%s", registryPrefix, repoName), "/") {
+			return repoName, true
+		}
+	}
+	return "", false
+}
+
+// returns false if there is no worker running for repoName
+func (h *Handler) triggerWorker(ctx context.Context, repoName string) bool {
+	ww, ok := h.workers[repoName]
+	if !ok {
+		return false
+	}
+	ww.Trigger(ctx)
+	return true
+}
+
+// Receives Docker Hub repository webhooks and checks the pushed repository
+// for updates straight away. Docker Hub does not sign its webhooks, so the
+// request is validated by the optional token query parameter, and the
+// webhook is acknowledged through its callback_url.
+func (h *Handler) DockerhubNotificationHandler(c *gin.Context) {
+	if token := h.conf.GetString("dockerhub_webhook_token"); token != "" {
+		if subtle.ConstantTimeCompare([]byte(c.Query("token")), []byte(token)) != 1 {
+			c.JSON(401, gin.H{
+				"message": "Error: invalid webhook token",
+			})
+			return
+		}
+	}
+
+	var payload client.DockerhubWebhookPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: %s", err),
+		})
+		return
+	}
+
+	triggered := []string{}
+	repoName, ok := h.findDockerhubRepo(payload.Repository.Namespace, payload.Repository.Name)
+	if !ok {
+		// the webhook chain may belong to another service, so it is left for that one to validate
+		c.JSON(200, gin.H{
+			"triggered": triggered,
+		})
+		return
+	}
+	callback := client.DockerhubWebhookCallback{
+		State:   "success",
+		Context: "registrywatcher",
+	}
+	log.LogAppInfo(fmt.Sprintf("Received Dockerhub webhook for %s:%s", payload.Repository.RepoName, payload.PushData.Tag))
+	if h.triggerWorker(c.Request.Context(), repoName) {
+		triggered = append(triggered, repoName)
+		callback.Description = fmt.Sprintf("checking %s for updates", repoName)
+	} else {
+		callback.State = "error"
+		callback.Description = fmt.Sprintf("no worker is running for %s", repoName)
+	}
+
+	if payload.CallbackURL != "" {
+		// the callback outlives the request, so it isn't cancelled with it
+		h.runInBackground(func() {
+			err := client.PostDockerhubWebhookCallback(context.Background(), h.conf, payload.CallbackURL, callback)
+			if err != nil {
+				log.LogAppErr(fmt.Sprintf("Couldn't validate Dockerhub webhook for %s", payload.Repository.RepoName), err)
+			}
+		})
+	}
+
+	c.JSON(200, gin.H{
+		"triggered": triggered,
+	})
+}
+
+// maps a Docker Hub namespace and repository name to a watched repository,
+// the namespace must match the dockerhub_namespace used for the Dockerhub API
+func (h *Handler) findDockerhubRepo(namespace, name string) (string, bool) {
+	if namespace != h.conf.GetString("dockerhub_namespace") {
+		return "", false
+	}
+	for _, repoName := range h.conf.GetStringSlice("watched_repositories") {
+		if repoName == name {
+			return repoName, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,113 @@
+//go:build unit
+// +build unit
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dsaidgovsg/registrywatcher/registry"
+	"github.com/dsaidgovsg/registrywatcher/worker"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func setUpNotificationTest() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	conf := viper.New()
+	conf.Set("watched_repositories", []string{"api", "web"})
+	conf.Set("repo_map.api.registry_name", "local")
+	conf.Set("repo_map.web.registry_name", "local")
+	conf.Set("registry_map.local.registry_prefix", "shop")
+	conf.Set("registry_notification_token", "registry-token")
+	conf.Set("dockerhub_namespace", "shop")
+	conf.Set("dockerhub_webhook_token", "hub-token")
+	// web has no worker running
+	handler := Handler{conf: conf, workers: map[string]*worker.WatcherWorker{
+		"api": worker.InitializeWatcherWorker(conf, worker.PollSchedule{}, "api", nil),
+	}}
+	r := gin.New()
+	r.POST("/notifications/registry", handler.RegistryNotificationHandler)
+	r.POST("/notifications/dockerhub", handler.DockerhubNotificationHandler)
+	return r
+}
+
+func postNotification(r *gin.Engine, path, authorization string, body interface{}) (int, []string) {
+	buf, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(buf))
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var rtn struct {
+		Triggered []string `json:"triggered"`
+	}
+	json.NewDecoder(w.Body).Decode(&rtn)
+	return w.Code, rtn.Triggered
+}
+
+func manifestPush(repository string) registry.Event {
+	return registry.Event{
+		Action: "push",
+		Target: registry.EventTarget{MediaType: registry.MediaTypeDockerManifest, Repository: repository, Tag: "v1.2.0"},
+	}
+}
+
+func TestRegistryNotificationHandler(t *testing.T) {
+	r := setUpNotificationTest()
+	envelope := registry.Envelope{Events: []registry.Event{
+		manifestPush("shop/api"),
+		manifestPush("shop/web"),
+		manifestPush("other/api"),
+		{Action: "pull", Target: registry.EventTarget{MediaType: registry.MediaTypeDockerManifest, Repository: "shop/api"}},
+	}}
+
+	code, _ := postNotification(r, "/notifications/registry", "", envelope)
+	assert.Equal(t, 401, code)
+	code, _ = postNotification(r, "/notifications/registry", "Bearer wrong", envelope)
+	assert.Equal(t, 401, code)
+
+	code, triggered := postNotification(r, "/notifications/registry", "Bearer registry-token", envelope)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, []string{"api"}, triggered)
+
+	code, _ = postNotification(r, "/notifications/registry", "Bearer registry-token", "not an envelope")
+	assert.Equal(t, 400, code)
+}
+
+func TestDockerhubNotificationHandler(t *testing.T) {
+	r := setUpNotificationTest()
+	payload := map[string]interface{}{
+		"push_data":  map[string]string{"tag": "v1.2.0"},
+		"repository": map[string]string{"namespace": "shop", "name": "api", "repo_name": "shop/api"},
+	}
+
+	code, _ := postNotification(r, "/notifications/dockerhub?token=wrong", "", payload)
+	assert.Equal(t, 401, code)
+
+	code, triggered := postNotification(r, "/notifications/dockerhub?token=hub-token", "", payload)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, []string{"api"}, triggered)
+
+	// repositories of other namespaces, or that aren't watched, are acknowledged but ignored
+	payload["repository"] = map[string]string{"namespace": "other", "name": "api", "repo_name": "other/api"}
+	code, triggered = postNotification(r, "/notifications/dockerhub?token=hub-token", "", payload)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, []string{}, triggered)
+	payload["repository"] = map[string]string{"namespace": "shop", "name": "cart", "repo_name": "shop/cart"}
+	code, triggered = postNotification(r, "/notifications/dockerhub?token=hub-token", "", payload)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, []string{}, triggered)
+
+	payload["repository"] = map[string]string{"namespace": "shop", "name": "web", "repo_name": "shop/web"}
+	code, triggered = postNotification(r, "/notifications/dockerhub?token=hub-token", "", payload)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, []string{}, triggered)
+}
@@ -0,0 +1,119 @@
+package notifier
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const defaultSMTPPort = 587
+
+// Sends notifications as plain text emails through an SMTP server. STARTTLS
+// is used when the server supports it, which PLAIN authentication requires.
+type EmailNotifier struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func newEmailNotifier(conf *viper.Viper) (*EmailNotifier, error) {
+	host, err := requireSetting(conf, "smtp_host")
+	if err != nil {
+		return nil, err
+	}
+	from, err := requireSetting(conf, "from")
+	if err != nil {
+		return nil, err
+	}
+	to := conf.GetStringSlice("to")
+	if len(to) == 0 {
+		return nil, fmt.Errorf("to is required")
+	}
+	port := defaultSMTPPort
+	if conf.IsSet("smtp_port") {
+		port = conf.GetInt("smtp_port")
+	}
+	return &EmailNotifier{
+		Host:     host,
+		Port:     port,
+		Username: conf.GetString("smtp_username"),
+		Password: conf.GetString("smtp_password"),
+		From:     from,
+		To:       to,
+	}, nil
+}
+
+func (notifier *EmailNotifier) Notify(ctx context.Context, n Notification) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(notifier.Host, strconv.Itoa(notifier.Port)))
+	if err != nil {
+		return err
+	}
+	// net/smtp isn't context aware, so bound the whole conversation instead
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, notifier.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: notifier.Host}); err != nil {
+			return err
+		}
+	}
+	if notifier.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", notifier.Username, notifier.Password, notifier.Host)); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(notifier.From); err != nil {
+		return err
+	}
+	for _, to := range notifier.To {
+		if err = c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(notifier.message(n)); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (notifier *EmailNotifier) message(n Notification) []byte {
+	severity := string(n.Severity)
+	subject := fmt.Sprintf("[registrywatcher] %s%s", strings.ToUpper(severity[:1]), severity[1:])
+	if n.Repo != "" {
+		subject = fmt.Sprintf("%s: %s", subject, n.Repo)
+	}
+	headers := []string{
+		fmt.Sprintf("From: %s", notifier.From),
+		fmt.Sprintf("To: %s", strings.Join(notifier.To, ", ")),
+		fmt.Sprintf("Subject: %s", subject),
+		fmt.Sprintf("Date: %s", n.Timestamp.Format(time.RFC1123Z)),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+	}
+	return []byte(strings.Join(headers, "\r\n") + "\r\n\r\n" + n.Text + "\r\n")
+}
@@ -0,0 +1,166 @@
+package notifier
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"github.com/dsaidgovsg/registrywatcher/log"
+	"github.com/dsaidgovsg/registrywatcher/utils"
+	"github.com/spf13/viper"
+)
+
+// Something that happened to a repository's deployments, rendered into
+// a notification by the template of the same name
+type Event string
+
+const (
+	// the watcher found a new tag to deploy
+	EventDetected Event = "detected"
+	// the digest of the deployed tag changed in the registry
+	EventDigestChanged Event = "digest_changed"
+	EventStarted       Event = "started"
+	EventSucceeded     Event = "succeeded"
+	EventFailed        Event = "failed"
+	// the deployment's rollout stopped being monitored before it finished,
+	// because it timed out or registrywatcher shut down
+	EventUnmonitored Event = "unmonitored"
+	// auto deployment was turned off
+	EventPaused  Event = "paused"
+	EventResumed Event = "resumed"
+	// the repository is rolled back, which turns off auto deployment
+	EventRolledBack Event = "rolled_back"
+	// an auto deployment waits for the deployment window to open
+	EventDeferred     Event = "deferred"
+	EventWindowOpened Event = "window_opened"
+	// an auto deployment waits for approval, see require_approval
+	EventApprovalRequested Event = "approval_requested"
+	EventApproved          Event = "approved"
+	EventRejected          Event = "rejected"
+	EventExpired           Event = "expired"
+)
+
+var events = []Event{
+	EventDetected, EventDigestChanged, EventStarted, EventSucceeded, EventFailed, EventUnmonitored,
+	EventPaused, EventResumed, EventRolledBack, EventDeferred, EventWindowOpened,
+	EventApprovalRequested, EventApproved, EventRejected, EventExpired,
+}
+
+// Used when neither the repository nor the global config has a template for the event
+var defaultTemplates = map[Event]string{
+	EventDetected:      "Update: found tag `{{.Tag}}` in `{{.Repo}}`. Auto deployment will happen shortly.",
+	EventDigestChanged: "Update: the SHA of tag `{{.Tag}}` in `{{.Repo}}` changed. Auto deployment will happen shortly.",
+	EventStarted:       "Update: deploying {{.Target}} to tag `{{.Tag}}`",
+	EventSucceeded:     "Success: deployment of {{.Target}} for tag `{{.Tag}}`{{with .Description}} ({{.}}){{end}} succeeded",
+	EventFailed:        "Error: deployment of {{.Target}} for tag `{{.Tag}}` failed{{with .Description}}: {{.}}{{end}}",
+	EventUnmonitored:   "Update: stopped monitoring deployment of {{.Target}} for tag `{{.Tag}}`{{with .Description}} ({{.}}){{end}}",
+	EventPaused:        "Turned off auto deployment for repo `{{.Repo}}`",
+	EventResumed:       "Turned on auto deployment for repo `{{.Repo}}`",
+	EventRolledBack:    "Update: rolling back `{{.Repo}}` to tag `{{.Tag}}`, auto deployment is turned off",
+	EventDeferred:      "Update: auto deployment of tag `{{.Tag}}` in `{{.Repo}}` is deferred, {{.Description}}. It will happen when the deployment window opens.",
+	EventWindowOpened:  "Update: the deployment window of `{{.Repo}}` is open, deploying deferred tag `{{.Tag}}`.",
+	EventApprovalRequested: "Update: deployment of tag `{{.Tag}}` in `{{.Repo}}` is waiting for approval until {{.ExpiresAt}}. " +
+		"Approve it with `POST /pending/{{.PendingID}}/approve` or reject it with `POST /pending/{{.PendingID}}/reject`.",
+	EventApproved: "Update: deployment of tag `{{.Tag}}` in `{{.Repo}}` was approved by {{.Requester}}.",
+	EventRejected: "Update: deployment of tag `{{.Tag}}` in `{{.Repo}}` was rejected by {{.Requester}}.",
+	EventExpired:  "Update: deployment of tag `{{.Tag}}` in `{{.Repo}}` was not approved in time and has expired.",
+}
+
+// What a template can refer to, e.g. {{.Tag}}. Fields that don't apply to an event are empty.
+type EventData struct {
+	Repo        string
+	Tag         string
+	PreviousTag string
+	Digest      string
+	// human readable name of what is deployed, e.g. "Nomad job `registrywatcher`"
+	Target    string
+	Trigger   string
+	Requester string
+	// details of the outcome, e.g. why a deployment failed
+	Description string
+	// the ID of the deployment waiting for approval, and when it expires in RFC 3339
+	PendingID int64
+	ExpiresAt string
+	// links configured under repo_map.<repo>.links, e.g. {{.Links.dashboard}}
+	Links map[string]string
+}
+
+func (event Event) severity() Severity {
+	switch event {
+	case EventSucceeded:
+		return SeveritySuccess
+	case EventFailed:
+		return SeverityError
+	default:
+		return SeverityUpdate
+	}
+}
+
+// Notifies about event, with a button for each of actions acting on the
+// deployed tag, or on the deployment waiting for approval if there is one
+func PostEvent(conf *viper.Viper, event Event, data EventData, actions ...string) {
+	Notify(conf, Notification{
+		Repo:         data.Repo,
+		Event:        event,
+		Severity:     event.severity(),
+		Text:         RenderEvent(conf, event, data),
+		Actions:      actions,
+		ActionTarget: utils.SlackActionTarget{Repo: data.Repo, Tag: data.Tag, PendingID: data.PendingID},
+	})
+}
+
+/*
+ * Renders event with the template configured under
+ * repo_map.<repo>.templates.<event>, or else templates.<event>, falling back
+ * to the built-in one. A configured template that fails to render is logged
+ * and the built-in one is used instead, so the notification isn't lost.
+ */
+func RenderEvent(conf *viper.Viper, event Event, data EventData) string {
+	if data.Links == nil {
+		data.Links = conf.GetStringMapString(fmt.Sprintf("repo_map.%s.links", data.Repo))
+	}
+	text, err := renderTemplate(eventTemplate(conf, data.Repo, event), data)
+	if err != nil {
+		log.LogAppErr(fmt.Sprintf("Cannot render %s template for %s", event, data.Repo), err)
+		text, _ = renderTemplate(defaultTemplates[event], data)
+	}
+	return text
+}
+
+func eventTemplate(conf *viper.Viper, repoName string, event Event) string {
+	if repoName != "" {
+		if key := fmt.Sprintf("repo_map.%s.templates.%s", repoName, event); conf.IsSet(key) {
+			return conf.GetString(key)
+		}
+	}
+	if key := fmt.Sprintf("templates.%s", event); conf.IsSet(key) {
+		return conf.GetString(key)
+	}
+	return defaultTemplates[event]
+}
+
+func renderTemplate(text string, data EventData) (string, error) {
+	tmpl, err := template.New("").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Checks that the templates of the watched repositories parse and only refer to fields of EventData
+func validateTemplates(conf *viper.Viper) error {
+	repoNames := append([]string{""}, conf.GetStringSlice("watched_repositories")...)
+	for _, repoName := range repoNames {
+		for _, event := range events {
+			data := EventData{Repo: repoName, Links: map[string]string{}}
+			if _, err := renderTemplate(eventTemplate(conf, repoName, event), data); err != nil {
+				return fmt.Errorf("invalid %s template for repo %q: %v", event, repoName, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/spf13/viper"
+)
+
+// Posts to a Mattermost incoming webhook, optionally overriding its channel and username
+type MattermostNotifier struct {
+	WebhookURL string
+	Channel    string
+	Username   string
+}
+
+// Mattermost accepts Slack style attachments
+type mattermostMessage struct {
+	Channel     string                 `json:"channel,omitempty"`
+	Username    string                 `json:"username,omitempty"`
+	Attachments []mattermostAttachment `json:"attachments"`
+}
+
+type mattermostAttachment struct {
+	Fallback string `json:"fallback"`
+	Color    string `json:"color"`
+	Text     string `json:"text"`
+}
+
+func newMattermostNotifier(conf *viper.Viper) (*MattermostNotifier, error) {
+	webhookURL, err := requireSetting(conf, "webhook_url")
+	if err != nil {
+		return nil, err
+	}
+	return &MattermostNotifier{
+		WebhookURL: webhookURL,
+		Channel:    conf.GetString("channel"),
+		Username:   conf.GetString("username"),
+	}, nil
+}
+
+func (notifier *MattermostNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(mattermostMessage{
+		Channel:  notifier.Channel,
+		Username: notifier.Username,
+		Attachments: []mattermostAttachment{{
+			Fallback: n.Text,
+			Color:    severityColors[n.Severity],
+			Text:     n.Text,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, notifier.WebhookURL, body, nil)
+}
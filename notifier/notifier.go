@@ -0,0 +1,187 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dsaidgovsg/registrywatcher/log"
+	"github.com/dsaidgovsg/registrywatcher/utils"
+	"github.com/spf13/viper"
+)
+
+type Severity string
+
+const (
+	SeverityUpdate  Severity = "update"
+	SeveritySuccess Severity = "success"
+	SeverityError   Severity = "error"
+)
+
+var severities = []Severity{SeverityUpdate, SeveritySuccess, SeverityError}
+
+// Notifier used when no routes are configured, posting to webhook_url
+const defaultNotifier = "slack"
+
+// Upper bound on delivering a notification to a single notifier
+const notifyTimeout = 10 * time.Second
+
+// Something registrywatcher did or found, e.g. a deployment that failed
+type Notification struct {
+	// empty if the notification isn't about a single repository
+	Repo string
+	// empty if the notification isn't about a deployment event
+	Event     Event
+	Severity  Severity
+	Text      string
+	Timestamp time.Time
+	// buttons offered by notifiers that support them, acting on ActionTarget
+	Actions      []string
+	ActionTarget utils.SlackActionTarget
+}
+
+// A backend that notifications are delivered to, configured under notifiers.<name>
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+var httpClient = &http.Client{Timeout: notifyTimeout}
+
+func PostUpdate(conf *viper.Viper, repoName, text string) {
+	Notify(conf, Notification{Repo: repoName, Severity: SeverityUpdate, Text: text})
+}
+
+func PostError(conf *viper.Viper, repoName, text string) {
+	Notify(conf, Notification{Repo: repoName, Severity: SeverityError, Text: text})
+}
+
+// Delivers n to each notifier it is routed to. Failures are only logged,
+// so that a notifier being down doesn't affect deployments.
+func Notify(conf *viper.Viper, n Notification) {
+	if _, ok := os.LookupEnv("DEBUG"); ok {
+		return
+	}
+	if n.Timestamp.IsZero() {
+		n.Timestamp = time.Now()
+	}
+	for _, name := range Route(conf, n.Repo, n.Severity) {
+		notifier, err := New(conf, name)
+		if err != nil {
+			log.LogAppErr(fmt.Sprintf("Cannot set up notifier %s", name), err)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		if err = notifier.Notify(ctx, n); err != nil {
+			log.LogAppErr(fmt.Sprintf("Cannot post notification to %s", name), err)
+		}
+		cancel()
+	}
+}
+
+/*
+ * Returns the names of the notifiers that notifications of severity about
+ * repoName are routed to. notify.<severity> in repo_map overrides the global
+ * notify.<severity>, and an empty list mutes them. Without either, they go
+ * to the Slack webhook_url, if it is set.
+ */
+func Route(conf *viper.Viper, repoName string, severity Severity) []string {
+	if repoName != "" {
+		if key := fmt.Sprintf("repo_map.%s.notify.%s", repoName, severity); conf.IsSet(key) {
+			return conf.GetStringSlice(key)
+		}
+	}
+	if key := fmt.Sprintf("notify.%s", severity); conf.IsSet(key) {
+		return conf.GetStringSlice(key)
+	}
+	if conf.GetString("webhook_url") == "" && !conf.IsSet(fmt.Sprintf("notifiers.%s", defaultNotifier)) {
+		return []string{}
+	}
+	return []string{defaultNotifier}
+}
+
+// Sets up the notifier configured under notifiers.<name>. The slack notifier
+// posts to webhook_url unless it is configured.
+func New(conf *viper.Viper, name string) (Notifier, error) {
+	sub := conf.Sub(fmt.Sprintf("notifiers.%s", name))
+	if sub == nil {
+		if name == defaultNotifier {
+			return newSlackNotifier(conf, conf.GetString("webhook_url"))
+		}
+		return nil, fmt.Errorf("notifier %s is not configured", name)
+	}
+
+	var notifier Notifier
+	var err error
+	switch notifierType := sub.GetString("type"); notifierType {
+	case "slack":
+		notifier, err = newSlackNotifier(conf, sub.GetString("webhook_url"))
+	case "teams":
+		notifier, err = newTeamsNotifier(sub)
+	case "mattermost":
+		notifier, err = newMattermostNotifier(sub)
+	case "email":
+		notifier, err = newEmailNotifier(sub)
+	case "webhook":
+		notifier, err = newWebhookNotifier(sub)
+	default:
+		err = fmt.Errorf("unknown type %q", notifierType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid notifier %s: %v", name, err)
+	}
+	return notifier, nil
+}
+
+// Checks that every configured notifier can be set up, that every route
+// of the watched repositories leads to one, and that their templates render
+func ValidateConfig(conf *viper.Viper) error {
+	for name := range conf.GetStringMap("notifiers") {
+		if _, err := New(conf, name); err != nil {
+			return err
+		}
+	}
+	repoNames := append([]string{""}, conf.GetStringSlice("watched_repositories")...)
+	for _, repoName := range repoNames {
+		for _, severity := range severities {
+			for _, name := range Route(conf, repoName, severity) {
+				if _, err := New(conf, name); err != nil {
+					return fmt.Errorf("invalid %s notification route for repo %q: %v", severity, repoName, err)
+				}
+			}
+		}
+	}
+	return validateTemplates(conf)
+}
+
+// Gets a notifier setting that must be set
+func requireSetting(conf *viper.Viper, key string) (string, error) {
+	value := conf.GetString(key)
+	if value == "" {
+		return "", fmt.Errorf("%s is required", key)
+	}
+	return value, nil
+}
+
+// POSTs body as JSON, failing on a non-2xx response
+func postJSON(ctx context.Context, url string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with status %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+//go:build unit
+// +build unit
+
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dsaidgovsg/registrywatcher/utils"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoute(t *testing.T) {
+	conf := viper.New()
+	assert.Equal(t, []string{}, Route(conf, "api", SeverityError))
+
+	conf.Set("webhook_url", "https://hooks.slack.com/services/x")
+	assert.Equal(t, []string{"slack"}, Route(conf, "api", SeverityError))
+
+	conf.Set("notify.error", []string{"slack", "oncall"})
+	conf.Set("repo_map.api.notify.error", []string{"oncall"})
+	conf.Set("repo_map.api.notify.update", []string{})
+	assert.Equal(t, []string{"oncall"}, Route(conf, "api", SeverityError))
+	assert.Equal(t, []string{"slack", "oncall"}, Route(conf, "web", SeverityError))
+	assert.Equal(t, []string{"slack", "oncall"}, Route(conf, "", SeverityError))
+	assert.Equal(t, []string{}, Route(conf, "api", SeverityUpdate))
+	assert.Equal(t, []string{"slack"}, Route(conf, "api", SeveritySuccess))
+}
+
+func TestValidateConfig(t *testing.T) {
+	conf := viper.New()
+	conf.Set("watched_repositories", []string{"api"})
+	conf.Set("webhook_url", "https://hooks.slack.com/services/x")
+	assert.Nil(t, ValidateConfig(conf))
+
+	conf.Set("notifiers.oncall.type", "teams")
+	assert.NotNil(t, ValidateConfig(conf))
+	conf.Set("notifiers.oncall.webhook_url", "https://example.webhook.office.com/x")
+	assert.Nil(t, ValidateConfig(conf))
+
+	conf.Set("repo_map.api.notify.error", []string{"pager"})
+	assert.NotNil(t, ValidateConfig(conf))
+	conf.Set("repo_map.api.notify.error", []string{"oncall"})
+	assert.Nil(t, ValidateConfig(conf))
+
+	conf.Set("notifiers.mail.type", "email")
+	conf.Set("notifiers.mail.smtp_host", "smtp.example.com")
+	conf.Set("notifiers.mail.from", "registrywatcher@example.com")
+	assert.NotNil(t, ValidateConfig(conf))
+	conf.Set("notifiers.mail.to", []string{"ops@example.com"})
+	assert.Nil(t, ValidateConfig(conf))
+
+	conf.Set("notifiers.pigeon.type", "pigeon")
+	assert.NotNil(t, ValidateConfig(conf))
+}
+
+// Starts a server that decodes the JSON body of each request into received
+func newJSONServer(t *testing.T, received interface{}, header *http.Header) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		if header != nil {
+			*header = r.Header
+		}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(received))
+	}))
+}
+
+var testNotification = Notification{
+	Repo:      "api",
+	Severity:  SeverityError,
+	Text:      "Error: failed to deploy `api` for tag `v1.2.0`",
+	Timestamp: time.Unix(1760000000, 0),
+}
+
+func TestTeamsNotifier(t *testing.T) {
+	var received map[string]string
+	server := newJSONServer(t, &received, nil)
+	defer server.Close()
+
+	notifier := &TeamsNotifier{WebhookURL: server.URL}
+	assert.Nil(t, notifier.Notify(context.Background(), testNotification))
+	assert.Equal(t, "MessageCard", received["@type"])
+	assert.Equal(t, "FF0000", received["themeColor"])
+	assert.Equal(t, testNotification.Text, received["text"])
+}
+
+func TestMattermostNotifier(t *testing.T) {
+	var received mattermostMessage
+	server := newJSONServer(t, &received, nil)
+	defer server.Close()
+
+	notifier := &MattermostNotifier{WebhookURL: server.URL, Channel: "deploys"}
+	assert.Nil(t, notifier.Notify(context.Background(), testNotification))
+	assert.Equal(t, "deploys", received.Channel)
+	assert.Equal(t, 1, len(received.Attachments))
+	assert.Equal(t, red, received.Attachments[0].Color)
+	assert.Equal(t, testNotification.Text, received.Attachments[0].Text)
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var body []byte
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(utils.WebhookSignatureHeader)
+	}))
+	defer server.Close()
+
+	notifier := &WebhookNotifier{URL: server.URL, Secret: "secret"}
+	assert.Nil(t, notifier.Notify(context.Background(), testNotification))
+	assert.Equal(t, utils.SignWebhookPayload("secret", body), signature)
+
+	var received webhookNotification
+	assert.Nil(t, json.Unmarshal(body, &received))
+	assert.Equal(t, webhookNotification{
+		Repository: "api",
+		Severity:   SeverityError,
+		Text:       testNotification.Text,
+		Timestamp:  1760000000,
+	}, received)
+}
+
+func TestWebhookNotifierErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	notifier := &WebhookNotifier{URL: server.URL}
+	assert.NotNil(t, notifier.Notify(context.Background(), testNotification))
+}
+
+func TestSlackNotifier(t *testing.T) {
+	var received map[string]interface{}
+	server := newJSONServer(t, &received, nil)
+	defer server.Close()
+
+	n := testNotification
+	n.Actions = []string{utils.SlackActionRollback}
+	notifier := &SlackNotifier{WebhookURL: server.URL}
+	assert.Nil(t, notifier.Notify(context.Background(), n))
+	assert.Nil(t, received["blocks"])
+	assert.Equal(t, 1, len(received["attachments"].([]interface{})))
+
+	received = nil
+	notifier.Buttons = true
+	assert.Nil(t, notifier.Notify(context.Background(), n))
+	assert.Equal(t, testNotification.Text, received["text"])
+	assert.Equal(t, 2, len(received["blocks"].([]interface{})))
+}
+
+func TestSlackActionMessage(t *testing.T) {
+	target := utils.SlackActionTarget{Repo: "api", Tag: "v1.2.0", PendingID: 7}
+	msg := slackActionMessage("Update: waiting for approval", target, []string{utils.SlackActionDeploy, utils.SlackActionSkip})
+	body, err := json.Marshal(msg)
+	assert.Nil(t, err)
+
+	var decoded struct {
+		Text   string `json:"text"`
+		Blocks []struct {
+			Type     string `json:"type"`
+			Elements []struct {
+				ActionID string `json:"action_id"`
+				Value    string `json:"value"`
+				Style    string `json:"style"`
+			} `json:"elements"`
+		} `json:"blocks"`
+	}
+	assert.Nil(t, json.Unmarshal(body, &decoded))
+	assert.Equal(t, "Update: waiting for approval", decoded.Text)
+	assert.Equal(t, 2, len(decoded.Blocks))
+	assert.Equal(t, "section", decoded.Blocks[0].Type)
+	assert.Equal(t, "actions", decoded.Blocks[1].Type)
+
+	buttons := decoded.Blocks[1].Elements
+	assert.Equal(t, 2, len(buttons))
+	assert.Equal(t, utils.SlackActionDeploy, buttons[0].ActionID)
+	assert.Equal(t, "primary", buttons[0].Style)
+	assert.Equal(t, utils.SlackActionSkip, buttons[1].ActionID)
+
+	var decodedTarget utils.SlackActionTarget
+	assert.Nil(t, json.Unmarshal([]byte(buttons[1].Value), &decodedTarget))
+	assert.Equal(t, target, decodedTarget)
+}
+
+func TestEmailMessage(t *testing.T) {
+	notifier := &EmailNotifier{
+		From: "registrywatcher@example.com",
+		To:   []string{"ops@example.com", "dev@example.com"},
+	}
+	msg := string(notifier.message(testNotification))
+	parts := strings.SplitN(msg, "\r\n\r\n", 2)
+	assert.Equal(t, 2, len(parts))
+	headers := strings.Split(parts[0], "\r\n")
+	assert.Contains(t, headers, "From: registrywatcher@example.com")
+	assert.Contains(t, headers, "To: ops@example.com, dev@example.com")
+	assert.Contains(t, headers, "Subject: [registrywatcher] Error: api")
+	assert.Contains(t, headers, "Date: "+testNotification.Timestamp.Format(time.RFC1123Z))
+	assert.Equal(t, testNotification.Text+"\r\n", parts[1])
+}
+
+func TestRenderEvent(t *testing.T) {
+	conf := viper.New()
+	data := EventData{Repo: "api", Tag: "v1.2.0", Target: "Nomad job `api`"}
+	assert.Equal(t, "Update: deploying Nomad job `api` to tag `v1.2.0`", RenderEvent(conf, EventStarted, data))
+
+	data.Description = "3 allocations healthy"
+	assert.Equal(t, "Success: deployment of Nomad job `api` for tag `v1.2.0` (3 allocations healthy) succeeded", RenderEvent(conf, EventSucceeded, data))
+
+	conf.Set("templates.started", "Deploying {{.Repo}}@{{.Tag}}")
+	conf.Set("repo_map.api.templates.started", "Deploying {{.Tag}}, watch {{.Links.dashboard}}")
+	conf.Set("repo_map.api.links.dashboard", "https://grafana.example.com/d/api")
+	assert.Equal(t, "Deploying v1.2.0, watch https://grafana.example.com/d/api", RenderEvent(conf, EventStarted, data))
+	data.Repo = "web"
+	assert.Equal(t, "Deploying web@v1.2.0", RenderEvent(conf, EventStarted, data))
+
+	// falls back to the built-in template
+	conf.Set("templates.paused", "{{.Repo.Name}}")
+	assert.Equal(t, "Turned off auto deployment for repo `web`", RenderEvent(conf, EventPaused, data))
+
+	pending := EventData{Repo: "api", Tag: "v1.2.0", PendingID: 7, ExpiresAt: "2020-01-02T03:04:05Z", Requester: "alice"}
+	assert.Equal(t, "Update: deployment of tag `v1.2.0` in `api` is waiting for approval until 2020-01-02T03:04:05Z. "+
+		"Approve it with `POST /pending/7/approve` or reject it with `POST /pending/7/reject`.", RenderEvent(conf, EventApprovalRequested, pending))
+	assert.Equal(t, "Update: deployment of tag `v1.2.0` in `api` was approved by alice.", RenderEvent(conf, EventApproved, pending))
+	conf.Set("repo_map.api.templates.approved", "{{.Requester}} approved #{{.PendingID}}")
+	assert.Equal(t, "alice approved #7", RenderEvent(conf, EventApproved, pending))
+}
+
+func TestValidateTemplates(t *testing.T) {
+	conf := viper.New()
+	conf.Set("watched_repositories", []string{"api"})
+	assert.Nil(t, ValidateConfig(conf))
+
+	conf.Set("repo_map.api.templates.failed", "Error: {{.Repo} failed")
+	assert.NotNil(t, ValidateConfig(conf))
+	conf.Set("repo_map.api.templates.failed", "Error: {{.Repository}} failed")
+	assert.NotNil(t, ValidateConfig(conf))
+	conf.Set("repo_map.api.templates.failed", "Error: {{.Repo}} failed, see {{.Links.runbook}}")
+	assert.Nil(t, ValidateConfig(conf))
+}
+
+func TestPostEventSeverity(t *testing.T) {
+	assert.Equal(t, SeverityError, EventFailed.severity())
+	assert.Equal(t, SeveritySuccess, EventSucceeded.severity())
+	assert.Equal(t, SeverityUpdate, EventDigestChanged.severity())
+	assert.Equal(t, SeverityUpdate, EventRejected.severity())
+}
@@ -0,0 +1,109 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/dsaidgovsg/registrywatcher/utils"
+	"github.com/nlopes/slack"
+	"github.com/spf13/viper"
+)
+
+const (
+	green  = "#00FF00"
+	red    = "#FF0000"
+	orange = "#FFA500"
+	yarly  = "https://i.imgur.com/LWRp6ZT.png"
+	nowai  = "https://i.imgur.com/MJ5Qx8f.jpg"
+)
+
+var severityColors = map[Severity]string{
+	SeverityUpdate:  orange,
+	SeveritySuccess: green,
+	SeverityError:   red,
+}
+
+var slackActionLabels = map[string]string{
+	utils.SlackActionDeploy:   "Deploy",
+	utils.SlackActionSkip:     "Skip this version",
+	utils.SlackActionRollback: "Rollback",
+	utils.SlackActionPause:    "Pause auto-deploy",
+}
+
+// Posts to a Slack incoming webhook
+type SlackNotifier struct {
+	WebhookURL string
+	// whether buttons can be added, which needs slack_signing_secret for
+	// registrywatcher to verify the interactions Slack calls back with
+	Buttons bool
+}
+
+// A Slack message with Block Kit blocks, which slack.WebhookMessage doesn't support
+type slackBlockMessage struct {
+	// shown in notifications
+	Text   string       `json:"text"`
+	Blocks slack.Blocks `json:"blocks"`
+}
+
+func newSlackNotifier(conf *viper.Viper, webhookURL string) (*SlackNotifier, error) {
+	if webhookURL == "" {
+		return nil, fmt.Errorf("webhook_url is required")
+	}
+	return &SlackNotifier{
+		WebhookURL: webhookURL,
+		Buttons:    conf.GetString("slack_signing_secret") != "",
+	}, nil
+}
+
+func (notifier *SlackNotifier) Notify(ctx context.Context, n Notification) error {
+	var body []byte
+	var err error
+	if notifier.Buttons && len(n.Actions) > 0 {
+		body, err = json.Marshal(slackActionMessage(n.Text, n.ActionTarget, n.Actions))
+	} else {
+		attachment := slack.Attachment{
+			Color: severityColors[n.Severity],
+			Text:  n.Text,
+			Ts:    json.Number(strconv.FormatInt(n.Timestamp.Unix(), 10)),
+		}
+		switch n.Severity {
+		case SeveritySuccess:
+			attachment.ThumbURL = yarly
+		case SeverityError:
+			attachment.ThumbURL = nowai
+		}
+		body, err = json.Marshal(slack.WebhookMessage{Attachments: []slack.Attachment{attachment}})
+	}
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, notifier.WebhookURL, body, nil)
+}
+
+// Lays out text with a button for each of actions, acting on target.
+// The buttons are handled by the /slack/interactions endpoint.
+func slackActionMessage(text string, target utils.SlackActionTarget, actions []string) slackBlockMessage {
+	// a struct of strings and an int always marshals
+	value, _ := json.Marshal(target)
+	buttons := []slack.BlockElement{}
+	for _, action := range actions {
+		label := slack.NewTextBlockObject(slack.PlainTextType, slackActionLabels[action], false, false)
+		button := slack.NewButtonBlockElement(action, string(value), label)
+		switch action {
+		case utils.SlackActionDeploy:
+			button.WithStyle(slack.StylePrimary)
+		case utils.SlackActionRollback:
+			button.WithStyle(slack.StyleDanger)
+		}
+		buttons = append(buttons, button)
+	}
+	return slackBlockMessage{
+		Text: text,
+		Blocks: slack.Blocks{BlockSet: []slack.Block{
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+			slack.NewActionBlock("", buttons...),
+		}},
+	}
+}
@@ -0,0 +1,45 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Posts to a Microsoft Teams incoming webhook
+type TeamsNotifier struct {
+	WebhookURL string
+}
+
+// A legacy actionable message card, which Teams incoming webhooks accept
+type teamsMessageCard struct {
+	Type       string `json:"@type"`
+	Context    string `json:"@context"`
+	ThemeColor string `json:"themeColor"`
+	Summary    string `json:"summary"`
+	Text       string `json:"text"`
+}
+
+func newTeamsNotifier(conf *viper.Viper) (*TeamsNotifier, error) {
+	webhookURL, err := requireSetting(conf, "webhook_url")
+	if err != nil {
+		return nil, err
+	}
+	return &TeamsNotifier{WebhookURL: webhookURL}, nil
+}
+
+func (notifier *TeamsNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(teamsMessageCard{
+		Type:       "MessageCard",
+		Context:    "https://schema.org/extensions",
+		ThemeColor: strings.TrimPrefix(severityColors[n.Severity], "#"),
+		Summary:    n.Text,
+		Text:       n.Text,
+	})
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, notifier.WebhookURL, body, nil)
+}
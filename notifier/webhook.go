@@ -0,0 +1,54 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/dsaidgovsg/registrywatcher/utils"
+	"github.com/spf13/viper"
+)
+
+// POSTs notifications as JSON to any URL, signed like the webhook deployer
+// if a secret is set
+type WebhookNotifier struct {
+	URL    string
+	Secret string
+}
+
+type webhookNotification struct {
+	Repository string   `json:"repository"`
+	Event      Event    `json:"event,omitempty"`
+	Severity   Severity `json:"severity"`
+	Text       string   `json:"text"`
+	Timestamp  int64    `json:"timestamp"`
+}
+
+func newWebhookNotifier(conf *viper.Viper) (*WebhookNotifier, error) {
+	url, err := requireSetting(conf, "url")
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookNotifier{
+		URL:    url,
+		Secret: conf.GetString("secret"),
+	}, nil
+}
+
+func (notifier *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(webhookNotification{
+		Repository: n.Repo,
+		Event:      n.Event,
+		Severity:   n.Severity,
+		Text:       n.Text,
+		Timestamp:  n.Timestamp.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if notifier.Secret != "" {
+		header.Set(utils.WebhookSignatureHeader, utils.SignWebhookPayload(notifier.Secret, body))
+	}
+	return postJSON(ctx, notifier.URL, body, header)
+}
@@ -0,0 +1,102 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Platform identifies the os and cpu architecture an image is built for.
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+/*
+ * Parses a platform in the "os/arch[/variant]" format used by docker,
+ * e.g. "linux/amd64" or "linux/arm64/v8".
+ */
+func ParsePlatform(platform string) (Platform, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", platform)
+	}
+	rtn := Platform{
+		OS:           parts[0],
+		Architecture: parts[1],
+	}
+	if len(parts) == 3 {
+		rtn.Variant = parts[2]
+	}
+	return rtn, nil
+}
+
+func (p Platform) String() string {
+	if p.Variant != "" {
+		return fmt.Sprintf("%s/%s/%s", p.OS, p.Architecture, p.Variant)
+	}
+	return fmt.Sprintf("%s/%s", p.OS, p.Architecture)
+}
+
+// Matches reports whether other satisfies p. An empty variant in p matches any variant.
+func (p Platform) Matches(other Platform) bool {
+	if p.OS != other.OS || p.Architecture != other.Architecture {
+		return false
+	}
+	return p.Variant == "" || p.Variant == other.Variant
+}
+
+// PlatformDescriptor references a platform specific manifest inside an index.
+type PlatformDescriptor struct {
+	Descriptor
+	Platform *Platform `json:"platform,omitempty"`
+}
+
+// Index is a Docker manifest list or an OCI image index.
+type Index struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	MediaType     string               `json:"mediaType,omitempty"`
+	Manifests     []PlatformDescriptor `json:"manifests"`
+
+	// Digest of the index itself, taken from the response headers.
+	Digest string `json:"-"`
+}
+
+// Platforms lists the platforms the index has manifests for.
+func (index *Index) Platforms() []Platform {
+	rtn := []Platform{}
+	for _, manifest := range index.Manifests {
+		if manifest.Platform != nil {
+			rtn = append(rtn, *manifest.Platform)
+		}
+	}
+	return rtn
+}
+
+// Resolve returns the first manifest in the index matching platform.
+func (index *Index) Resolve(platform Platform) (*PlatformDescriptor, error) {
+	for i, manifest := range index.Manifests {
+		if manifest.Platform != nil && platform.Matches(*manifest.Platform) {
+			return &index.Manifests[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no manifest found for platform %s", platform)
+}
+
+func isIndexMediaType(mediaType string) bool {
+	return mediaType == MediaTypeDockerManifestList || mediaType == MediaTypeOCIIndex
+}
+
+func decodeIndex(body []byte, mediaType, digest string) (*Index, error) {
+	index := &Index{}
+	if err := json.Unmarshal(body, index); err != nil {
+		return nil, err
+	}
+	if index.MediaType == "" {
+		// the mediaType field is optional for OCI indexes
+		index.MediaType = mediaType
+	}
+	index.Digest = digest
+	return index, nil
+}
@@ -1,6 +1,7 @@
 package registry
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -14,8 +15,12 @@ var (
 // getPaginatedJson accepts a string and a pointer, and returns the
 // next page URL while updating pointed-to variable with a parsed JSON
 // value. When there are no more pages it returns `ErrNoMorePages`.
-func (registry *Registry) getPaginatedJson(url string, response interface{}) (string, error) {
-	resp, err := registry.Client.Get(url)
+func (registry *Registry) getPaginatedJson(ctx context.Context, url string, response interface{}) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := registry.Client.Do(req)
 	if err != nil {
 		return "", err
 	}
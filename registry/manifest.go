@@ -0,0 +1,258 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// manifestMediaTypes are sent in the Accept header of manifest requests,
+// without them some registries fall back to the deprecated schema 1 manifest
+// which has a different digest from the one pushed.
+var manifestMediaTypes = []string{
+	MediaTypeDockerManifest,
+	MediaTypeDockerManifestList,
+	MediaTypeOCIManifest,
+	MediaTypeOCIIndex,
+}
+
+/*
+ * Returns the content digest of the manifest that reference (a tag or a
+ * digest) currently points to, as reported in the Docker-Content-Digest
+ * header. Only a HEAD request is made, so this does not count towards
+ * Docker Hub pull rate limits.
+ */
+func (registry *Registry) Digest(ctx context.Context, repository, reference string) (string, error) {
+	url := registry.url("/v2/%s/manifests/%s", repository, reference)
+	registry.Logf("registry.manifest.head url=%s repository=%s reference=%s", url, repository, reference)
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+
+	resp, err := registry.Client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry did not return a Docker-Content-Digest header for %s:%s", repository, reference)
+	}
+	return digest, nil
+}
+
+// Descriptor references content such as an image config or a layer blob.
+type Descriptor struct {
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+	Digest    string `json:"digest"`
+}
+
+// Manifest is a Docker v2 schema 2 or OCI image manifest.
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+
+	// Digest of the manifest itself, taken from the response headers.
+	Digest string `json:"-"`
+}
+
+// Size is the total size of the image config and all layers, as stored in the registry.
+func (m *Manifest) Size() int64 {
+	size := m.Config.Size
+	for _, layer := range m.Layers {
+		size += layer.Size
+	}
+	return size
+}
+
+// ImageConfig holds the fields of an image config blob that are of interest to us.
+type ImageConfig struct {
+	Created      time.Time `json:"created"`
+	Architecture string    `json:"architecture"`
+	OS           string    `json:"os"`
+	Variant      string    `json:"variant,omitempty"`
+	Config       struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+}
+
+// Image is the metadata of a single image, combined from its manifest and config.
+type Image struct {
+	Digest       string            `json:"digest"`
+	MediaType    string            `json:"media_type"`
+	Created      time.Time         `json:"created"`
+	Architecture string            `json:"architecture"`
+	OS           string            `json:"os"`
+	Variant      string            `json:"variant,omitempty"`
+	Labels       map[string]string `json:"labels"`
+	Layers       []Descriptor      `json:"layers"`
+	Size         int64             `json:"size"`
+}
+
+/*
+ * Fetches and decodes the image manifest that reference points to.
+ * Manifest lists and image indexes are not handled here, resolve them to a
+ * platform specific digest with PlatformDigest first.
+ */
+func (registry *Registry) Manifest(ctx context.Context, repository, reference string) (*Manifest, error) {
+	mediaType, digest, body, err := registry.getManifest(ctx, repository, reference)
+	if err != nil {
+		return nil, err
+	}
+	switch mediaType {
+	case MediaTypeDockerManifest, MediaTypeOCIManifest:
+	default:
+		return nil, fmt.Errorf("unsupported manifest media type %q for %s:%s", mediaType, repository, reference)
+	}
+
+	manifest := &Manifest{}
+	if err := json.Unmarshal(body, manifest); err != nil {
+		return nil, err
+	}
+	if manifest.MediaType == "" {
+		// the mediaType field is optional for OCI manifests
+		manifest.MediaType = mediaType
+	}
+	manifest.Digest = digest
+	return manifest, nil
+}
+
+// Fetches and decodes the manifest list or image index that reference points to.
+func (registry *Registry) Index(ctx context.Context, repository, reference string) (*Index, error) {
+	mediaType, digest, body, err := registry.getManifest(ctx, repository, reference)
+	if err != nil {
+		return nil, err
+	}
+	if !isIndexMediaType(mediaType) {
+		return nil, fmt.Errorf("%s:%s is not a manifest list or image index, got media type %q", repository, reference, mediaType)
+	}
+	return decodeIndex(body, mediaType, digest)
+}
+
+/*
+ * Returns the digest of the image manifest for platform that reference points
+ * to. If reference is a single image manifest its own digest is returned,
+ * regardless of platform, since the registry does not record the platform of
+ * a plain manifest.
+ */
+func (registry *Registry) PlatformDigest(ctx context.Context, repository, reference string, platform Platform) (string, error) {
+	mediaType, digest, body, err := registry.getManifest(ctx, repository, reference)
+	if err != nil {
+		return "", err
+	}
+	if !isIndexMediaType(mediaType) {
+		return digest, nil
+	}
+	index, err := decodeIndex(body, mediaType, digest)
+	if err != nil {
+		return "", err
+	}
+	descriptor, err := index.Resolve(platform)
+	if err != nil {
+		return "", fmt.Errorf("%s:%s: %v", repository, reference, err)
+	}
+	return descriptor.Digest, nil
+}
+
+// returns the media type, digest and raw body of the manifest reference points to
+func (registry *Registry) getManifest(ctx context.Context, repository, reference string) (string, string, []byte, error) {
+	url := registry.url("/v2/%s/manifests/%s", repository, reference)
+	registry.Logf("registry.manifest.get url=%s repository=%s reference=%s", url, repository, reference)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", "", nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+
+	resp, err := registry.Client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return contentMediaType(resp), resp.Header.Get("Docker-Content-Digest"), body, nil
+}
+
+// Fetches and decodes the image config blob referenced by manifest.
+func (registry *Registry) ImageConfig(ctx context.Context, repository string, manifest *Manifest) (*ImageConfig, error) {
+	url := registry.url("/v2/%s/blobs/%s", repository, manifest.Config.Digest)
+	registry.Logf("registry.blob.get url=%s repository=%s digest=%s", url, repository, manifest.Config.Digest)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := registry.Client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ImageConfig{}
+	if err := json.NewDecoder(resp.Body).Decode(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Fetches the manifest and config of reference and combines them.
+func (registry *Registry) Image(ctx context.Context, repository, reference string) (*Image, error) {
+	manifest, err := registry.Manifest(ctx, repository, reference)
+	if err != nil {
+		return nil, err
+	}
+	config, err := registry.ImageConfig(ctx, repository, manifest)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{
+		Digest:       manifest.Digest,
+		MediaType:    manifest.MediaType,
+		Created:      config.Created,
+		Architecture: config.Architecture,
+		OS:           config.OS,
+		Variant:      config.Variant,
+		Labels:       config.Config.Labels,
+		Layers:       manifest.Layers,
+		Size:         manifest.Size(),
+	}, nil
+}
+
+// returns the media type of the response body without parameters such as charset
+func contentMediaType(resp *http.Response) string {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
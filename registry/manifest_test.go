@@ -0,0 +1,174 @@
+//go:build unit
+// +build unit
+
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRegistry(t *testing.T, handler http.HandlerFunc) *Registry {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	registry, err := New(ts.URL, "", "", "")
+	if err != nil {
+		t.Fatalf("couldn't create registry: %v", err)
+	}
+	return registry
+}
+
+func TestDigest(t *testing.T) {
+	registry := newTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+		case "/v2/prefix/repo/manifests/v1.0.0":
+			assert.Equal(t, "HEAD", r.Method)
+			assert.Contains(t, r.Header.Get("Accept"), MediaTypeDockerManifest)
+			assert.Contains(t, r.Header.Get("Accept"), MediaTypeOCIIndex)
+			w.Header().Set("Docker-Content-Digest", "sha256:abc")
+		case "/v2/prefix/repo/manifests/nodigest":
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	digest, err := registry.Digest(context.Background(), "prefix/repo", "v1.0.0")
+	assert.Nil(t, err)
+	assert.Equal(t, "sha256:abc", digest)
+
+	_, err = registry.Digest(context.Background(), "prefix/repo", "nodigest")
+	assert.NotNil(t, err)
+
+	_, err = registry.Digest(context.Background(), "prefix/repo", "missing")
+	assert.NotNil(t, err)
+}
+
+func TestImage(t *testing.T) {
+	registry := newTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+		case "/v2/prefix/repo/manifests/v1.0.0":
+			w.Header().Set("Content-Type", MediaTypeOCIManifest)
+			w.Header().Set("Docker-Content-Digest", "sha256:manifest")
+			w.Write([]byte(`{
+				"schemaVersion": 2,
+				"config": {"mediaType": "application/vnd.oci.image.config.v1+json", "size": 100, "digest": "sha256:config"},
+				"layers": [
+					{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "size": 1000, "digest": "sha256:layer1"},
+					{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "size": 2000, "digest": "sha256:layer2"}
+				]
+			}`))
+		case "/v2/prefix/repo/manifests/list":
+			w.Header().Set("Content-Type", MediaTypeDockerManifestList)
+			w.Write([]byte(`{"schemaVersion": 2, "manifests": []}`))
+		case "/v2/prefix/repo/blobs/sha256:config":
+			w.Write([]byte(`{
+				"created": "2020-01-02T03:04:05Z",
+				"architecture": "amd64",
+				"os": "linux",
+				"config": {"Labels": {"maintainer": "someone"}}
+			}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	image, err := registry.Image(context.Background(), "prefix/repo", "v1.0.0")
+	assert.Nil(t, err)
+	assert.Equal(t, "sha256:manifest", image.Digest)
+	assert.Equal(t, MediaTypeOCIManifest, image.MediaType)
+	assert.Equal(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), image.Created)
+	assert.Equal(t, "amd64", image.Architecture)
+	assert.Equal(t, "linux", image.OS)
+	assert.Equal(t, map[string]string{"maintainer": "someone"}, image.Labels)
+	assert.Len(t, image.Layers, 2)
+	assert.Equal(t, int64(3100), image.Size)
+
+	_, err = registry.Manifest(context.Background(), "prefix/repo", "list")
+	assert.NotNil(t, err)
+}
+
+func TestPlatformDigest(t *testing.T) {
+	registry := newTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+		case "/v2/prefix/repo/manifests/multiarch":
+			w.Header().Set("Content-Type", MediaTypeOCIIndex)
+			w.Header().Set("Docker-Content-Digest", "sha256:index")
+			w.Write([]byte(`{
+				"schemaVersion": 2,
+				"manifests": [
+					{"mediaType": "application/vnd.oci.image.manifest.v1+json", "size": 1, "digest": "sha256:amd64",
+					 "platform": {"architecture": "amd64", "os": "linux"}},
+					{"mediaType": "application/vnd.oci.image.manifest.v1+json", "size": 1, "digest": "sha256:arm64",
+					 "platform": {"architecture": "arm64", "os": "linux", "variant": "v8"}}
+				]
+			}`))
+		case "/v2/prefix/repo/manifests/single":
+			w.Header().Set("Content-Type", MediaTypeDockerManifest)
+			w.Header().Set("Docker-Content-Digest", "sha256:single")
+			w.Write([]byte(`{"schemaVersion": 2, "config": {}, "layers": []}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	index, err := registry.Index(context.Background(), "prefix/repo", "multiarch")
+	assert.Nil(t, err)
+	assert.Equal(t, "sha256:index", index.Digest)
+	assert.Len(t, index.Platforms(), 2)
+
+	cases := []struct {
+		reference string
+		platform  string
+		digest    string
+		isErr     bool
+	}{
+		{"multiarch", "linux/amd64", "sha256:amd64", false},
+		{"multiarch", "linux/arm64", "sha256:arm64", false},
+		{"multiarch", "linux/arm64/v8", "sha256:arm64", false},
+		{"multiarch", "linux/arm64/v7", "", true},
+		{"multiarch", "windows/amd64", "", true},
+		{"single", "linux/arm64", "sha256:single", false},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s %s", tc.reference, tc.platform), func(t *testing.T) {
+			platform, err := ParsePlatform(tc.platform)
+			assert.Nil(t, err)
+			digest, err := registry.PlatformDigest(context.Background(), "prefix/repo", tc.reference, platform)
+			assert.Equal(t, tc.isErr, err != nil)
+			assert.Equal(t, tc.digest, digest)
+		})
+	}
+
+	_, err = registry.Index(context.Background(), "prefix/repo", "single")
+	assert.NotNil(t, err)
+}
+
+func TestParsePlatform(t *testing.T) {
+	cases := []struct {
+		platform string
+		Expected Platform
+		isErr    bool
+	}{
+		{"linux/amd64", Platform{OS: "linux", Architecture: "amd64"}, false},
+		{"linux/arm64/v8", Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, false},
+		{"linux", Platform{}, true},
+		{"linux/", Platform{}, true},
+		{"linux/arm/v7/extra", Platform{}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.platform, func(t *testing.T) {
+			platform, err := ParsePlatform(tc.platform)
+			assert.Equal(t, tc.isErr, err != nil)
+			assert.Equal(t, tc.Expected, platform)
+		})
+	}
+}
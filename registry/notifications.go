@@ -0,0 +1,40 @@
+package registry
+
+// MediaTypeEvents is the content type of notification envelopes sent by registries
+const MediaTypeEvents = "application/vnd.docker.distribution.events.v1+json"
+
+// Envelope is the body of a registry notification, see
+// https://docs.docker.com/registry/notifications/
+type Envelope struct {
+	Events []Event `json:"events"`
+}
+
+type Event struct {
+	ID        string `json:"id"`
+	Timestamp string `json:"timestamp"`
+	// push, pull, mount or delete
+	Action string      `json:"action"`
+	Target EventTarget `json:"target"`
+}
+
+type EventTarget struct {
+	MediaType  string `json:"mediaType"`
+	Digest     string `json:"digest"`
+	Repository string `json:"repository"`
+	URL        string `json:"url"`
+	Tag        string `json:"tag"`
+}
+
+// IsManifestPush reports whether the event is a push of a manifest,
+// blob pushes are notified separately and are not of interest.
+func (event Event) IsManifestPush() bool {
+	if event.Action != "push" {
+		return false
+	}
+	for _, mediaType := range manifestMediaTypes {
+		if event.Target.MediaType == mediaType {
+			return true
+		}
+	}
+	return false
+}
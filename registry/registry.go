@@ -1,6 +1,7 @@
 package registry
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -147,7 +148,7 @@ func newFromTransport(registryUrl, scope, username, password string, transport h
 		Logf: logf,
 	}
 
-	if err := registry.Ping(); err != nil {
+	if err := registry.Ping(context.Background()); err != nil {
 		return nil, err
 	}
 
@@ -160,10 +161,14 @@ func (r *Registry) url(pathTemplate string, args ...interface{}) string {
 	return url
 }
 
-func (r *Registry) Ping() error {
+func (r *Registry) Ping(ctx context.Context) error {
 	url := r.url("/v2/")
 	r.Logf("registry.ping url=%s", url)
-	resp, err := r.Client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.Client.Do(req)
 	if resp != nil {
 		defer resp.Body.Close()
 	}
@@ -1,6 +1,7 @@
 package registry
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -32,7 +33,7 @@ type authToken struct {
 }
 
 func (t *TokenTransport) authAndRetry(authService *authService, req *http.Request) (*http.Response, error) {
-	token, authResp, err := t.auth(authService)
+	token, authResp, err := t.auth(req.Context(), authService)
 	if err != nil {
 		return authResp, err
 	}
@@ -41,8 +42,8 @@ func (t *TokenTransport) authAndRetry(authService *authService, req *http.Reques
 	return retryResp, err
 }
 
-func (t *TokenTransport) auth(authService *authService) (string, *http.Response, error) {
-	authReq, err := authService.Request(t.Username, t.Password)
+func (t *TokenTransport) auth(ctx context.Context, authService *authService) (string, *http.Response, error) {
+	authReq, err := authService.Request(ctx, t.Username, t.Password)
 	if err != nil {
 		return "", nil, err
 	}
@@ -83,7 +84,7 @@ type authService struct {
 	Scope   string
 }
 
-func (authService *authService) Request(username, password string) (*http.Request, error) {
+func (authService *authService) Request(ctx context.Context, username, password string) (*http.Request, error) {
 	url, err := url.Parse(authService.Realm)
 	if err != nil {
 		return nil, err
@@ -96,7 +97,7 @@ func (authService *authService) Request(username, password string) (*http.Reques
 	}
 	url.RawQuery = q.Encode()
 
-	request, err := http.NewRequest("GET", url.String(), nil)
+	request, err := http.NewRequestWithContext(ctx, "GET", url.String(), nil)
 
 	if username != "" || password != "" {
 		request.SetBasicAuth(username, password)
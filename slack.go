@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"time"
+
+	"github.com/dsaidgovsg/registrywatcher/client"
+	"github.com/dsaidgovsg/registrywatcher/log"
+	"github.com/dsaidgovsg/registrywatcher/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/nlopes/slack"
+)
+
+// Slack expects interactions to be acknowledged within 3 seconds, so
+// actions run in the background, and report back through the response_url
+const slackActionTimeout = time.Minute
+
+// The parts of a Slack block_actions payload that registrywatcher uses
+type slackInteraction struct {
+	Type string `json:"type"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	ResponseURL string `json:"response_url"`
+	Message     struct {
+		Text string `json:"text"`
+	} `json:"message"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+// Reads the body of a request from Slack, after checking that it is signed
+// with slack_signing_secret and recent
+func (h *Handler) readSlackRequest(c *gin.Context) ([]byte, error) {
+	secret := h.conf.GetString("slack_signing_secret")
+	if secret == "" {
+		return nil, fmt.Errorf("slack_signing_secret is not set")
+	}
+	verifier, err := slack.NewSecretsVerifier(c.Request.Header, secret)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = verifier.Write(body); err != nil {
+		return nil, err
+	}
+	if err = verifier.Ensure(); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Handles the buttons of Slack updates, see notifier.SlackNotifier
+func (h *Handler) SlackInteractionHandler(c *gin.Context) {
+	body, err := h.readSlackRequest(c)
+	if err != nil {
+		log.LogAppWarn("Rejected Slack interaction", err)
+		c.JSON(401, gin.H{
+			"message": "Error: invalid Slack signature",
+		})
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: %s", err),
+		})
+		return
+	}
+	var interaction slackInteraction
+	if err = json.Unmarshal([]byte(form.Get("payload")), &interaction); err != nil {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: %s", err),
+		})
+		return
+	}
+
+	if interaction.Type == "block_actions" {
+		user := interaction.User.Username
+		if user == "" {
+			user = interaction.User.ID
+		}
+		for _, action := range interaction.Actions {
+			var target utils.SlackActionTarget
+			if err = json.Unmarshal([]byte(action.Value), &target); err != nil {
+				log.LogAppWarn(fmt.Sprintf("Ignoring Slack action %s with invalid value", action.ActionID), err)
+				continue
+			}
+			actionID := action.ActionID
+			h.runInBackground(func() {
+				h.runSlackAction(actionID, target, user, interaction.ResponseURL, interaction.Message.Text)
+			})
+		}
+	}
+	c.Status(200)
+}
+
+// Runs action on target on behalf of user. On success the original message
+// is replaced by its text and the outcome, which drops its buttons.
+func (h *Handler) runSlackAction(action string, target utils.SlackActionTarget, user, responseURL, originalText string) {
+	ctx, cancel := context.WithTimeout(context.Background(), slackActionTimeout)
+	defer cancel()
+
+	log.LogAppInfo(fmt.Sprintf("Running Slack action %s on %s for %s", action, target.Repo, user))
+	outcome, err := h.performSlackAction(ctx, action, target, user)
+	if err != nil {
+		log.LogAppErr(fmt.Sprintf("Slack action %s on %s failed", action, target.Repo), err)
+		err = utils.RespondToSlack(ctx, responseURL, fmt.Sprintf("Error: %s", err), utils.SlackResponseEphemeral)
+	} else {
+		err = utils.ReplaceSlackMessage(ctx, responseURL, fmt.Sprintf("%s\n%s", originalText, outcome))
+	}
+	if err != nil {
+		log.LogAppErr("Couldn't respond to Slack interaction", err)
+	}
+}
+
+func (h *Handler) performSlackAction(ctx context.Context, action string, target utils.SlackActionTarget, user string) (string, error) {
+	repoName := target.Repo
+	if repoName == "" || !h.isWatchedOrGlobal(repoName) {
+		return "", fmt.Errorf("repo %s is not being watched", repoName)
+	}
+
+	switch action {
+	case utils.SlackActionDeploy:
+		if target.PendingID != 0 {
+			if _, err := h.clients.ApprovePendingDeployment(ctx, h.conf, target.PendingID, user); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s approved the deployment of tag `%s`.", user, target.Tag), nil
+		}
+		// the pinned tag or version constraint may have changed since the update was posted
+		tag, err := h.clients.GetFormattedPinnedTag(ctx, repoName)
+		if err != nil {
+			return "", err
+		}
+		if tag != target.Tag {
+			return "", fmt.Errorf("%s now resolves to tag %s instead of %s", repoName, tag, target.Tag)
+		}
+		h.clients.DeployPinnedTag(ctx, h.conf, repoName, client.DeployTriggerManual, user)
+		return fmt.Sprintf("%s deployed tag `%s`.", user, tag), nil
+
+	case utils.SlackActionSkip:
+		if target.PendingID != 0 {
+			if _, err := h.clients.RejectPendingDeployment(ctx, h.conf, target.PendingID, user); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s skipped tag `%s`.", user, target.Tag), nil
+		}
+		deferredTrigger, err := h.clients.PostgresClient.GetDeferredTrigger(ctx, repoName)
+		if err != nil {
+			return "", err
+		}
+		if deferredTrigger == "" {
+			return "", fmt.Errorf("%s has no deferred deployment", repoName)
+		}
+		if err = h.clients.PostgresClient.UpdateDeferredTrigger(ctx, repoName, ""); err != nil {
+			return "", err
+		}
+		log.LogAppInfo(fmt.Sprintf("Deferred deployment of %s dropped by %s", repoName, user))
+		return fmt.Sprintf("%s skipped tag `%s`.", user, target.Tag), nil
+
+	case utils.SlackActionRollback:
+		tag, err := h.clients.GetRollbackTag(ctx, repoName, 0)
+		if err != nil {
+			return "", fmt.Errorf("couldn't find a tag to roll back to, %s", err)
+		}
+		if err = h.clients.RollbackToTag(ctx, h.conf, repoName, tag, user, ""); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s rolled `%s` back to tag `%s`.", user, repoName, tag), nil
+
+	case utils.SlackActionPause:
+		h.setAutoDeploy(ctx, repoName, false)
+		return fmt.Sprintf("%s turned off auto deployment for repo `%s`.", user, repoName), nil
+	}
+	return "", fmt.Errorf("unknown action %s", action)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dsaidgovsg/registrywatcher/client"
+	"github.com/dsaidgovsg/registrywatcher/log"
+	"github.com/dsaidgovsg/registrywatcher/registry"
+	"github.com/dsaidgovsg/registrywatcher/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const slackCommandHistoryLength = 5
+
+const slackCommandUsage = "Usage:\n" +
+	"`status <repo>`: how the repo is deployed\n" +
+	"`deploy <repo> <tag>`: pin the repo to tag and deploy it\n" +
+	"`pause <repo>`: turn off auto deployment\n" +
+	"`reset <repo>`: unpin the repo, turn auto deployment back on and deploy the latest tag\n" +
+	"`history <repo>`: the last deployments of the repo"
+
+// Handles the Slack slash command, e.g. /registrywatcher status $REPO_NAME.
+// The command is acknowledged straight away, and its result posted to the channel.
+func (h *Handler) SlackCommandHandler(c *gin.Context) {
+	body, err := h.readSlackRequest(c)
+	if err != nil {
+		log.LogAppWarn("Rejected Slack command", err)
+		c.JSON(401, gin.H{
+			"message": "Error: invalid Slack signature",
+		})
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error: %s", err),
+		})
+		return
+	}
+
+	user := form.Get("user_name")
+	if user == "" {
+		user = form.Get("user_id")
+	}
+	h.runInBackground(func() {
+		h.runSlackCommand(strings.Fields(form.Get("text")), user, form.Get("response_url"))
+	})
+	// shows the command in the channel along with its result
+	c.JSON(200, gin.H{
+		"response_type": utils.SlackResponseInChannel,
+	})
+}
+
+func (h *Handler) runSlackCommand(args []string, user, responseURL string) {
+	ctx, cancel := context.WithTimeout(context.Background(), slackActionTimeout)
+	defer cancel()
+
+	log.LogAppInfo(fmt.Sprintf("Running Slack command %q for %s", strings.Join(args, " "), user))
+	reply, err := h.slackCommand(ctx, args, user)
+	if err != nil {
+		err = utils.RespondToSlack(ctx, responseURL, fmt.Sprintf("Error: %s", err), utils.SlackResponseEphemeral)
+	} else {
+		err = utils.RespondToSlack(ctx, responseURL, reply, utils.SlackResponseInChannel)
+	}
+	if err != nil {
+		log.LogAppErr("Couldn't respond to Slack command", err)
+	}
+}
+
+func (h *Handler) slackCommand(ctx context.Context, args []string, user string) (string, error) {
+	if len(args) == 0 || args[0] == "help" {
+		return slackCommandUsage, nil
+	}
+	command := args[0]
+	wantArgs := 2
+	if command == "deploy" {
+		wantArgs = 3
+	}
+	if len(args) != wantArgs {
+		return "", fmt.Errorf("wrong number of arguments for %s\n%s", command, slackCommandUsage)
+	}
+	repoName := args[1]
+	if !h.isWatchedOrGlobal(repoName) {
+		return "", fmt.Errorf("Repo %s is not being watched", repoName)
+	}
+
+	switch command {
+	case "status":
+		return h.slackStatus(ctx, repoName)
+
+	case "deploy":
+		tag := args[2]
+		if err := h.deployTag(ctx, repoName, tag, user); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s is deploying `%s` to tag `%s`.", user, repoName, tag), nil
+
+	case "pause":
+		h.setAutoDeploy(ctx, repoName, false)
+		return fmt.Sprintf("%s turned off auto deployment for repo `%s`.", user, repoName), nil
+
+	case "reset":
+		deploying, err := h.resetTag(ctx, repoName, user)
+		if err != nil {
+			return "", err
+		} else if !deploying {
+			return fmt.Sprintf("`%s` is not pinned to a tag.", repoName), nil
+		}
+		return fmt.Sprintf("%s unpinned `%s`, deploying its latest tag.", user, repoName), nil
+
+	case "history":
+		history, total, err := h.clients.PostgresClient.GetDeploymentHistory(ctx, repoName, slackCommandHistoryLength, 0)
+		if err != nil {
+			return "", err
+		}
+		return formatSlackHistory(repoName, history, total), nil
+	}
+	return "", fmt.Errorf("unknown command %s\n%s", command, slackCommandUsage)
+}
+
+func (h *Handler) slackStatus(ctx context.Context, repoName string) (string, error) {
+	tag, err := h.clients.PostgresClient.GetPinnedTag(ctx, repoName)
+	if err != nil {
+		return "", err
+	}
+	summary, err := h.repoSummary(ctx, repoName, tag)
+	if err != nil {
+		return "", err
+	}
+	pending, err := h.clients.PostgresClient.GetPendingDeployments(ctx, repoName)
+	if err != nil {
+		return "", err
+	}
+	return formatSlackStatus(repoName, summary, pending), nil
+}
+
+// Formats a repoSummary, along with the deployments waiting for approval
+func formatSlackStatus(repoName string, summary map[string]interface{}, pending []client.PendingDeploymentRow) string {
+	lines := []string{fmt.Sprintf("*%s* is on tag `%s`", repoName, summary["pinned_tag_value"])}
+	if image, ok := summary["pinned_image"].(*registry.Image); ok && image != nil {
+		lines = append(lines, fmt.Sprintf("Built %s for %s/%s, %d MB",
+			image.Created.UTC().Format(time.RFC3339), image.OS, image.Architecture, image.Size/1000000))
+	}
+	if pinnedTag := summary["pinned_tag"]; pinnedTag != "" {
+		lines = append(lines, fmt.Sprintf("Pinned to `%s`", pinnedTag))
+	} else if versionConstraint := summary["version_constraint"]; versionConstraint != "" {
+		lines = append(lines, fmt.Sprintf("Pinned to version constraint `%s`", versionConstraint))
+	}
+	if summary["auto_deploy"] == true {
+		lines = append(lines, "Auto deployment is on")
+	} else {
+		lines = append(lines, "Auto deployment is off")
+	}
+	if deferredTrigger := summary["deferred_trigger"]; deferredTrigger != "" {
+		lines = append(lines, fmt.Sprintf("A %s deployment is deferred until the deployment window opens", deferredTrigger))
+	}
+	for _, p := range pending {
+		lines = append(lines, fmt.Sprintf("Tag `%s` is waiting for approval until %s (id %d)",
+			p.Tag, p.ExpiresAt.UTC().Format(time.RFC3339), p.ID))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatSlackHistory(repoName string, history []client.DeploymentHistoryRow, total int) string {
+	if len(history) == 0 {
+		return fmt.Sprintf("`%s` has not been deployed yet.", repoName)
+	}
+	lines := []string{fmt.Sprintf("Last %d of %d deployments of *%s*:", len(history), total, repoName)}
+	for _, entry := range history {
+		line := fmt.Sprintf("%s `%s` → `%s` (%s", entry.StartedAt.UTC().Format(time.RFC3339), entry.FromTag, entry.ToTag, entry.Trigger)
+		if entry.Requester != "" {
+			line = fmt.Sprintf("%s by %s", line, entry.Requester)
+		}
+		line = fmt.Sprintf("%s): %s", line, entry.Outcome)
+		if entry.Description != "" {
+			line = fmt.Sprintf("%s, %s", line, entry.Description)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,123 @@
+//go:build unit
+// +build unit
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dsaidgovsg/registrywatcher/client"
+	"github.com/dsaidgovsg/registrywatcher/registry"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSigningSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+
+func signedSlackRequest(path, body, secret string) *http.Request {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("v0:%s:%s", timestamp, body)))
+
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestSlackCommandHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	conf := viper.New()
+	conf.Set("slack_signing_secret", testSigningSecret)
+	conf.Set("watched_repositories", []string{"api"})
+	handler := Handler{conf: conf}
+	r := gin.New()
+	r.POST("/slack/commands", handler.SlackCommandHandler)
+
+	replies := make(chan map[string]string, 1)
+	slackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reply map[string]string
+		json.NewDecoder(r.Body).Decode(&reply)
+		replies <- reply
+	}))
+	defer slackServer.Close()
+	form := url.Values{
+		"command":      {"/registrywatcher"},
+		"text":         {"status web"},
+		"user_name":    {"alice"},
+		"response_url": {slackServer.URL},
+	}.Encode()
+
+	// signed with another secret
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, signedSlackRequest("/slack/commands", form, "not the secret"))
+	assert.Equal(t, 401, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, signedSlackRequest("/slack/commands", form, testSigningSecret))
+	assert.Equal(t, 200, w.Code)
+	select {
+	case reply := <-replies:
+		assert.Equal(t, "ephemeral", reply["response_type"])
+		assert.Equal(t, "Error: Repo web is not being watched", reply["text"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply posted to the response_url")
+	}
+}
+
+func TestFormatSlackStatus(t *testing.T) {
+	summary := map[string]interface{}{
+		"pinned_tag":         "",
+		"pinned_tag_value":   "v1.2.0",
+		"version_constraint": "~1.2",
+		"auto_deploy":        true,
+		"deferred_trigger":   "",
+		"pinned_image": &registry.Image{
+			Created:      time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC),
+			OS:           "linux",
+			Architecture: "amd64",
+			Size:         52500000,
+		},
+	}
+	pending := []client.PendingDeploymentRow{{
+		ID:        3,
+		Tag:       "v1.2.1",
+		ExpiresAt: time.Date(2026, 10, 17, 9, 0, 0, 0, time.UTC),
+	}}
+	assert.Equal(t, "*api* is on tag `v1.2.0`\n"+
+		"Built 2026-10-01T08:00:00Z for linux/amd64, 52 MB\n"+
+		"Pinned to version constraint `~1.2`\n"+
+		"Auto deployment is on\n"+
+		"Tag `v1.2.1` is waiting for approval until 2026-10-17T09:00:00Z (id 3)",
+		formatSlackStatus("api", summary, pending))
+}
+
+func TestFormatSlackHistory(t *testing.T) {
+	assert.Equal(t, "`api` has not been deployed yet.", formatSlackHistory("api", nil, 0))
+
+	history := []client.DeploymentHistoryRow{{
+		FromTag:     "v1.1.0",
+		ToTag:       "v1.2.0",
+		Trigger:     "manual",
+		Requester:   "alice",
+		StartedAt:   time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC),
+		Outcome:     "failed",
+		Description: "allocation failed",
+	}}
+	assert.Equal(t, "Last 1 of 12 deployments of *api*:\n"+
+		"2026-10-16T10:00:00Z `v1.1.0` → `v1.2.0` (manual by alice): failed, allocation failed",
+		formatSlackHistory("api", history, 12))
+}
@@ -0,0 +1,106 @@
+//go:build unit
+// +build unit
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/dsaidgovsg/registrywatcher/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// Encodes a block_actions interaction of alice pressing a button for each of
+// actions, as Slack posts it to the interactivity request URL
+func slackInteractionForm(t *testing.T, responseURL string, actions map[string]string) string {
+	interaction := map[string]interface{}{
+		"type":         "block_actions",
+		"user":         map[string]string{"id": "U123", "username": "alice"},
+		"response_url": responseURL,
+		"message":      map[string]string{"text": "Update: deployment of tag `v1.2.0` in `web` is deferred"},
+	}
+	buttons := []map[string]string{}
+	for actionID, value := range actions {
+		buttons = append(buttons, map[string]string{"action_id": actionID, "value": value})
+	}
+	interaction["actions"] = buttons
+	payload, err := json.Marshal(interaction)
+	assert.Nil(t, err)
+	return url.Values{"payload": {string(payload)}}.Encode()
+}
+
+func TestSlackInteractionHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	conf := viper.New()
+	conf.Set("slack_signing_secret", testSigningSecret)
+	conf.Set("watched_repositories", []string{"api"})
+	handler := Handler{conf: conf}
+	r := gin.New()
+	r.POST("/slack/interactions", handler.SlackInteractionHandler)
+
+	replies := make(chan map[string]interface{}, 1)
+	slackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reply map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&reply)
+		replies <- reply
+	}))
+	defer slackServer.Close()
+	form := slackInteractionForm(t, slackServer.URL, map[string]string{
+		utils.SlackActionSkip: `{"repo": "web", "tag": "v1.2.0"}`,
+	})
+
+	// signed with another secret
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, signedSlackRequest("/slack/interactions", form, "not the secret"))
+	assert.Equal(t, 401, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, signedSlackRequest("/slack/interactions", "payload=%7Bnot+json", testSigningSecret))
+	assert.Equal(t, 400, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, signedSlackRequest("/slack/interactions", form, testSigningSecret))
+	assert.Equal(t, 200, w.Code)
+	// shutting down waits for the reply
+	handler.Shutdown(context.Background())
+	select {
+	case reply := <-replies:
+		assert.Equal(t, "ephemeral", reply["response_type"])
+		assert.Equal(t, "Error: repo web is not being watched", reply["text"])
+	default:
+		t.Fatal("no reply posted to the response_url before shutting down")
+	}
+
+	// buttons whose value isn't a target are ignored
+	form = slackInteractionForm(t, slackServer.URL, map[string]string{utils.SlackActionSkip: "web"})
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, signedSlackRequest("/slack/interactions", form, testSigningSecret))
+	assert.Equal(t, 200, w.Code)
+	handler.Shutdown(context.Background())
+	select {
+	case reply := <-replies:
+		t.Fatalf("unexpected reply %v", reply)
+	default:
+	}
+}
+
+func TestPerformSlackAction(t *testing.T) {
+	conf := viper.New()
+	conf.Set("watched_repositories", []string{"api"})
+	handler := Handler{conf: conf}
+	ctx := context.Background()
+
+	_, err := handler.performSlackAction(ctx, utils.SlackActionPause, utils.SlackActionTarget{}, "alice")
+	assert.EqualError(t, err, "repo  is not being watched")
+	_, err = handler.performSlackAction(ctx, utils.SlackActionRollback, utils.SlackActionTarget{Repo: "web"}, "alice")
+	assert.EqualError(t, err, "repo web is not being watched")
+	_, err = handler.performSlackAction(ctx, "redeploy", utils.SlackActionTarget{Repo: "api"}, "alice")
+	assert.EqualError(t, err, "unknown action redeploy")
+}
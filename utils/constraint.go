@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A range of semantic versions, e.g. "~1.4", "^2", ">=3.0 <4" or "1.x || ^2.1".
+// Comparators separated by spaces or commas must all match, and sets of
+// comparators separated by || are alternatives. Each alternative must stay
+// within one major version, so that a new major version is never matched.
+type VersionConstraint struct {
+	raw  string
+	sets [][]comparator
+}
+
+type comparator struct {
+	op      string
+	version Version
+}
+
+var operatorSpaceRe = regexp.MustCompile(`(>=|<=|>|<|=|~|\^)\s+`)
+var partialVersionRe = regexp.MustCompile(`^v?(\d+|[xX*])(?:\.(\d+|[xX*]))?(?:\.(\d+|[xX*])(-[0-9A-Za-z.-]+)?)?$`)
+
+func ParseVersionConstraint(constraint string) (*VersionConstraint, error) {
+	c := VersionConstraint{raw: strings.TrimSpace(constraint)}
+	if c.raw == "" {
+		return nil, fmt.Errorf("empty version constraint")
+	}
+	normalized := operatorSpaceRe.ReplaceAllString(c.raw, "$1")
+	for _, alternative := range strings.Split(normalized, "||") {
+		set := []comparator{}
+		for _, term := range strings.FieldsFunc(alternative, func(r rune) bool { return r == ' ' || r == ',' }) {
+			comparators, err := parseTerm(term)
+			if err != nil {
+				return nil, fmt.Errorf("invalid version constraint %q: %v", constraint, err)
+			}
+			set = append(set, comparators...)
+		}
+		if len(set) == 0 {
+			return nil, fmt.Errorf("invalid version constraint %q: empty range", constraint)
+		}
+		set, err := capMajor(set)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version constraint %q: %v", constraint, err)
+		}
+		c.sets = append(c.sets, set)
+	}
+	return &c, nil
+}
+
+func (c *VersionConstraint) String() string {
+	return c.raw
+}
+
+// Whether version is inside the range
+func (c *VersionConstraint) Check(version Version) bool {
+	for _, set := range c.sets {
+		matches := true
+		for _, comp := range set {
+			if !comp.check(version) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether tag is a semantic version inside the range
+func (c *VersionConstraint) CheckTag(tag string) bool {
+	version, err := ParseVersion(tag)
+	return err == nil && c.Check(version)
+}
+
+func (comp comparator) check(version Version) bool {
+	cmp := version.Compare(comp.version)
+	switch comp.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return cmp == 0
+}
+
+// Expands a single term, e.g. ~1.4 or >=3.0, into comparators on full versions
+func parseTerm(term string) ([]comparator, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "~", "^"} {
+		if strings.HasPrefix(term, prefix) {
+			op = prefix
+			break
+		}
+	}
+	version, parts, err := parsePartialVersion(strings.TrimPrefix(term, op))
+	if err != nil {
+		return nil, err
+	}
+
+	// x-ranges such as 1.x are treated as a bare partial version
+	if parts == 0 {
+		if op == "" || op == "=" || op == ">=" || op == "<=" || op == "~" || op == "^" {
+			return []comparator{{">=", Version{}}}, nil
+		}
+		return nil, fmt.Errorf("%s matches no version", term)
+	}
+
+	switch op {
+	case "", "=":
+		if parts == 3 {
+			return []comparator{{"=", version}}, nil
+		}
+		return []comparator{{">=", version}, {"<", bump(version, parts)}}, nil
+	case ">=":
+		return []comparator{{op, version}}, nil
+	case "<":
+		// <4 excludes the pre-releases of 4.0.0 as well
+		if parts < 3 {
+			version.PreRelease = []string{"0"}
+		}
+		return []comparator{{op, version}}, nil
+	case ">":
+		// >1.4 means any version after the 1.4 series
+		if parts == 3 {
+			return []comparator{{">", version}}, nil
+		}
+		return []comparator{{">=", bump(version, parts)}}, nil
+	case "<=":
+		if parts == 3 {
+			return []comparator{{"<=", version}}, nil
+		}
+		return []comparator{{"<", bump(version, parts)}}, nil
+	case "~":
+		// ~1 allows minor updates, ~1.4 and ~1.4.2 allow patch updates
+		if parts == 1 {
+			return []comparator{{">=", version}, {"<", bump(version, 1)}}, nil
+		}
+		return []comparator{{">=", version}, {"<", bump(version, 2)}}, nil
+	}
+
+	// ^ allows updates that don't change the leftmost non-zero number
+	switch {
+	case version.Major > 0 || parts == 1:
+		return []comparator{{">=", version}, {"<", bump(version, 1)}}, nil
+	case version.Minor > 0 || parts == 2:
+		return []comparator{{">=", version}, {"<", bump(version, 2)}}, nil
+	}
+	return []comparator{{">=", version}, {"<", bump(version, 3)}}, nil
+}
+
+/*
+ * Checks that set has an upper bound within the major version of its lower
+ * bound, and adds a comparator excluding the pre-releases of the next major
+ * version, which e.g. <4.0.0 lets through.
+ */
+func capMajor(set []comparator) ([]comparator, error) {
+	lower := Version{}
+	var upper *comparator
+	for i, comp := range set {
+		if (comp.op == ">=" || comp.op == ">" || comp.op == "=") && comp.version.Compare(lower) > 0 {
+			lower = comp.version
+		}
+		if (comp.op == "<" || comp.op == "<=" || comp.op == "=") && (upper == nil || comp.version.Compare(upper.version) < 0) {
+			upper = &set[i]
+		}
+	}
+	nextMajor := Version{Major: lower.Major + 1}
+	if upper == nil {
+		return nil, fmt.Errorf("no upper bound, the range must stay within major version %d", lower.Major)
+	}
+	if cmp := upper.version.Compare(nextMajor); cmp > 0 || (cmp == 0 && upper.op != "<") {
+		return nil, fmt.Errorf("the range must stay within major version %d", lower.Major)
+	}
+	return append(set, comparator{"<", Version{Major: lower.Major + 1, PreRelease: []string{"0"}}}), nil
+}
+
+// Parses a version whose trailing numbers may be missing or wildcards,
+// returning it with the missing numbers set to 0 and how many numbers were given
+func parsePartialVersion(s string) (Version, int, error) {
+	match := partialVersionRe.FindStringSubmatch(s)
+	if match == nil {
+		return Version{}, 0, fmt.Errorf("%q is not a version", s)
+	}
+	if match[4] != "" {
+		version, err := ParseVersion(s)
+		return version, 3, err
+	}
+	numbers := []uint64{}
+	for _, part := range match[1:4] {
+		if part == "" || part == "x" || part == "X" || part == "*" {
+			break
+		}
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return Version{}, 0, fmt.Errorf("invalid version number %q: %v", part, err)
+		}
+		numbers = append(numbers, n)
+	}
+	version := Version{}
+	for i, n := range numbers {
+		switch i {
+		case 0:
+			version.Major = n
+		case 1:
+			version.Minor = n
+		case 2:
+			version.Patch = n
+		}
+	}
+	return version, len(numbers), nil
+}
+
+// Returns the lowest version after every version sharing the first parts
+// numbers of version, including the pre-releases of the next version, so
+// that e.g. ^1 does not match 2.0.0-rc.1
+func bump(version Version, parts int) Version {
+	next := Version{PreRelease: []string{"0"}}
+	switch parts {
+	case 1:
+		next.Major = version.Major + 1
+	case 2:
+		next.Major, next.Minor = version.Major, version.Minor+1
+	default:
+		next.Major, next.Minor, next.Patch = version.Major, version.Minor, version.Patch+1
+	}
+	return next
+}
@@ -0,0 +1,80 @@
+//go:build unit
+// +build unit
+
+package utils
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionConstraint(t *testing.T) {
+	cases := []struct {
+		constraint string
+		tag        string
+		Expected   bool
+	}{
+		{"~1.4", "v1.4.0", true},
+		{"~1.4", "v1.4.9", true},
+		{"~1.4", "v1.5.0", false},
+		{"~1.4.2", "v1.4.1", false},
+		{"~1", "v1.9.0", true},
+		{"~1", "v2.0.0", false},
+		{"^2", "v2.9.9", true},
+		{"^2", "v3.0.0", false},
+		{"^2", "v3.0.0-rc.1", false},
+		{"^2", "v1.9.9", false},
+		{"^1.4.2", "v1.9.0", true},
+		{"^0.4.2", "v0.4.9", true},
+		{"^0.4.2", "v0.5.0", false},
+		{"^0.0.3", "v0.0.4", false},
+		{">=3.0 <4", "v3.7.1", true},
+		{">=3.0 <4", "v4.0.0", false},
+		{">=3.0 <4", "v4.0.0-rc.1", false},
+		{">=3.0, <4", "v2.9.0", false},
+		{">= 3.0 < 4", "v3.0.0", true},
+		{">=3.0 <4.0.0", "v4.0.0-rc.1", false},
+		{">1.4 <2", "v1.4.9", false},
+		{">1.4 <2", "v1.5.0", true},
+		{">=1.0 <=1.4", "v1.4.9", true},
+		{">=1.0 <=1.4", "v1.5.0", false},
+		{"1.4", "v1.4.3", true},
+		{"1.x", "v1.9.0", true},
+		{"1.x", "v2.0.0", false},
+		{"1.4.2", "1.4.2", true},
+		{"=1.4.2", "v1.4.3", false},
+		{"^1 || ^3", "v3.1.0", true},
+		{"^1 || ^3", "v2.1.0", false},
+		{"0.x", "v0.9.9", true},
+		{"^2", "latest", false},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s %s", tc.constraint, tc.tag), func(t *testing.T) {
+			constraint, err := ParseVersionConstraint(tc.constraint)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.Expected, constraint.CheckTag(tc.tag))
+		})
+	}
+}
+
+func TestParseVersionConstraintErrors(t *testing.T) {
+	for _, constraint := range []string{"", "~", "latest", ">=1.0 ||", "^1.0.0.0", ">*",
+		// would match new major versions
+		"*", ">=3.0", ">1", "<=1.4", ">=3.0 <=4", ">=3.0 <5", "^1 || >=3"} {
+		_, err := ParseVersionConstraint(constraint)
+		assert.NotNil(t, err, constraint)
+	}
+}
+
+func TestGetLatestReleaseTagWithConstraint(t *testing.T) {
+	tags := []string{"v1.4.0", "v1.4.7", "v1.5.0", "v2.0.0", "v2.3.1", "v3.0.0-rc.1"}
+	constraint, _ := ParseVersionConstraint("~1.4")
+	tag, _ := GetLatestReleaseTag(tags, (&TagPolicy{}).WithConstraint(constraint))
+	assert.Equal(t, "v1.4.7", tag)
+
+	constraint, _ = ParseVersionConstraint("^2")
+	tag, _ = GetLatestReleaseTag(tags, (&TagPolicy{IncludePreReleases: true}).WithConstraint(constraint))
+	assert.Equal(t, "v2.3.1", tag)
+}
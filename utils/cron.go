@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A cron expression, "minute hour day-of-month month day-of-week", matching
+// the minutes it would fire at. Fields accept *, numbers, ranges (1-5),
+// steps (*/15 or 9-17/2) and lists (1,15), and month and day of week names.
+// As in cron, if both day fields are restricted a day matches either of them.
+type CronSchedule struct {
+	raw    string
+	fields [5]uint64
+	// whether the day fields are *, which changes how they combine
+	domStar bool
+	dowStar bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var cronFields = [5]cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12,
+		names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	// 7 is also Sunday
+	{name: "day of week", min: 0, max: 7,
+		names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+func ParseCronSchedule(expr string) (*CronSchedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+	schedule := CronSchedule{raw: strings.Join(parts, " ")}
+	for i, part := range parts {
+		bits, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", expr, err)
+		}
+		schedule.fields[i] = bits
+	}
+	// fold Sunday as 7 into 0
+	if schedule.fields[4]&(1<<7) != 0 {
+		schedule.fields[4] |= 1
+	}
+	schedule.domStar = parts[2] == "*"
+	schedule.dowStar = parts[4] == "*"
+	return &schedule, nil
+}
+
+func (c *CronSchedule) String() string {
+	return c.raw
+}
+
+// Whether the minute t falls in matches the schedule, in t's location
+func (c *CronSchedule) Matches(t time.Time) bool {
+	if c.fields[0]&(1<<uint(t.Minute())) == 0 ||
+		c.fields[1]&(1<<uint(t.Hour())) == 0 ||
+		c.fields[3]&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	domMatch := c.fields[2]&(1<<uint(t.Day())) != 0
+	dowMatch := c.fields[4]&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Returns a bitset of the values field matches
+func parseCronField(field string, spec cronField) (uint64, error) {
+	var bits uint64
+	for _, term := range strings.Split(field, ",") {
+		rangePart, step := term, 1
+		if i := strings.Index(term, "/"); i >= 0 {
+			var err error
+			rangePart = term[:i]
+			if step, err = strconv.Atoi(term[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %s field %q", spec.name, term)
+			}
+		}
+
+		lo, hi := spec.min, spec.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = parseCronValue(bounds[0], spec); err != nil {
+				return 0, err
+			}
+			if hi, err = parseCronValue(bounds[1], spec); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range in %s field %q", spec.name, term)
+			}
+		default:
+			var err error
+			if lo, err = parseCronValue(rangePart, spec); err != nil {
+				return 0, err
+			}
+			// a single value with a step, e.g. 5/15, runs to the end of the range
+			hi = lo
+			if step > 1 {
+				hi = spec.max
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(value string, spec cronField) (int, error) {
+	for i, name := range spec.names {
+		if strings.EqualFold(value, name) {
+			// month names start at 1, day names at 0
+			return i + spec.min, nil
+		}
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < spec.min || v > spec.max {
+		return 0, fmt.Errorf("%s must be between %d and %d, got %q", spec.name, spec.min, spec.max, value)
+	}
+	return v, nil
+}
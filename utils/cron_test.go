@@ -0,0 +1,54 @@
+//go:build unit
+// +build unit
+
+package utils
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCronScheduleMatches(t *testing.T) {
+	// 2026-10-16 is a Friday
+	friday := func(hour, minute int) time.Time {
+		return time.Date(2026, 10, 16, hour, minute, 0, 0, time.UTC)
+	}
+	cases := []struct {
+		expr     string
+		t        time.Time
+		Expected bool
+	}{
+		{"* 9-16 * * 1-5", friday(9, 0), true},
+		{"* 9-16 * * 1-5", friday(16, 59), true},
+		{"* 9-16 * * 1-5", friday(17, 0), false},
+		{"* 9-16 * * 1-5", friday(8, 59), false},
+		{"* 9-16 * * 1-5", friday(12, 0).AddDate(0, 0, 1), false},
+		{"* 9-16 * * mon-FRI", friday(12, 0), true},
+		{"*/15 * * * *", friday(12, 30), true},
+		{"*/15 * * * *", friday(12, 31), false},
+		{"0,30 22 * * *", friday(22, 30), true},
+		{"* * * * 0", friday(12, 0).AddDate(0, 0, 2), true},
+		{"* * * * 7", friday(12, 0).AddDate(0, 0, 2), true},
+		{"* * 1 jan *", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		// both day fields restricted, either matches
+		{"* * 1 * 5", friday(12, 0), true},
+		{"* * 1 * 4", friday(12, 0), false},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s at %s", tc.expr, tc.t.Format(time.RFC1123)), func(t *testing.T) {
+			schedule, err := ParseCronSchedule(tc.expr)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.Expected, schedule.Matches(tc.t))
+		})
+	}
+}
+
+func TestParseCronScheduleErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 9-17/0 * * *", "* 17-9 * * *", "* * * * funday"} {
+		_, err := ParseCronSchedule(expr)
+		assert.NotNil(t, err, expr)
+	}
+}
@@ -2,52 +2,51 @@ package utils
 
 import (
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
-	"os"
 	"regexp"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/dsaidgovsg/registrywatcher/log"
-	"github.com/nlopes/slack"
 	"github.com/spf13/viper"
 )
 
 // update README link if moving this definition
-var re = regexp.MustCompile(`^v([0-9]{1,3})\.([0-9]{1,3})\.([0-9]{1,3})$`)
 var findSHA = regexp.MustCompile(`[A-Fa-f0-9]{40}`)
 
 func IsTagSHAFormat(tag string) bool {
 	return findSHA.MatchString(tag)
 }
 
+// release tags are semantic versions, with or without a leading v
 func IsTagReleaseFormat(tag string) bool {
-	return re.MatchString(tag)
+	_, err := ParseVersion(tag)
+	return err == nil
 }
 
-func FilterReleaseTags(tags []string) []string {
+// Returns the tags that policy considers releases
+func FilterReleaseTags(tags []string, policy *TagPolicy) []string {
 	rtn := []string{}
 	for _, tag := range tags {
-		if IsTagReleaseFormat(tag) {
+		if policy.IsReleaseTag(tag) {
 			rtn = append(rtn, tag)
 		}
 	}
 	return rtn
 }
 
-func FilterSHATags(tags []string) []string {
+// Leaves out commit SHA tags, unless the policy's include regex explicitly matches them
+func FilterSHATags(tags []string, policy *TagPolicy) []string {
 	rtn := []string{}
 	for _, tag := range tags {
-		if !IsTagSHAFormat(tag) {
+		if !IsTagSHAFormat(tag) || (policy.Include != nil && policy.Include.MatchString(tag)) {
 			rtn = append(rtn, tag)
 		}
 	}
 	return rtn
 }
 
+// Deprecated: only supports vMAJOR.MINOR.PATCH below 1000, use CompareVersionTags instead
 func TagToNumber(tag string) int {
 	arr := strings.Split(tag, ".")
 	major, _ := strconv.Atoi(string(arr[0][1:]))
@@ -59,18 +58,15 @@ func TagToNumber(tag string) int {
 }
 
 // latest with respect to versioned tags
-func IsTagLatest(tag string, tags []string) bool {
+func IsTagLatest(tag string, tags []string, policy *TagPolicy) bool {
 	if tag == "" {
 		return true
 	}
-	// tags to numbers
-	values := []int{}
-	for _, tag := range tags {
-		values = append(values, TagToNumber(tag))
+	latestTag, err := GetLatestReleaseTag(tags, policy)
+	if err != nil {
+		return false
 	}
-	sort.Ints(values)
-	rtn := TagToNumber(tag) == values[len(values)-1]
-	return rtn
+	return policy.Compare(tag, latestTag) >= 0
 }
 
 func IsTagDeployable(checkedTag string, availableDockerTags []string) bool {
@@ -85,20 +81,21 @@ func IsTagDeployable(checkedTag string, availableDockerTags []string) bool {
 	return false
 }
 
-func GetLatestReleaseTag(tags []string) (string, error) {
-	tags = FilterReleaseTags(tags)
-	tagValueMap := map[int]string{}
-	values := []int{}
-	for _, tag := range tags {
-		tagValueMap[TagToNumber(tag)] = tag
-		values = append(values, TagToNumber(tag))
-	}
-	sort.Ints(values)
-	if len(values) == 0 {
+// Returns the newest release tag according to policy. Tags that are as recent as
+// each other, e.g. v1.0.0 and 1.0.0+build.1, are ordered by name so the result is stable.
+func GetLatestReleaseTag(tags []string, policy *TagPolicy) (string, error) {
+	tags = FilterReleaseTags(tags, policy)
+	if len(tags) == 0 {
 		return "", fmt.Errorf("No valid tag")
 	}
-	biggestValue := values[len(values)-1]
-	return tagValueMap[biggestValue], nil
+	latestTag := tags[0]
+	for _, tag := range tags[1:] {
+		c := policy.Compare(tag, latestTag)
+		if c > 0 || (c == 0 && tag > latestTag) {
+			latestTag = tag
+		}
+	}
+	return latestTag, nil
 }
 
 func CastMapOfMaps(mapOfMap interface{}) map[string]map[string]string {
@@ -113,11 +110,9 @@ func CastMapOfMaps(mapOfMap interface{}) map[string]map[string]string {
 }
 
 func extractRegistryInfo(conf *viper.Viper, repoName, keyName string) string {
-	repoMap := CastMapOfMaps(conf.Get("repo_map"))
-	registryMap := CastMapOfMaps(conf.Get("registry_map"))
-	registryName := repoMap[repoName]["registry_name"]
-	repoRegistryMap := registryMap[registryName]
-	return repoRegistryMap[keyName]
+	// read key by key, repo_map also holds settings that aren't strings
+	registryName := GetRepoSetting(conf, repoName, "registry_name")
+	return conf.GetString(fmt.Sprintf("registry_map.%s.%s", registryName, keyName))
 }
 
 // return the scheme, domain and prefix in that order
@@ -153,66 +148,109 @@ func ConstructImageName(domain, prefix, repoName, tag string) string {
 	)
 }
 
+// Same as ConstructImageName, but references the image by its manifest digest
+func ConstructImageDigestName(domain, prefix, repoName, digest string) string {
+	return fmt.Sprintf("%s/%s/%s@%s",
+		domain,
+		prefix,
+		repoName,
+		digest,
+	)
+}
+
+// Returns the digest of a full image name such as name@sha256:..., empty if it has none
+func ImageDigest(image string) string {
+	if i := strings.Index(image, "@"); i != -1 {
+		return image[i+1:]
+	}
+	return ""
+}
+
+// Splits a full image name into the image and tag, ignoring any digest.
+// Registry hosts with a port are handled, the tag is empty if not present.
+func SplitImageName(image string) (string, string) {
+	if i := strings.Index(image, "@"); i != -1 {
+		image = image[:i]
+	}
+	i := strings.LastIndex(image, ":")
+	if i == -1 || strings.Contains(image[i:], "/") {
+		return image, ""
+	}
+	return image[:i], image[i+1:]
+}
+
+// Whether repoName is deployed by its immutable manifest digest rather than its tag, false by default
+func GetRepoDeployByDigest(conf *viper.Viper, repoName string) bool {
+	return conf.GetBool(fmt.Sprintf("repo_map.%s.deploy_by_digest", repoName))
+}
+
 // Get the Nomad job name config mapping for repoName
-func GetRepoNomadJob(conf *viper.Viper, repoName string) string {
-	repoMap := conf.Get("repo_map").(map[string]interface{})
-	jobID := repoMap[repoName].(map[string]interface{})["nomad_job_name"].(string)
-	return jobID
+func GetRepoNomadJob(conf *viper.Viper, repoName string) (string, error) {
+	return getRequiredRepoSetting(conf, repoName, "nomad_job_name")
 }
 
 // Get the Nomad task name config mapping for repoName
-func GetRepoNomadTaskName(conf *viper.Viper, repoName string) string {
-	repoMap := conf.Get("repo_map").(map[string]interface{})
-	jobID := repoMap[repoName].(map[string]interface{})["nomad_task_name"].(string)
-	return jobID
-}
-
-const (
-	green  = "#00FF00"
-	red    = "#FF0000"
-	orange = "#FFA500"
-	yarly  = "https://i.imgur.com/LWRp6ZT.png"
-	nowai  = "https://i.imgur.com/MJ5Qx8f.jpg"
-)
+func GetRepoNomadTaskName(conf *viper.Viper, repoName string) (string, error) {
+	return getRequiredRepoSetting(conf, repoName, "nomad_task_name")
+}
 
-func PostSlackUpdate(conf *viper.Viper, text string) {
-	attachment := slack.Attachment{
-		Color: orange,
-		Text:  text,
-		Ts:    json.Number(strconv.FormatInt(time.Now().Unix(), 10)),
+func getRequiredRepoSetting(conf *viper.Viper, repoName, key string) (string, error) {
+	value := GetRepoSetting(conf, repoName, key)
+	if value == "" {
+		return "", fmt.Errorf("%s is not set for repo %s", key, repoName)
 	}
-	postSlackMessage(conf, attachment)
+	return value, nil
 }
 
-func PostSlackError(conf *viper.Viper, text string) {
-	attachment := slack.Attachment{
-		Color:    red,
-		Text:     text,
-		Ts:       json.Number(strconv.FormatInt(time.Now().Unix(), 10)),
-		ThumbURL: nowai,
+// Get the deployer backend for repoName, defaults to nomad
+func GetRepoDeployer(conf *viper.Viper, repoName string) string {
+	deployer := GetRepoSetting(conf, repoName, "deployer")
+	if deployer == "" {
+		return "nomad"
 	}
-	postSlackMessage(conf, attachment)
+	return deployer
 }
 
-func PostSlackSuccess(conf *viper.Viper, text string) {
-	attachment := slack.Attachment{
-		Color:    green,
-		Text:     text,
-		Ts:       json.Number(strconv.FormatInt(time.Now().Unix(), 10)),
-		ThumbURL: yarly,
-	}
-	postSlackMessage(conf, attachment)
+// Whether repoName should be rolled back to its last successful
+// deployment when a deployment fails or times out, false by default
+func GetRepoAutoRollback(conf *viper.Viper, repoName string) bool {
+	return conf.GetBool(fmt.Sprintf("repo_map.%s.auto_rollback", repoName))
 }
 
-func postSlackMessage(conf *viper.Viper, attachment slack.Attachment) {
-	if _, ok := os.LookupEnv("DEBUG"); !ok {
-		msg := slack.WebhookMessage{
-			Attachments: []slack.Attachment{attachment},
-		}
+// Get the platform, in os/arch[/variant] format, to track for repoName.
+// Empty if the repository is not published as a multi-arch image.
+func GetRepoPlatform(conf *viper.Viper, repoName string) string {
+	return GetRepoSetting(conf, repoName, "platform")
+}
 
-		err := slack.PostWebhook(conf.GetString("webhook_url"), &msg)
-		if err != nil {
-			log.LogAppErr(fmt.Sprintf("Cannot post to slack webhook_url %s", conf.GetString("webhook_url")), err)
-		}
+// Whether auto deployments of repoName wait for a manual approval, false by default
+func GetRepoRequireApproval(conf *viper.Viper, repoName string) bool {
+	return conf.GetBool(fmt.Sprintf("repo_map.%s.require_approval", repoName))
+}
+
+const defaultApprovalTimeout = 24 * time.Hour
+
+// Get how long a deployment of repoName waits for approval before it expires.
+// approval_timeout in repo_map overrides the global approval_timeout.
+func GetRepoApprovalTimeout(conf *viper.Viper, repoName string) (time.Duration, error) {
+	timeout := GetRepoSetting(conf, repoName, "approval_timeout")
+	if timeout == "" {
+		timeout = conf.GetString("approval_timeout")
 	}
+	if timeout == "" {
+		return defaultApprovalTimeout, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid approval_timeout for repo %s: %v", repoName, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("approval_timeout for repo %s must be positive", repoName)
+	}
+	return d, nil
+}
+
+// Get an optional repo_map setting for repoName, empty if not set
+func GetRepoSetting(conf *viper.Viper, repoName, key string) string {
+	return conf.GetString(fmt.Sprintf("repo_map.%s.%s", repoName, key))
 }
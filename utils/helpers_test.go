@@ -5,8 +5,10 @@ package utils
 
 import (
 	"fmt"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestTagToNumber(t *testing.T) {
@@ -61,8 +63,18 @@ func TestIsTagReleaseFormat(t *testing.T) {
 		{"v0.0.11", true},
 		{"v0.0.111", true},
 		{"v0.0.11a", false},
-		{"v0.0.1111", false},
+		{"v0.0.1111", true},
 		{"v999.999.999", true},
+		{"1.4.0", true},
+		{"v2.0.0-rc.1", true},
+		{"v3.1.0+build.7", true},
+		{"v1.0.0-rc.1+build.7", true},
+		{"v01.0.0", false},
+		{"v1.0", false},
+		{"v1.0.0-", false},
+		{"v1.0.0-rc.01", false},
+		{"v1.0.0+build..7", false},
+		{"latest", false},
 	}
 	for _, tc := range cases {
 		t.Run(fmt.Sprintf("%s, %t", tc.tag, tc.Expected), func(t *testing.T) {
@@ -70,3 +82,119 @@ func TestIsTagReleaseFormat(t *testing.T) {
 		})
 	}
 }
+
+func TestCompareVersionTags(t *testing.T) {
+	// in increasing order of precedence, from the SemVer 2.0 spec
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"v1.0.0",
+		"v1.0.1",
+		"v1.999.0",
+		"v1.1000.0",
+		"v2.0.0-rc.1",
+		"v2.0.0",
+	}
+	for i := 0; i < len(ordered)-1; i++ {
+		t.Run(fmt.Sprintf("%s < %s", ordered[i], ordered[i+1]), func(t *testing.T) {
+			assert.Equal(t, -1, CompareVersionTags(ordered[i], ordered[i+1]))
+			assert.Equal(t, 1, CompareVersionTags(ordered[i+1], ordered[i]))
+		})
+	}
+	assert.Equal(t, 0, CompareVersionTags("v3.1.0+build.7", "3.1.0"))
+	assert.Equal(t, 1, CompareVersionTags("v0.0.1", "latest"))
+}
+
+func TestGetLatestReleaseTag(t *testing.T) {
+	cases := []struct {
+		tags               []string
+		includePreReleases bool
+		Expected           string
+		isErr              bool
+	}{
+		{[]string{"v0.9.0", "1.4.0", "v1.10.0", "latest"}, false, "v1.10.0", false},
+		{[]string{"v1.0.0", "v2.0.0-rc.1"}, false, "v1.0.0", false},
+		{[]string{"v1.0.0", "v2.0.0-rc.1"}, true, "v2.0.0-rc.1", false},
+		{[]string{"v2.0.0-rc.1", "v2.0.0-rc.2", "v2.0.0"}, true, "v2.0.0", false},
+		{[]string{"v3.1.0+build.7", "v3.0.0"}, false, "v3.1.0+build.7", false},
+		{[]string{"v2.0.0-rc.1", "latest"}, false, "", true},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s, %t", tc.tags, tc.includePreReleases), func(t *testing.T) {
+			tag, err := GetLatestReleaseTag(tc.tags, &TagPolicy{IncludePreReleases: tc.includePreReleases})
+			assert.Equal(t, tc.isErr, err != nil)
+			assert.Equal(t, tc.Expected, tag)
+		})
+	}
+}
+
+func TestSplitImageName(t *testing.T) {
+	cases := []struct {
+		image string
+		name  string
+		tag   string
+	}{
+		{"registry.example.com/prefix/repo:v1.0.0", "registry.example.com/prefix/repo", "v1.0.0"},
+		{"localhost:5000/prefix/repo:v1.0.0", "localhost:5000/prefix/repo", "v1.0.0"},
+		{"localhost:5000/prefix/repo", "localhost:5000/prefix/repo", ""},
+		{"repo", "repo", ""},
+		{"repo:latest@sha256:abc", "repo", "latest"},
+		{"repo@sha256:abc", "repo", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.image, func(t *testing.T) {
+			name, tag := SplitImageName(tc.image)
+			assert.Equal(t, tc.name, name)
+			assert.Equal(t, tc.tag, tag)
+		})
+	}
+}
+
+func TestGetRepoApprovalTimeout(t *testing.T) {
+	conf := viper.New()
+	conf.Set("repo_map.api.require_approval", true)
+
+	timeout, err := GetRepoApprovalTimeout(conf, "api")
+	assert.Nil(t, err)
+	assert.Equal(t, 24*time.Hour, timeout)
+
+	conf.Set("approval_timeout", "2h")
+	timeout, err = GetRepoApprovalTimeout(conf, "api")
+	assert.Nil(t, err)
+	assert.Equal(t, 2*time.Hour, timeout)
+
+	conf.Set("repo_map.api.approval_timeout", "30m")
+	timeout, err = GetRepoApprovalTimeout(conf, "api")
+	assert.Nil(t, err)
+	assert.Equal(t, 30*time.Minute, timeout)
+
+	conf.Set("repo_map.api.approval_timeout", "-1h")
+	_, err = GetRepoApprovalTimeout(conf, "api")
+	assert.NotNil(t, err)
+	conf.Set("repo_map.api.approval_timeout", "soon")
+	_, err = GetRepoApprovalTimeout(conf, "api")
+	assert.NotNil(t, err)
+}
+
+func TestGetRepoNomadJob(t *testing.T) {
+	conf := viper.New()
+	conf.Set("repo_map.api.deployer", "webhook")
+	_, err := GetRepoNomadJob(conf, "api")
+	assert.EqualError(t, err, "nomad_job_name is not set for repo api")
+	_, err = GetRepoNomadTaskName(conf, "web")
+	assert.EqualError(t, err, "nomad_task_name is not set for repo web")
+
+	conf.Set("repo_map.api.nomad_job_name", "api-job")
+	conf.Set("repo_map.api.nomad_task_name", "api-task")
+	jobID, err := GetRepoNomadJob(conf, "api")
+	assert.Nil(t, err)
+	assert.Equal(t, "api-job", jobID)
+	taskName, err := GetRepoNomadTaskName(conf, "api")
+	assert.Nil(t, err)
+	assert.Equal(t, "api-task", taskName)
+}
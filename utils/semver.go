@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A semantic version as defined by https://semver.org/spec/v2.0.0.html
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	PreRelease []string
+	Build      []string
+}
+
+// Parses a tag such as 1.4.0, v2.0.0-rc.1 or v3.1.0+build.7,
+// the leading v is optional
+func ParseVersion(tag string) (Version, error) {
+	version := Version{}
+	rest := strings.TrimPrefix(tag, "v")
+
+	if i := strings.Index(rest, "+"); i >= 0 {
+		build, err := splitIdentifiers(rest[i+1:], false)
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid build metadata in %s: %v", tag, err)
+		}
+		version.Build = build
+		rest = rest[:i]
+	}
+	if i := strings.Index(rest, "-"); i >= 0 {
+		preRelease, err := splitIdentifiers(rest[i+1:], true)
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid pre-release in %s: %v", tag, err)
+		}
+		version.PreRelease = preRelease
+		rest = rest[:i]
+	}
+
+	core := strings.Split(rest, ".")
+	if len(core) != 3 {
+		return Version{}, fmt.Errorf("%s is not in MAJOR.MINOR.PATCH format", tag)
+	}
+	numbers := make([]uint64, 3)
+	for i, part := range core {
+		if !isNumericIdentifier(part) {
+			return Version{}, fmt.Errorf("invalid version number %q in %s", part, tag)
+		}
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid version number %q in %s: %v", part, tag, err)
+		}
+		numbers[i] = n
+	}
+	version.Major, version.Minor, version.Patch = numbers[0], numbers[1], numbers[2]
+	return version, nil
+}
+
+func (v Version) IsPreRelease() bool {
+	return len(v.PreRelease) > 0
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.IsPreRelease() {
+		s += "-" + strings.Join(v.PreRelease, ".")
+	}
+	if len(v.Build) > 0 {
+		s += "+" + strings.Join(v.Build, ".")
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 if v has lower, equal or higher precedence than other.
+// Build metadata is ignored, as required by SemVer.
+func (v Version) Compare(other Version) int {
+	if c := compareUint(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, other.Patch); c != 0 {
+		return c
+	}
+
+	// a normal version has higher precedence than its pre-releases
+	switch {
+	case !v.IsPreRelease() && !other.IsPreRelease():
+		return 0
+	case !v.IsPreRelease():
+		return 1
+	case !other.IsPreRelease():
+		return -1
+	}
+	for i := 0; i < len(v.PreRelease) && i < len(other.PreRelease); i++ {
+		if c := compareIdentifiers(v.PreRelease[i], other.PreRelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareUint(uint64(len(v.PreRelease)), uint64(len(other.PreRelease)))
+}
+
+// Compares two version tags by SemVer precedence, tags that aren't
+// valid versions have lower precedence than any version
+func CompareVersionTags(a, b string) int {
+	va, errA := ParseVersion(a)
+	vb, errB := ParseVersion(b)
+	switch {
+	case errA != nil && errB != nil:
+		return 0
+	case errA != nil:
+		return -1
+	case errB != nil:
+		return 1
+	}
+	return va.Compare(vb)
+}
+
+func splitIdentifiers(s string, isPreRelease bool) ([]string, error) {
+	identifiers := strings.Split(s, ".")
+	for _, identifier := range identifiers {
+		if identifier == "" {
+			return nil, fmt.Errorf("empty identifier")
+		}
+		for _, r := range identifier {
+			if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-') {
+				return nil, fmt.Errorf("invalid character %q in identifier %s", r, identifier)
+			}
+		}
+		// numeric pre-release identifiers are compared numerically, so leading zeros are ambiguous
+		if isPreRelease && isDigits(identifier) && !isNumericIdentifier(identifier) {
+			return nil, fmt.Errorf("numeric identifier %s has leading zeros", identifier)
+		}
+	}
+	return identifiers, nil
+}
+
+func compareIdentifiers(a, b string) int {
+	aNumeric, bNumeric := isDigits(a), isDigits(b)
+	switch {
+	case aNumeric && bNumeric:
+		// no leading zeros, so the longer number is bigger
+		if c := compareUint(uint64(len(a)), uint64(len(b))); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	case aNumeric:
+		return -1
+	case bNumeric:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// digits without leading zeros
+func isNumericIdentifier(s string) bool {
+	return isDigits(s) && (s == "0" || s[0] != '0')
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Buttons that can be added to Slack updates, handled by the /slack/interactions endpoint
+const (
+	// approve a pending deployment, or deploy a deferred one straight away
+	SlackActionDeploy = "deploy"
+	// reject a pending deployment, or drop a deferred one
+	SlackActionSkip     = "skip"
+	SlackActionRollback = "rollback"
+	SlackActionPause    = "pause"
+)
+
+// What a Slack button acts on, carried as the button's value
+type SlackActionTarget struct {
+	Repo string `json:"repo"`
+	Tag  string `json:"tag,omitempty"`
+	// set if the button decides a pending deployment
+	PendingID int64 `json:"pending_id,omitempty"`
+}
+
+// A reply to a Slack interaction or slash command
+type slackResponse struct {
+	Text            string `json:"text"`
+	ResponseType    string `json:"response_type,omitempty"`
+	ReplaceOriginal bool   `json:"replace_original"`
+}
+
+// Who sees a reply posted to a Slack response_url
+const (
+	// only the user who interacted or ran the command
+	SlackResponseEphemeral = "ephemeral"
+	SlackResponseInChannel = "in_channel"
+)
+
+// Replies to a Slack interaction or slash command through its response_url
+func RespondToSlack(ctx context.Context, responseURL, text, responseType string) error {
+	return postSlackJSON(ctx, responseURL, slackResponse{
+		Text:         text,
+		ResponseType: responseType,
+	})
+}
+
+// Replaces the message of a Slack interaction through its response_url,
+// which also drops its buttons
+func ReplaceSlackMessage(ctx context.Context, responseURL, text string) error {
+	return postSlackJSON(ctx, responseURL, slackResponse{
+		Text:            text,
+		ReplaceOriginal: true,
+	})
+}
+
+func postSlackJSON(ctx context.Context, url string, msg slackResponse) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("slack responded with status %s", resp.Status)
+	}
+	return nil
+}
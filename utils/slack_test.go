@@ -0,0 +1,33 @@
+//go:build unit
+// +build unit
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRespondToSlack(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	assert.Nil(t, RespondToSlack(context.Background(), server.URL, "Error: no", SlackResponseEphemeral))
+	assert.Equal(t, "ephemeral", received["response_type"])
+	assert.Equal(t, false, received["replace_original"])
+
+	received = nil
+	assert.Nil(t, ReplaceSlackMessage(context.Background(), server.URL, "done"))
+	assert.Equal(t, "done", received["text"])
+	assert.Equal(t, true, received["replace_original"])
+	assert.Nil(t, received["blocks"])
+}
@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+type TagPolicyType string
+
+const (
+	// semantic versions, e.g. v1.4.0 or 2.0.0-rc.1
+	SemverTagPolicy TagPolicyType = "semver"
+	// calendar versions, e.g. 2026.10.17 or 2026.10.17.2
+	CalverTagPolicy TagPolicyType = "calver"
+	// build numbers, e.g. 123 or v123
+	NumericTagPolicy TagPolicyType = "numeric"
+	// tags matching a pattern, ordered by its capture groups, e.g. release-123
+	RegexTagPolicy TagPolicyType = "regex"
+)
+
+// Decides which tags of a repository are releases, and how they are ordered
+// to pick the latest one. The zero value is the semver policy without pre-releases.
+type TagPolicy struct {
+	Type TagPolicyType
+	// for the regex policy, tags must match Pattern and are ordered by
+	// comparing the capture groups in CaptureOrder one by one
+	Pattern      *regexp.Regexp
+	CaptureOrder []int
+	// optional, tags must match Include and must not match Exclude
+	Include *regexp.Regexp
+	Exclude *regexp.Regexp
+	// for the semver policy, whether pre-releases can be the latest tag
+	IncludePreReleases bool
+	// for the semver policy, optional range the latest tag must be inside
+	Constraint *VersionConstraint
+}
+
+var calverRe = regexp.MustCompile(`^v?(\d{4}|\d{2})\.(\d{1,2})(\.\d+){0,2}$`)
+var numericRe = regexp.MustCompile(`^v?\d+$`)
+
+// Reads the tag policy of repoName from repo_map:
+// tag_policy, tag_pattern, tag_capture_order, tag_include, tag_exclude and include_prereleases
+func GetRepoTagPolicy(conf *viper.Viper, repoName string) (*TagPolicy, error) {
+	policy := TagPolicy{
+		Type:               TagPolicyType(strings.ToLower(GetRepoSetting(conf, repoName, "tag_policy"))),
+		IncludePreReleases: conf.GetBool(fmt.Sprintf("repo_map.%s.include_prereleases", repoName)),
+	}
+	if policy.Type == "" {
+		policy.Type = SemverTagPolicy
+	}
+
+	var err error
+	switch policy.Type {
+	case SemverTagPolicy, CalverTagPolicy, NumericTagPolicy:
+	case RegexTagPolicy:
+		pattern := GetRepoSetting(conf, repoName, "tag_pattern")
+		if pattern == "" {
+			return nil, fmt.Errorf("tag_pattern must be set for the regex tag policy of repo %s", repoName)
+		}
+		if policy.Pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid tag_pattern for repo %s: %v", repoName, err)
+		}
+		captureOrder := conf.GetStringSlice(fmt.Sprintf("repo_map.%s.tag_capture_order", repoName))
+		if policy.CaptureOrder, err = resolveCaptureOrder(policy.Pattern, captureOrder); err != nil {
+			return nil, fmt.Errorf("invalid tag_capture_order for repo %s: %v", repoName, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported tag_policy %s for repo %s", policy.Type, repoName)
+	}
+
+	if include := GetRepoSetting(conf, repoName, "tag_include"); include != "" {
+		if policy.Include, err = regexp.Compile(include); err != nil {
+			return nil, fmt.Errorf("invalid tag_include for repo %s: %v", repoName, err)
+		}
+	}
+	if exclude := GetRepoSetting(conf, repoName, "tag_exclude"); exclude != "" {
+		if policy.Exclude, err = regexp.Compile(exclude); err != nil {
+			return nil, fmt.Errorf("invalid tag_exclude for repo %s: %v", repoName, err)
+		}
+	}
+	return &policy, nil
+}
+
+// Maps capture group names or numbers to group numbers, defaulting to every group in order
+func resolveCaptureOrder(pattern *regexp.Regexp, captureOrder []string) ([]int, error) {
+	if len(captureOrder) == 0 {
+		order := []int{}
+		for i := 1; i <= pattern.NumSubexp(); i++ {
+			order = append(order, i)
+		}
+		return order, nil
+	}
+	order := []int{}
+	for _, capture := range captureOrder {
+		if i, err := strconv.Atoi(capture); err == nil {
+			if i < 1 || i > pattern.NumSubexp() {
+				return nil, fmt.Errorf("pattern has no capture group %d", i)
+			}
+			order = append(order, i)
+			continue
+		}
+		i := pattern.SubexpIndex(capture)
+		if i < 0 {
+			return nil, fmt.Errorf("pattern has no capture group named %s", capture)
+		}
+		order = append(order, i)
+	}
+	return order, nil
+}
+
+// Whether the include and exclude regexes allow tag, regardless of its format
+func (p *TagPolicy) allows(tag string) bool {
+	if p.Include != nil && !p.Include.MatchString(tag) {
+		return false
+	}
+	return p.Exclude == nil || !p.Exclude.MatchString(tag)
+}
+
+// Whether tag is a release that can be picked as the latest tag
+func (p *TagPolicy) IsReleaseTag(tag string) bool {
+	if !p.allows(tag) {
+		return false
+	}
+	switch p.Type {
+	case CalverTagPolicy:
+		return calverRe.MatchString(tag)
+	case NumericTagPolicy:
+		return numericRe.MatchString(tag)
+	case RegexTagPolicy:
+		return p.Pattern.MatchString(tag)
+	}
+	version, err := ParseVersion(tag)
+	if err != nil || (version.IsPreRelease() && !p.IncludePreReleases) {
+		return false
+	}
+	return p.Constraint == nil || p.Constraint.Check(version)
+}
+
+// Returns a copy of the policy that only accepts releases inside constraint
+func (p *TagPolicy) WithConstraint(constraint *VersionConstraint) *TagPolicy {
+	policy := *p
+	policy.Constraint = constraint
+	return &policy
+}
+
+// Returns -1, 0 or 1 if release tag a is older than, as recent as or newer than b
+func (p *TagPolicy) Compare(a, b string) int {
+	switch p.Type {
+	case CalverTagPolicy:
+		return compareNumberLists(splitNumbers(a), splitNumbers(b))
+	case NumericTagPolicy:
+		return compareNumbers(strings.TrimPrefix(a, "v"), strings.TrimPrefix(b, "v"))
+	case RegexTagPolicy:
+		return p.compareCaptures(a, b)
+	}
+	return CompareVersionTags(a, b)
+}
+
+func (p *TagPolicy) compareCaptures(a, b string) int {
+	aGroups := p.Pattern.FindStringSubmatch(a)
+	bGroups := p.Pattern.FindStringSubmatch(b)
+	switch {
+	case aGroups == nil && bGroups == nil:
+		return 0
+	case aGroups == nil:
+		return -1
+	case bGroups == nil:
+		return 1
+	}
+	for _, i := range p.CaptureOrder {
+		if c := compareNumbers(aGroups[i], bGroups[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compares numerically if both strings are numbers, lexically otherwise
+func compareNumbers(a, b string) int {
+	if isDigits(a) && isDigits(b) {
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if c := compareUint(uint64(len(a)), uint64(len(b))); c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+func splitNumbers(tag string) []string {
+	return strings.Split(strings.TrimPrefix(tag, "v"), ".")
+}
+
+// compares part by part, a list that is a prefix of the other is older
+func compareNumberLists(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareNumbers(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return compareUint(uint64(len(a)), uint64(len(b)))
+}
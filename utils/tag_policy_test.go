@@ -0,0 +1,94 @@
+//go:build unit
+// +build unit
+
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+const tagPolicyTestConfig = `
+[repo_map.semver]
+include_prereleases = true
+
+[repo_map.calver]
+tag_policy = "calver"
+
+[repo_map.numeric]
+tag_policy = "numeric"
+tag_exclude = "^v"
+
+[repo_map.release]
+tag_policy = "regex"
+tag_pattern = "^release-(\\d+)$"
+
+[repo_map.ordered]
+tag_policy = "regex"
+tag_pattern = "^(?P<branch>[a-z]+)-(?P<build>\\d+)-(?P<date>\\d{8})$"
+tag_capture_order = ["date", "build"]
+tag_include = "^main-"
+
+[repo_map.nopattern]
+tag_policy = "regex"
+
+[repo_map.unknown]
+tag_policy = "alphabetical"
+`
+
+func readTagPolicyTestConfig(t *testing.T) *viper.Viper {
+	conf := viper.New()
+	conf.SetConfigType("toml")
+	if err := conf.ReadConfig(strings.NewReader(tagPolicyTestConfig)); err != nil {
+		t.Fatalf("couldn't read config: %v", err)
+	}
+	return conf
+}
+
+func TestGetLatestReleaseTagWithPolicy(t *testing.T) {
+	conf := readTagPolicyTestConfig(t)
+	cases := []struct {
+		repoName string
+		tags     []string
+		Expected string
+	}{
+		{"semver", []string{"v1.0.0", "v1.1.0-rc.1", "latest"}, "v1.1.0-rc.1"},
+		{"default", []string{"v1.0.0", "v1.1.0-rc.1", "latest"}, "v1.0.0"},
+		{"calver", []string{"2026.9.30", "2026.10.1", "2026.10.1.2", "2025.12.31", "v1.0.0"}, "2026.10.1.2"},
+		{"numeric", []string{"99", "100", "v200", "latest"}, "100"},
+		{"release", []string{"release-99", "release-123", "release-45", "v9.9.9"}, "release-123"},
+		{"ordered", []string{"main-9-20261017", "main-10-20261016", "main-2-20261017", "dev-99-20261231"}, "main-9-20261017"},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s %s", tc.repoName, tc.tags), func(t *testing.T) {
+			policy, err := GetRepoTagPolicy(conf, tc.repoName)
+			assert.Nil(t, err)
+			tag, err := GetLatestReleaseTag(tc.tags, policy)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.Expected, tag)
+		})
+	}
+}
+
+func TestGetRepoTagPolicyErrors(t *testing.T) {
+	conf := readTagPolicyTestConfig(t)
+	for _, repoName := range []string{"nopattern", "unknown"} {
+		_, err := GetRepoTagPolicy(conf, repoName)
+		assert.NotNil(t, err, repoName)
+	}
+}
+
+func TestFilterSHATags(t *testing.T) {
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	tags := []string{"v1.0.0", sha}
+	assert.Equal(t, []string{"v1.0.0"}, FilterSHATags(tags, &TagPolicy{}))
+
+	// repos that release commit SHA tags can keep them by including them explicitly
+	policy := &TagPolicy{Include: regexp.MustCompile("^[0-9a-f]{40}$")}
+	assert.Equal(t, tags, FilterSHATags(tags, policy))
+}
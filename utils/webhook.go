@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Header carrying the hex encoded HMAC-SHA256 of the body of the requests
+// registrywatcher sends to webhooks, keyed by the webhook's secret
+const WebhookSignatureHeader = "X-Registrywatcher-Signature"
+
+// Returns the signature header value of payload, in the form sha256=<hex digest>
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
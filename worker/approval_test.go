@@ -0,0 +1,50 @@
+//go:build integration
+
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dsaidgovsg/registrywatcher/client"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Test that a check expires the pending deployments that weren't approved in time.
+*/
+func TestExpirePendingDeployments(t *testing.T) {
+	te := client.SetUpClientTest(t)
+	defer te.TearDown()
+	ww := InitializeWatcherWorker(te.Conf, PollSchedule{}, te.TestRepoName, te.Clients)
+	ctx := context.Background()
+
+	expiredID, err := te.Clients.PostgresClient.InsertPendingDeployment(ctx, client.PendingDeploymentRow{
+		RepositoryName: te.TestRepoName,
+		Tag:            "v1.0.0",
+		Trigger:        string(client.DeployTriggerAuto),
+		CreatedAt:      time.Now().Add(-2 * time.Hour),
+		ExpiresAt:      time.Now().Add(-time.Hour),
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, ww.expirePendingDeployments(ctx))
+	pending, err := te.Clients.PostgresClient.GetPendingDeployment(ctx, expiredID)
+	assert.Nil(t, err)
+	assert.Equal(t, client.PendingDeploymentExpired, pending.Status)
+	assert.NotNil(t, pending.DecidedAt)
+
+	// not expired yet
+	pendingID, err := te.Clients.PostgresClient.InsertPendingDeployment(ctx, client.PendingDeploymentRow{
+		RepositoryName: te.TestRepoName,
+		Tag:            "v1.0.0",
+		Trigger:        string(client.DeployTriggerAuto),
+		CreatedAt:      time.Now(),
+		ExpiresAt:      time.Now().Add(time.Hour),
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, ww.expirePendingDeployments(ctx))
+	pending, err = te.Clients.PostgresClient.GetPendingDeployment(ctx, pendingID)
+	assert.Nil(t, err)
+	assert.Equal(t, client.PendingDeploymentPending, pending.Status)
+}
@@ -0,0 +1,42 @@
+//go:build integration
+
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dsaidgovsg/registrywatcher/client"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Test that only one of the replicas watching a repository leads it, and that
+another one takes over once the leader gives up.
+*/
+func TestAcquireLeadership(t *testing.T) {
+	te := client.SetUpClientTest(t)
+	defer te.TearDown()
+	te.Conf.Set("leader_election", true)
+
+	ctx := context.Background()
+	// replicas share the database, but each has its own worker
+	leader := InitializeWatcherWorker(te.Conf, PollSchedule{}, te.TestRepoName, te.Clients)
+	follower := InitializeWatcherWorker(te.Conf, PollSchedule{}, te.TestRepoName, te.Clients)
+
+	assert.True(t, leader.acquireLeadership(ctx))
+	assert.True(t, leader.IsLeader())
+	assert.False(t, follower.acquireLeadership(ctx))
+	assert.False(t, follower.IsLeader())
+
+	// leadership is kept while the lock is held
+	assert.True(t, leader.acquireLeadership(ctx))
+	assert.False(t, follower.acquireLeadership(ctx))
+
+	leader.releaseLeadership()
+	assert.False(t, leader.IsLeader())
+	assert.True(t, follower.acquireLeadership(ctx))
+	assert.True(t, follower.IsLeader())
+	assert.False(t, leader.acquireLeadership(ctx))
+	follower.releaseLeadership()
+}
@@ -0,0 +1,76 @@
+package worker
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/dsaidgovsg/registrywatcher/utils"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultPollJitter     = 0.1
+	defaultPollMaxBackoff = 30 * time.Minute
+)
+
+// How often a worker checks its repository for updates
+type PollSchedule struct {
+	Interval time.Duration
+	// fraction of the delay it is randomly moved by, e.g. 0.1 for ±10%,
+	// so that workers started together don't poll together
+	Jitter float64
+	// upper bound of the delay while checks keep failing
+	MaxBackoff time.Duration
+}
+
+// Reads the poll schedule of repoName. poll_interval in repo_map overrides
+// the global poll_interval, poll_jitter and poll_max_backoff are global.
+func GetPollSchedule(conf *viper.Viper, repoName string) (PollSchedule, error) {
+	schedule := PollSchedule{
+		Jitter:     defaultPollJitter,
+		MaxBackoff: defaultPollMaxBackoff,
+	}
+
+	interval := utils.GetRepoSetting(conf, repoName, "poll_interval")
+	if interval == "" {
+		interval = conf.GetString("poll_interval")
+	}
+	var err error
+	if schedule.Interval, err = time.ParseDuration(interval); err != nil {
+		return schedule, fmt.Errorf("invalid poll_interval for repo %s: %v", repoName, err)
+	}
+	if schedule.Interval <= 0 {
+		return schedule, fmt.Errorf("poll_interval for repo %s must be positive", repoName)
+	}
+
+	if conf.IsSet("poll_jitter") {
+		schedule.Jitter = conf.GetFloat64("poll_jitter")
+		if schedule.Jitter < 0 || schedule.Jitter >= 1 {
+			return schedule, fmt.Errorf("poll_jitter must be at least 0 and less than 1, got %v", schedule.Jitter)
+		}
+	}
+	if maxBackoff := conf.GetString("poll_max_backoff"); maxBackoff != "" {
+		if schedule.MaxBackoff, err = time.ParseDuration(maxBackoff); err != nil {
+			return schedule, fmt.Errorf("invalid poll_max_backoff: %v", err)
+		}
+	}
+	return schedule, nil
+}
+
+/*
+ * Returns the delay before the next check after failures consecutive failed
+ * checks. The interval doubles with every failure up to MaxBackoff, and is
+ * then moved by up to Jitter of itself, r being a random number in [0, 1).
+ */
+func (s PollSchedule) Delay(failures int, r float64) time.Duration {
+	delay := s.Interval
+	if failures > 0 {
+		backoff := float64(s.Interval) * math.Pow(2, float64(failures))
+		delay = time.Duration(math.Min(backoff, float64(s.MaxBackoff)))
+		if delay < s.Interval {
+			delay = s.Interval
+		}
+	}
+	return time.Duration(float64(delay) * (1 + s.Jitter*(2*r-1)))
+}
@@ -0,0 +1,65 @@
+//go:build unit
+// +build unit
+
+package worker
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPollSchedule(t *testing.T) {
+	conf := viper.New()
+	conf.SetConfigType("toml")
+	err := conf.ReadConfig(strings.NewReader(`
+poll_interval = "1m"
+poll_jitter = 0.2
+poll_max_backoff = "10m"
+
+[repo_map.fast]
+poll_interval = "10s"
+
+[repo_map.broken]
+poll_interval = "soon"
+`))
+	assert.Nil(t, err)
+
+	schedule, err := GetPollSchedule(conf, "fast")
+	assert.Nil(t, err)
+	assert.Equal(t, PollSchedule{Interval: 10 * time.Second, Jitter: 0.2, MaxBackoff: 10 * time.Minute}, schedule)
+
+	schedule, err = GetPollSchedule(conf, "default")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute, schedule.Interval)
+
+	_, err = GetPollSchedule(conf, "broken")
+	assert.NotNil(t, err)
+}
+
+func TestPollScheduleDelay(t *testing.T) {
+	schedule := PollSchedule{Interval: time.Minute, MaxBackoff: 5 * time.Minute}
+	cases := []struct {
+		failures int
+		Expected time.Duration
+	}{
+		{0, time.Minute},
+		{1, 2 * time.Minute},
+		{2, 4 * time.Minute},
+		{3, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%d failures", tc.failures), func(t *testing.T) {
+			assert.Equal(t, tc.Expected, schedule.Delay(tc.failures, 0.5))
+		})
+	}
+
+	schedule.Jitter = 0.1
+	assert.Equal(t, 54*time.Second, schedule.Delay(0, 0))
+	assert.Equal(t, 66*time.Second, schedule.Delay(0, 1))
+}
@@ -1,68 +1,321 @@
 package worker
 
 import (
+	"context"
 	"fmt"
+	"math/rand"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dsaidgovsg/registrywatcher/client"
 	"github.com/dsaidgovsg/registrywatcher/log"
+	"github.com/dsaidgovsg/registrywatcher/notifier"
 	"github.com/dsaidgovsg/registrywatcher/utils"
 	"github.com/spf13/viper"
 )
 
+// Upper bound on a single check for updates, so a hung registry or database
+// can't stop a worker from polling, or from shutting down
+const checkTimeout = 5 * time.Minute
+
+// Progress of a worker's checks, failed checks delay the next one exponentially
+type BackoffState struct {
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastError           string    `json:"last_error"`
+	NextPoll            time.Time `json:"next_poll"`
+}
+
 type WatcherWorker struct {
-	conf         *viper.Viper
-	pollInterval time.Duration
-	repoName     string
-	clients      *client.Clients
+	conf     *viper.Viper
+	schedule PollSchedule
+	repoName string
+	clients  *client.Clients
+	// only used by the Run goroutine
+	rand *rand.Rand
+	// guards backoff, which is read by the API
+	mu      sync.Mutex
+	backoff BackoffState
+	// receives pushes notified by the registry, to check for updates before the next poll
+	trigger chan struct{}
+	// held while this replica is the leader for repoName, if leader_election is on
+	leaderLock *client.AdvisoryLock
+	// 1 while this replica is the leader for repoName
+	leading int32
 }
 
-func InitializeWatcherWorker(conf *viper.Viper, pollInterval time.Duration,
+func InitializeWatcherWorker(conf *viper.Viper, schedule PollSchedule,
 	repoName string, clients *client.Clients) *WatcherWorker {
 	ww := WatcherWorker{
-		pollInterval: pollInterval,
-		conf:         conf,
-		repoName:     repoName,
-		clients:      clients,
+		schedule: schedule,
+		conf:     conf,
+		repoName: repoName,
+		clients:  clients,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		trigger:  make(chan struct{}, 1),
 	}
 	return &ww
 }
 
-func (ww *WatcherWorker) Run() {
+// Run polls for updates until ctx is done. A check that is in progress when
+// ctx is done is finished first, and the leader lock is released on return.
+func (ww *WatcherWorker) Run(ctx context.Context) {
+	defer ww.releaseLeadership()
 	ww.initialize()
 	for {
-		ww.runOnce()
-		time.Sleep(ww.pollInterval)
+		delay := ww.scheduleNextPoll(ww.check())
+		select {
+		case <-ctx.Done():
+			log.LogAppInfo(fmt.Sprintf("Stopped watching %s", ww.repoName))
+			return
+		case <-time.After(delay):
+		case <-ww.trigger:
+			log.LogAppInfo(fmt.Sprintf("Checking %s for updates after a push notification", ww.repoName))
+		}
 	}
 }
 
-func (ww *WatcherWorker) initialize() {
-	ww.clients.PopulateCaches(ww.repoName)
+// Checks for updates if this replica is the leader, otherwise refreshes the caches.
+// Returns the error of a failed check.
+func (ww *WatcherWorker) check() error {
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	if ww.acquireLeadership(ctx) {
+		return ww.runOnce(ctx)
+	}
+	// keep the caches fresh so the read-only API stays accurate
+	ww.clients.PopulateCaches(ctx, ww.repoName)
+	return nil
 }
 
-func (ww *WatcherWorker) runOnce() {
-	shouldDeploy, err := ww.clients.ShouldDeploy(ww.repoName)
-	if err != nil {
+// Records the result of a check and returns how long to wait before the next one
+func (ww *WatcherWorker) scheduleNextPoll(checkErr error) time.Duration {
+	ww.mu.Lock()
+	defer ww.mu.Unlock()
+	if checkErr == nil {
+		ww.backoff.ConsecutiveFailures = 0
+		ww.backoff.LastError = ""
+	} else {
+		ww.backoff.ConsecutiveFailures++
+		ww.backoff.LastError = checkErr.Error()
+	}
+	delay := ww.schedule.Delay(ww.backoff.ConsecutiveFailures, ww.rand.Float64())
+	ww.backoff.NextPoll = time.Now().Add(delay)
+	if checkErr != nil {
+		log.LogAppWarn(fmt.Sprintf("Checking %s for updates failed %d times in a row, next check in %s",
+			ww.repoName, ww.backoff.ConsecutiveFailures, delay.Round(time.Second)), checkErr)
+	}
+	return delay
+}
+
+// BackoffState returns the result of the worker's recent checks and when it checks next
+func (ww *WatcherWorker) BackoffState() BackoffState {
+	ww.mu.Lock()
+	defer ww.mu.Unlock()
+	return ww.backoff
+}
+
+// Trigger makes the worker check for updates without waiting for the poll interval.
+// Triggers received while a check is pending are coalesced. With leader election,
+// a replica that isn't the leader also forwards the trigger to the leader.
+func (ww *WatcherWorker) Trigger(ctx context.Context) {
+	ww.triggerCheck()
+	if !ww.conf.GetBool("leader_election") || ww.IsLeader() {
 		return
 	}
-	originalTag, err := ww.clients.GetFormattedPinnedTag(ww.repoName)
-	if err != nil || !shouldDeploy {
+	if err := ww.clients.PostgresClient.NotifyPush(ctx, ww.repoName); err != nil {
+		log.LogAppErr(fmt.Sprintf("Couldn't forward push notification for %s to the leader", ww.repoName), err)
+	}
+}
+
+func (ww *WatcherWorker) triggerCheck() {
+	select {
+	case ww.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// ListenForReplicas triggers the workers this replica is the leader of when
+// another replica forwards a push notification for them, and refreshes the
+// caches of the repositories another replica deployed, until ctx is done
+func ListenForReplicas(ctx context.Context, clients *client.Clients, workers map[string]*WatcherWorker) {
+	onPush := func(repoName string) {
+		ww, ok := workers[repoName]
+		if !ok || !ww.IsLeader() {
+			return
+		}
+		log.LogAppInfo(fmt.Sprintf("Received push notification for %s from another replica", repoName))
+		ww.triggerCheck()
+	}
+	onDeploy := func(repoName string) {
+		if _, ok := workers[repoName]; !ok {
+			return
+		}
+		refreshCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+		defer cancel()
+		clients.PopulateCaches(refreshCtx, repoName)
+	}
+	if err := clients.PostgresClient.ListenForReplicas(ctx, onPush, onDeploy); err != nil {
+		log.LogAppErr("Stopped listening for notifications from other replicas", err)
+	}
+}
+
+// IsLeader returns whether this replica deploys repoName
+func (ww *WatcherWorker) IsLeader() bool {
+	return atomic.LoadInt32(&ww.leading) == 1
+}
+
+// Returns whether this replica is the leader for repoName, taking the
+// repo's advisory lock if no other replica holds it. Without leader
+// election every replica is the leader.
+func (ww *WatcherWorker) acquireLeadership(ctx context.Context) bool {
+	if !ww.conf.GetBool("leader_election") {
+		atomic.StoreInt32(&ww.leading, 1)
+		return true
+	}
+	if ww.leaderLock != nil {
+		if ww.leaderLock.IsHeld(ctx) {
+			return true
+		}
+		log.LogAppWarn(fmt.Sprintf("Lost leadership for %s", ww.repoName), nil)
+		ww.leaderLock.Release()
+		ww.leaderLock = nil
+		atomic.StoreInt32(&ww.leading, 0)
+	}
+
+	lock, err := ww.clients.PostgresClient.TryAdvisoryLock(ctx, fmt.Sprintf("registrywatcher/%s", ww.repoName))
+	if err != nil {
+		log.LogAppErr(fmt.Sprintf("Couldn't take leader lock for %s", ww.repoName), err)
+		return false
+	}
+	if lock == nil {
+		return false
+	}
+	log.LogAppInfo(fmt.Sprintf("Became leader for %s", ww.repoName))
+	ww.leaderLock = lock
+	atomic.StoreInt32(&ww.leading, 1)
+	return true
+}
+
+// Gives up leadership so that another replica can take over
+func (ww *WatcherWorker) releaseLeadership() {
+	if ww.leaderLock == nil {
 		return
 	}
+	if err := ww.leaderLock.Release(); err != nil {
+		log.LogAppErr(fmt.Sprintf("Couldn't release leader lock for %s", ww.repoName), err)
+	}
+	ww.leaderLock = nil
+	atomic.StoreInt32(&ww.leading, 0)
+}
+
+func (ww *WatcherWorker) initialize() {
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	ww.clients.PopulateCaches(ctx, ww.repoName)
+}
+
+/*
+ * Deploys the pinned tag if it changed, returns an error if that couldn't be
+ * checked. Outside the repository's deployment windows the deployment is
+ * deferred instead, and made by the first check once the window opens.
+ */
+func (ww *WatcherWorker) runOnce(ctx context.Context) error {
+	// fetched before ShouldDeploy refreshes the tags cache, so a changed
+	// pinned tag value tells a new release apart from a changed digest
+	originalTag, _ := ww.clients.GetFormattedPinnedTag(ctx, ww.repoName)
+	shouldDeploy, err := ww.clients.ShouldDeploy(ctx, ww.repoName)
+	if err != nil {
+		return err
+	}
+	if err = ww.expirePendingDeployments(ctx); err != nil {
+		return err
+	}
+	deferredTrigger, err := ww.clients.PostgresClient.GetDeferredTrigger(ctx, ww.repoName)
+	if err != nil {
+		return err
+	}
+	if !shouldDeploy && deferredTrigger == "" {
+		return nil
+	}
 
-	// quick way to determine if SHA changes prompted the auto deployment
-	// proper way is to compare the docker content digest inside ./clients/nomad_client.go
-	tagToDeploy, err := ww.clients.GetFormattedPinnedTag(ww.repoName)
+	tagToDeploy, err := ww.clients.GetFormattedPinnedTag(ctx, ww.repoName)
 	if err != nil {
 		log.LogAppErr(fmt.Sprintf("Couldn't fetch formatted pinned tag to post slack update for %s", ww.repoName), err)
-		return
-	} else if tagToDeploy == originalTag {
-		utils.PostSlackUpdate(ww.conf, fmt.Sprintf("Update: the SHA of tag `%s` in `%s` changed. Auto deployment will happen shortly.", tagToDeploy, ww.repoName))
+		return err
+	}
+	trigger := client.DeployTrigger(deferredTrigger)
+	if shouldDeploy {
+		trigger = client.DeployTriggerAuto
+		if tagToDeploy == originalTag {
+			trigger = client.DeployTriggerDigestChange
+		}
+	} else if autoDeploy, err := ww.clients.PostgresClient.GetAutoDeployFlag(ctx, ww.repoName); err != nil {
+		return err
+	} else if !autoDeploy {
+		// auto deployment was turned off while the deployment was deferred
+		log.LogAppInfo(fmt.Sprintf("Dropping deferred deployment of %s since auto deployment is off", ww.repoName))
+		return ww.clients.PostgresClient.UpdateDeferredTrigger(ctx, ww.repoName, "")
+	}
+
+	if shouldDeploy && utils.GetRepoRequireApproval(ww.conf, ww.repoName) {
+		return ww.requestApproval(ctx, tagToDeploy, trigger)
+	}
+
+	allowed, reason, err := ww.clients.CheckDeploymentWindow(ctx, ww.repoName, time.Now())
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		if !shouldDeploy {
+			return nil
+		}
+		log.LogAppInfo(fmt.Sprintf("Deferring deployment of tag %s for repo %s: %s", tagToDeploy, ww.repoName, reason))
+		if err = ww.clients.PostgresClient.UpdateDeferredTrigger(ctx, ww.repoName, string(trigger)); err != nil {
+			return err
+		}
+		notifier.PostEvent(ww.conf, notifier.EventDeferred,
+			notifier.EventData{Repo: ww.repoName, Tag: tagToDeploy, Trigger: string(trigger), Description: reason},
+			utils.SlackActionDeploy, utils.SlackActionSkip, utils.SlackActionPause)
+		return nil
+	}
+
+	if !shouldDeploy {
+		notifier.PostEvent(ww.conf, notifier.EventWindowOpened, notifier.EventData{Repo: ww.repoName, Tag: tagToDeploy, Trigger: string(trigger)})
+	} else if trigger == client.DeployTriggerDigestChange {
+		notifier.PostEvent(ww.conf, notifier.EventDigestChanged, notifier.EventData{Repo: ww.repoName, Tag: tagToDeploy, Trigger: string(trigger)})
+	} else {
+		notifier.PostEvent(ww.conf, notifier.EventDetected, notifier.EventData{Repo: ww.repoName, Tag: tagToDeploy, PreviousTag: originalTag, Trigger: string(trigger)})
 	}
 
 	log.LogAppInfo(fmt.Sprintf("Auto deploying tag %s for repo %s", tagToDeploy, ww.repoName))
 	if _, ok := os.LookupEnv("DEBUG"); !ok {
-		ww.clients.DeployPinnedTag(ww.conf, ww.repoName)
+		ww.clients.DeployPinnedTag(ctx, ww.conf, ww.repoName, trigger, "")
+	}
+	return nil
+}
+
+// Holds the deployment of tag until it is approved through the API
+func (ww *WatcherWorker) requestApproval(ctx context.Context, tag string, trigger client.DeployTrigger) error {
+	pending, err := ww.clients.RequestApproval(ctx, ww.conf, ww.repoName, tag, trigger)
+	if err != nil {
+		return err
+	}
+	log.LogAppInfo(fmt.Sprintf("Deployment %d of tag %s for repo %s is waiting for approval", pending.ID, tag, ww.repoName))
+	notifier.PostEvent(ww.conf, notifier.EventApprovalRequested, pending.EventData(),
+		utils.SlackActionDeploy, utils.SlackActionSkip, utils.SlackActionPause)
+	return nil
+}
+
+func (ww *WatcherWorker) expirePendingDeployments(ctx context.Context) error {
+	expired, err := ww.clients.PostgresClient.ExpirePendingDeployments(ctx, ww.repoName, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, pending := range expired {
+		log.LogAppInfo(fmt.Sprintf("Deployment %d of tag %s for repo %s expired without approval", pending.ID, pending.Tag, ww.repoName))
+		notifier.PostEvent(ww.conf, notifier.EventExpired, pending.EventData())
 	}
+	return nil
 }
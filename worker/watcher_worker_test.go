@@ -0,0 +1,23 @@
+//go:build unit
+// +build unit
+
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrigger(t *testing.T) {
+	ww := InitializeWatcherWorker(viper.New(), PollSchedule{}, "api", nil)
+	assert.Equal(t, 0, len(ww.trigger))
+
+	ww.Trigger(context.Background())
+	assert.Equal(t, 1, len(ww.trigger))
+	// coalesced with the pending trigger
+	ww.Trigger(context.Background())
+	assert.Equal(t, 1, len(ww.trigger))
+}